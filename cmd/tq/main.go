@@ -15,11 +15,10 @@ var (
 )
 
 func main() {
-	if err := cli.Execute(version, commit, date); err != nil {
-		if errors.Is(err, cli.ErrExitWithStatus) {
-			os.Exit(1)
-		}
+	err := cli.Execute(version, commit, date)
+	var exit *cli.ExitError
+	if err != nil && !(errors.As(err, &exit) && exit.Err == nil) {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
 	}
+	os.Exit(cli.ExitCode(err))
 }
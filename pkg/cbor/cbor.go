@@ -0,0 +1,405 @@
+// Package cbor reads and writes CBOR (RFC 8949) as the generic values used
+// across tq. Integers decode to int64 (uint64 above the int64 range) and
+// floats of every width to float64, so the two stay distinct; byte strings
+// decode to []byte and datetimes (tags 0 and 1) to time.Time. Other tags
+// are dropped in favor of their content, and undefined decodes as null.
+package cbor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Major types
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Tags with a meaning here
+const (
+	tagDatetime  = 0
+	tagEpoch     = 1
+	tagPosBignum = 2
+	tagNegBignum = 3
+)
+
+// maxDepth bounds the nesting of arrays, maps and tags in decoded input
+const maxDepth = 10000
+
+// errBreak is returned for the "break" stop code ending an indefinite-length item
+var errBreak = errors.New("cbor: unexpected break")
+
+// Decoder reads a sequence of CBOR data items (RFC 8742)
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder returns a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next data item into v, which must be a *interface{}. It
+// returns io.EOF when the sequence ends between items.
+func (d *Decoder) Decode(v interface{}) error {
+	ptr, ok := v.(*interface{})
+	if !ok {
+		return fmt.Errorf("cbor: Decode requires *interface{}, got %T", v)
+	}
+	if _, err := d.r.Peek(1); err != nil {
+		return err
+	}
+	value, err := d.value(0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	*ptr = value
+	return nil
+}
+
+// bytes reads n bytes, growing the buffer as data arrives rather than
+// trusting a length read from the input
+func (d *Decoder) bytes(n uint64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// head reads an initial byte and its argument. indefinite is set for
+// additional information 31.
+func (d *Decoder) head() (major byte, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info <= 27:
+		data, err := d.bytes(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, false, err
+		}
+		for _, c := range data {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, info, arg, false, nil
+	case info == 31 && major != majorUint && major != majorNegInt && major != majorTag:
+		return major, info, 0, true, nil
+	}
+	return 0, 0, 0, false, fmt.Errorf("cbor: invalid additional information %d for major type %d", info, major)
+}
+
+func (d *Decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor: nesting too deep")
+	}
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: negative integer -1-%d out of range", arg)
+		}
+		return -1 - int64(arg), nil
+	case majorBytes, majorText:
+		data, err := d.str(major, arg, indefinite)
+		if err != nil {
+			return nil, err
+		}
+		if major == majorBytes {
+			return data, nil
+		}
+		if !utf8.Valid(data) {
+			return nil, errors.New("cbor: invalid UTF-8 in text string")
+		}
+		return string(data), nil
+	case majorArray:
+		items := make([]interface{}, 0, min(arg, 1024))
+		for i := uint64(0); indefinite || i < arg; i++ {
+			item, err := d.value(depth + 1)
+			if err == errBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case majorMap:
+		obj := make(map[string]interface{}, min(arg, 1024))
+		for i := uint64(0); indefinite || i < arg; i++ {
+			key, err := d.value(depth + 1)
+			if err == errBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := key.(string); ok {
+				obj[s] = value
+			} else {
+				obj[fmt.Sprint(key)] = value
+			}
+		}
+		return obj, nil
+	case majorTag:
+		content, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return tagged(arg, content)
+	}
+	return simple(info, arg, indefinite)
+}
+
+// str reads a byte or text string, joining the chunks of an indefinite one
+func (d *Decoder) str(major byte, n uint64, indefinite bool) ([]byte, error) {
+	if !indefinite {
+		return d.bytes(n)
+	}
+	var data []byte
+	for {
+		m, _, arg, chunked, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m == majorSimple && chunked {
+			return data, nil
+		}
+		if m != major || chunked {
+			return nil, errors.New("cbor: invalid chunk in indefinite-length string")
+		}
+		chunk, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+	}
+}
+
+// tagged interprets the content of a tag
+func tagged(tag uint64, content interface{}) (interface{}, error) {
+	switch tag {
+	case tagDatetime:
+		s, ok := content.(string)
+		if !ok {
+			return nil, errors.New("cbor: datetime tag on a non-string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("cbor: invalid datetime %q", s)
+		}
+		return t, nil
+	case tagEpoch:
+		switch n := content.(type) {
+		case int64:
+			return time.Unix(n, 0).UTC(), nil
+		case float64:
+			sec, frac := math.Modf(n)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("cbor: epoch tag on a non-number")
+	case tagPosBignum, tagNegBignum:
+		data, ok := content.([]byte)
+		if !ok {
+			return nil, errors.New("cbor: bignum tag on a non-byte string")
+		}
+		n := new(big.Int).SetBytes(data)
+		if tag == tagNegBignum {
+			n.Neg(n).Sub(n, big.NewInt(1))
+		}
+		switch {
+		case n.IsInt64():
+			return n.Int64(), nil
+		case n.IsUint64():
+			return n.Uint64(), nil
+		}
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, nil
+	}
+	return content, nil
+}
+
+// simple decodes major type 7: simple values, floats and break
+func simple(info byte, arg uint64, indefinite bool) (interface{}, error) {
+	switch {
+	case indefinite:
+		return nil, errBreak
+	case info == 20:
+		return false, nil
+	case info == 21:
+		return true, nil
+	case info == 22, info == 23:
+		return nil, nil
+	case info == 25:
+		return halfToFloat(uint16(arg)), nil
+	case info == 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case info == 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+}
+
+// halfToFloat converts an IEEE 754 half-precision float
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// Encode writes v as CBOR. Integers use the smallest head, float64 is
+// always written as a 64-bit float, map keys are sorted, and time.Time is
+// written as an RFC 3339 datetime (tag 0).
+func Encode(v interface{}) ([]byte, error) {
+	e := &encoder{}
+	if err := e.value(v); err != nil {
+		return nil, err
+	}
+	return e.b, nil
+}
+
+type encoder struct {
+	b []byte
+}
+
+// head writes an initial byte with the smallest encoding of arg
+func (e *encoder) head(major byte, arg uint64) {
+	m := major << 5
+	switch {
+	case arg < 24:
+		e.b = append(e.b, m|byte(arg))
+	case arg <= math.MaxUint8:
+		e.b = append(e.b, m|24, byte(arg))
+	case arg <= math.MaxUint16:
+		e.b = append(e.b, m|25, byte(arg>>8), byte(arg))
+	case arg <= math.MaxUint32:
+		e.b = append(e.b, m|26, byte(arg>>24), byte(arg>>16), byte(arg>>8), byte(arg))
+	default:
+		e.b = append(e.b, m|27)
+		for i := 7; i >= 0; i-- {
+			e.b = append(e.b, byte(arg>>(8*i)))
+		}
+	}
+}
+
+func (e *encoder) int(n int64) {
+	if n >= 0 {
+		e.head(majorUint, uint64(n))
+	} else {
+		e.head(majorNegInt, uint64(-1-n))
+	}
+}
+
+func (e *encoder) value(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		e.b = append(e.b, 0xf6)
+	case bool:
+		if val {
+			e.b = append(e.b, 0xf5)
+		} else {
+			e.b = append(e.b, 0xf4)
+		}
+	case int:
+		e.int(int64(val))
+	case int64:
+		e.int(val)
+	case uint64:
+		e.head(majorUint, val)
+	case float64:
+		bits := math.Float64bits(val)
+		e.b = append(e.b, 0xfb)
+		for i := 7; i >= 0; i-- {
+			e.b = append(e.b, byte(bits>>(8*i)))
+		}
+	case string:
+		e.head(majorText, uint64(len(val)))
+		e.b = append(e.b, val...)
+	case []byte:
+		e.head(majorBytes, uint64(len(val)))
+		e.b = append(e.b, val...)
+	case time.Time:
+		s := val.Format(time.RFC3339Nano)
+		e.head(majorTag, tagDatetime)
+		e.head(majorText, uint64(len(s)))
+		e.b = append(e.b, s...)
+	case []interface{}:
+		e.head(majorArray, uint64(len(val)))
+		for _, item := range val {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		e.head(majorMap, uint64(len(val)))
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.head(majorText, uint64(len(k)))
+			e.b = append(e.b, k...)
+			if err := e.value(val[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: cannot encode %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func decodeHex(t *testing.T, s string) interface{} {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad test vector %s: %v", s, err)
+	}
+	var v interface{}
+	if err := NewDecoder(bytes.NewReader(data)).Decode(&v); err != nil {
+		t.Fatalf("Decode(%s) failed: %v", s, err)
+	}
+	return v
+}
+
+// Vectors from RFC 8949, Appendix A
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		hex      string
+		expected interface{}
+	}{
+		{"00", int64(0)},
+		{"1818", int64(24)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"3903e7", int64(-1000)},
+		{"c249010000000000000000", 18446744073709551616.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f93e00", 1.5},
+		{"f97bff", 65504.0},
+		{"fa47c35000", 100000.0},
+		{"f4", false},
+		{"f6", nil},
+		{"f7", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"62c3bc", "ü"},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a26161016162820203", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"c11a514b67b0", time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9fff", []interface{}{}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"a201020304", map[string]interface{}{"1": int64(2), "3": int64(4)}},
+	}
+
+	for _, tt := range tests {
+		got := decodeHex(t, tt.hex)
+		if gotTime, ok := got.(time.Time); ok {
+			if !gotTime.Equal(tt.expected.(time.Time)) {
+				t.Errorf("Decode(%s) = %v, want %v", tt.hex, got, tt.expected)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Decode(%s) = %#v, want %#v", tt.hex, got, tt.expected)
+		}
+	}
+
+	if f := decodeHex(t, "f97c00").(float64); !math.IsInf(f, 1) {
+		t.Errorf("Expected +Inf, got %v", f)
+	}
+}
+
+func TestDecodeSequence(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte{0x01, 0x02}))
+	for _, want := range []int64{1, 2} {
+		var v interface{}
+		if err := d.Decode(&v); err != nil || v != want {
+			t.Fatalf("Expected %d, got %v (%v)", want, v, err)
+		}
+	}
+	var v interface{}
+	if err := d.Decode(&v); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, s := range []string{"1a0000", "ff", "62c3", "7f01ff", "1c", "3bffffffffffffffff"} {
+		data, _ := hex.DecodeString(s)
+		var v interface{}
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&v); err == nil || err == io.EOF {
+			t.Errorf("Decode(%s): expected error, got %v", s, err)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{int64(23), "17"},
+		{int64(1000000), "1a000f4240"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{-1, "20"},
+		{1.0, "fb3ff0000000000000"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2}, "420102"},
+		{map[string]interface{}{"b": []interface{}{int64(2), nil}, "a": true}, "a26161f5616282 02f6"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+	}
+
+	for _, tt := range tests {
+		data, err := Encode(tt.value)
+		if err != nil {
+			t.Fatalf("Encode(%v) failed: %v", tt.value, err)
+		}
+		want := bytes.ReplaceAll([]byte(tt.expected), []byte(" "), nil)
+		if got := hex.EncodeToString(data); got != string(want) {
+			t.Errorf("Encode(%v) = %s, want %s", tt.value, got, want)
+		}
+	}
+
+	if _, err := Encode(struct{}{}); err == nil {
+		t.Error("Expected error encoding an unsupported type")
+	}
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ssccio/tq/pkg/converter"
+)
+
+// namedFlags take a name and a value (--arg NAME VALUE), which pflag
+// cannot parse; they are taken out of the arguments before cobra sees them
+var namedFlags = map[string]string{
+	"--arg":       "Set $NAME to the string VALUE (--arg NAME VALUE)",
+	"--argjson":   "Set $NAME to the JSON text VALUE (--argjson NAME JSON)",
+	"--argtoon":   "Set $NAME to the TOON text VALUE (--argtoon NAME TOON)",
+	"--argyaml":   "Set $NAME to the YAML text VALUE (--argyaml NAME YAML)",
+	"--slurpfile": "Set $NAME to an array of the values in FILE, in its detected format (--slurpfile NAME FILE)",
+	"--rawfile":   "Set $NAME to the contents of FILE as a string (--rawfile NAME FILE)",
+}
+
+// namedArg is one use of a flag in namedFlags
+type namedArg struct {
+	flag  string
+	name  string
+	value string
+}
+
+// splitNamedArgs returns args without the flags in namedFlags, and those
+// flags in order. Arguments after -- are left alone.
+func splitNamedArgs(args []string) ([]string, []namedArg, error) {
+	var rest []string
+	var named []namedArg
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if _, ok := namedFlags[args[i]]; !ok {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+2 >= len(args) {
+			return nil, nil, fmt.Errorf("%s takes a name and a value", args[i])
+		}
+		named = append(named, namedArg{flag: args[i], name: args[i+1], value: args[i+2]})
+		i += 2
+	}
+	return rest, named, nil
+}
+
+// queryVariables returns the variables defined for the query: one per named
+// argument, $ARGS with "positional" and "named" arguments as in jq, and
+// $named as a shorthand for $ARGS.named. Positional arguments are strings,
+// or JSON texts with --jsonargs.
+func queryVariables(named []namedArg, positional []string, jsonPositional bool) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, arg := range named {
+		value, err := namedValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", arg.flag, arg.name, err)
+		}
+		values[arg.name] = value
+	}
+
+	args := make([]interface{}, 0, len(positional))
+	for _, text := range positional {
+		if !jsonPositional {
+			args = append(args, text)
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return nil, fmt.Errorf("--jsonargs: invalid JSON text %q: %w", text, err)
+		}
+		args = append(args, value)
+	}
+
+	vars := map[string]interface{}{
+		"ARGS":  map[string]interface{}{"positional": args, "named": values},
+		"named": values,
+	}
+	for name, value := range values {
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// namedValue reads the value of a named argument
+func namedValue(arg namedArg) (interface{}, error) {
+	switch arg.flag {
+	case "--arg":
+		return arg.value, nil
+	case "--argjson":
+		var value interface{}
+		if err := json.Unmarshal([]byte(arg.value), &value); err != nil {
+			return nil, fmt.Errorf("invalid JSON text: %w", err)
+		}
+		return value, nil
+	case "--argtoon", "--argyaml":
+		format := strings.TrimPrefix(arg.flag, "--arg")
+		return converter.New(converter.Options{InputFormat: format}).Read(strings.NewReader(arg.value))
+	case "--rawfile":
+		data, err := os.ReadFile(arg.value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	case "--slurpfile":
+		f, err := os.Open(arg.value)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		values, err := converter.New(converter.Options{InputFormat: "auto", Slurp: true}).FileValues(f, arg.value)
+		if err != nil {
+			return nil, err
+		}
+		all := make([]interface{}, 0)
+		for {
+			value, err := values.Next()
+			if err == io.EOF {
+				return all, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, value)
+		}
+	}
+	return nil, fmt.Errorf("unknown flag")
+}
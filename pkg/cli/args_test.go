@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ssccio/tq/pkg/query"
+)
+
+func TestSplitNamedArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		rest  []string
+		named []namedArg
+	}{
+		{
+			"none", []string{"-c", ".a", "file.json"},
+			[]string{"-c", ".a", "file.json"}, nil,
+		},
+		{
+			"in_order",
+			[]string{"--arg", "b", "2", "-c", "--argjson", "a", "1", ".", "--rawfile", "c", "x.txt", "file.json"},
+			[]string{"-c", ".", "file.json"},
+			[]namedArg{{"--arg", "b", "2"}, {"--argjson", "a", "1"}, {"--rawfile", "c", "x.txt"}},
+		},
+		{
+			"with_args", []string{"--args", "$ARGS", "--arg", "x", "y", "p1", "p2"},
+			[]string{"--args", "$ARGS", "p1", "p2"}, []namedArg{{"--arg", "x", "y"}},
+		},
+		{
+			"value_like_a_flag", []string{"--arg", "x", "--arg", "."},
+			[]string{"."}, []namedArg{{"--arg", "x", "--arg"}},
+		},
+		{
+			"after_double_dash", []string{".", "--", "--arg", "x", "y"},
+			[]string{".", "--", "--arg", "x", "y"}, nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, named, err := splitNamedArgs(tt.args)
+			if err != nil {
+				t.Fatalf("splitNamedArgs failed: %v", err)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("Expected arguments %q, got %q", tt.rest, rest)
+			}
+			if !reflect.DeepEqual(named, tt.named) {
+				t.Errorf("Expected named arguments %v, got %v", tt.named, named)
+			}
+		})
+	}
+
+	for _, args := range [][]string{{"--arg"}, {"--arg", "x"}, {".", "--slurpfile", "x"}} {
+		if _, _, err := splitNamedArgs(args); err == nil {
+			t.Errorf("Expected an error for %q", args)
+		}
+	}
+}
+
+func TestQueryVariables(t *testing.T) {
+	tests := []struct {
+		name       string
+		named      []namedArg
+		positional []string
+		json       bool
+		query      string
+		want       interface{}
+	}{
+		{"arg", []namedArg{{"--arg", "x", "1"}}, nil, false, "$x", "1"},
+		{"argjson", []namedArg{{"--argjson", "x", `{"a":[1,null]}`}}, nil, false, "$x.a",
+			[]interface{}{float64(1), nil}},
+		{"argtoon", []namedArg{{"--argtoon", "x", "a: 1\nb: hi"}}, nil, false, "$x.b", "hi"},
+		{"argyaml", []namedArg{{"--argyaml", "x", "a: [1, 2]"}}, nil, false, "$x.a[1]", 2},
+		{"later_wins", []namedArg{{"--arg", "x", "1"}, {"--arg", "x", "2"}}, nil, false, "$x", "2"},
+		{"named", []namedArg{{"--arg", "a", "1"}, {"--argjson", "b", "2"}}, nil, false, "$ARGS.named",
+			map[string]interface{}{"a": "1", "b": float64(2)}},
+		{"named_shorthand", []namedArg{{"--arg", "a", "1"}}, nil, false, "$named",
+			map[string]interface{}{"a": "1"}},
+		{"no_named", nil, nil, false, "$ARGS",
+			map[string]interface{}{"positional": []interface{}{}, "named": map[string]interface{}{}}},
+		{"args", nil, []string{"b", "1", "a"}, false, "$ARGS.positional",
+			[]interface{}{"b", "1", "a"}},
+		{"jsonargs", nil, []string{`"b"`, "1", `{"a":true}`, "null"}, true, "$ARGS.positional",
+			[]interface{}{"b", float64(1), map[string]interface{}{"a": true}, nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := queryVariables(tt.named, tt.positional, tt.json)
+			if err != nil {
+				t.Fatalf("queryVariables failed: %v", err)
+			}
+			engine := query.New()
+			for name, value := range vars {
+				engine.SetVariable(name, value)
+			}
+			got, err := engine.Execute(tt.query, nil)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+
+	bad := []struct {
+		name       string
+		named      []namedArg
+		positional []string
+		message    string
+	}{
+		{"argjson_invalid", []namedArg{{"--argjson", "x", "{bad"}}, nil, "--argjson x: invalid JSON text"},
+		{"argjson_empty", []namedArg{{"--argjson", "x", ""}}, nil, "--argjson x: invalid JSON text"},
+		{"argjson_trailing", []namedArg{{"--argjson", "x", "1 2"}}, nil, "--argjson x: invalid JSON text"},
+		{"jsonargs_invalid", nil, []string{"1", "two"}, `--jsonargs: invalid JSON text "two"`},
+		{"rawfile_missing", []namedArg{{"--rawfile", "x", filepath.Join(t.TempDir(), "missing")}}, nil, "--rawfile x:"},
+		{"slurpfile_missing", []namedArg{{"--slurpfile", "x", filepath.Join(t.TempDir(), "missing")}}, nil, "--slurpfile x:"},
+	}
+	for _, tt := range bad {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := queryVariables(tt.named, tt.positional, tt.positional != nil)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.message) {
+				t.Errorf("Expected an error starting with %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestNamedValueFiles(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		flag    string
+		file    string
+		content string
+		want    interface{}
+	}{
+		{"rawfile", "--rawfile", "notes.json", "{\"a\": 1}\n", "{\"a\": 1}\n"},
+		{"json", "--slurpfile", "values.json", `{"a":1} {"a":2}`,
+			[]interface{}{map[string]interface{}{"a": float64(1)}, map[string]interface{}{"a": float64(2)}}},
+		{"yaml", "--slurpfile", "values.yaml", "a: 1\n---\na: 2\n",
+			[]interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}}},
+		{"toml", "--slurpfile", "config.toml", "[server]\nport = 80\n",
+			[]interface{}{map[string]interface{}{"server": map[string]interface{}{"port": int64(80)}}}},
+		{"toon_by_content", "--slurpfile", "data", "users[2]{id,name}:\n  1,a\n  2,b\n",
+			[]interface{}{map[string]interface{}{"users": []interface{}{
+				map[string]interface{}{"id": int64(1), "name": "a"},
+				map[string]interface{}{"id": int64(2), "name": "b"},
+			}}}},
+		{"empty", "--slurpfile", "empty.json", "", []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(name, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := namedValue(namedArg{flag: tt.flag, name: "x", value: name})
+			if err != nil {
+				t.Fatalf("namedValue failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
@@ -11,15 +12,65 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/ssccio/tq/pkg/converter"
 	"github.com/ssccio/tq/pkg/query"
+	"github.com/ssccio/tq/pkg/toon"
 )
 
+// Exit statuses, as in jq. Errors that are not an *ExitError, such as usage
+// problems and unreadable input, exit with exitUsage.
+const (
+	exitFalse    = 1 // --exit-status: the last output was false or null
+	exitUsage    = 2 // Usage problem or system error
+	exitCompile  = 3 // The query does not parse
+	exitNoOutput = 4 // --exit-status: there was no output
+	exitRuntime  = 5 // The query failed on its input
+)
+
+// ExitError ends tq with exit status Code. Err is the error to report; an
+// ExitError without one exits quietly.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
 // ErrExitWithStatus is returned when exit-status flag is set and result is false/nil
-var ErrExitWithStatus = errors.New("exit with status 1")
+var ErrExitWithStatus = &ExitError{Code: exitFalse}
+
+// ErrNoOutput is returned when exit-status flag is set and there was no output
+var ErrNoOutput = &ExitError{Code: exitNoOutput}
+
+// ExitCode returns the exit status for an error returned by Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exit *ExitError
+	if errors.As(err, &exit) {
+		return exit.Code
+	}
+	return exitUsage
+}
 
 var (
 	inputFormat  string
 	outputFormat string
 	rawOutput    bool
+	joinOutput   bool
+	rawOutput0   bool
+	asciiOutput  bool
+	rawInput     bool
+	colorOutput  bool
+	monochrome   bool
 	compact      bool
 	slurp        bool
 	nullInput    bool
@@ -30,6 +81,38 @@ var (
 	delimiter    string
 	showStats    bool
 	showCompare  bool
+	foldKeys     bool
+	flattenDepth int
+	expandPaths  bool
+	pathConflict string
+	sparseTable  bool
+	sparseFill   string
+	lengthMarker bool
+	omitLengths  bool
+	blankLines   string
+	quoting      string
+	trailingNL   bool
+	streamRows   bool
+	stream       bool
+	streamErrors bool
+	seq          bool
+	skipBadLines bool
+	debugFormat  bool
+	columns      []string
+	csvHeader    bool
+	inferTypes   bool
+	tomlDates    string
+	xmlAttr      string
+	xmlText      string
+	xmlNS        string
+	xmlArrays    []string
+	binaryMode   string
+	inPlace      bool
+	backup       string
+	argsMode     bool
+	jsonArgs     bool
+	namedArgs    []namedArg
+	variables    map[string]interface{} // $name values for the query
 )
 
 func Execute(version, commit, date string) error {
@@ -52,34 +135,71 @@ readability and structure - perfect for LLM workflows.`,
 
   # Show token statistics
   tq -i json -o toon --stats data.json`,
-		Version: fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
-		RunE:    run,
-		SilenceUsage: true,
+		Version:       fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
+		RunE:          run,
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
 
 	// Input/Output flags
 	rootCmd.Flags().StringVarP(&inputFormat, "input-format", "i", "auto",
-		"Input format: auto, json, yaml, toon")
+		"Input format: auto, json, jsonl, yaml, toon, csv, tsv, toml, xml, ini, dotenv, properties, msgpack, cbor")
 	rootCmd.Flags().StringVarP(&outputFormat, "output-format", "o", "toon",
-		"Output format: toon, json, yaml")
+		"Output format: toon, json, jsonl, yaml, csv, tsv, toml, xml, ini, dotenv, properties, msgpack, cbor, markdown, html")
 
 	// Output options
 	rootCmd.Flags().BoolVarP(&rawOutput, "raw-output", "r", false,
-		"Output raw text, not TOON/JSON strings")
+		"Write strings as raw text, not quoted, in every output format")
+	rootCmd.Flags().BoolVarP(&joinOutput, "join-output", "j", false,
+		"Like -r, without a newline after each output")
+	rootCmd.Flags().BoolVar(&rawOutput0, "raw-output0", false,
+		"Like -r, with a NUL instead of a newline after each output")
+	rootCmd.Flags().BoolVarP(&asciiOutput, "ascii-output", "a", false,
+		"Escape non-ASCII characters as \\uXXXX in JSON output and raw strings")
 	rootCmd.Flags().BoolVarP(&compact, "compact-output", "c", false,
 		"Compact output (no pretty-printing)")
+	rootCmd.Flags().BoolVarP(&colorOutput, "color-output", "C", false,
+		"Color JSON, YAML and TOON output even when not writing to a terminal")
+	rootCmd.Flags().BoolVarP(&monochrome, "monochrome-output", "M", false,
+		"Do not color output")
 
 	// Input options
 	rootCmd.Flags().BoolVarP(&slurp, "slurp", "s", false,
 		"Read entire input into single array")
+	rootCmd.Flags().BoolVarP(&rawInput, "raw-input", "R", false,
+		"Read each line of input as a string; with -s, the whole input as one string")
 	rootCmd.Flags().BoolVarP(&nullInput, "null-input", "n", false,
 		"Don't read input, use null as input")
+	rootCmd.Flags().BoolVar(&streamRows, "stream-rows", false,
+		"Run the query on each row of a top-level TOON array, one row in memory at a time")
+	rootCmd.Flags().BoolVar(&stream, "stream", false,
+		"Parse input into [path, leaf] streaming events and run the query on each")
+	rootCmd.Flags().BoolVar(&streamErrors, "stream-errors", false,
+		"Like --stream, but report parse errors as a final [message, path] event")
+	rootCmd.Flags().BoolVar(&seq, "seq", false,
+		"Use application/json-seq: RS before each output, RS-separated JSON input")
+	rootCmd.Flags().BoolVar(&skipBadLines, "skip-bad-lines", false,
+		"Skip malformed JSONL input lines with a warning instead of failing")
+	rootCmd.Flags().BoolVar(&debugFormat, "debug-format", false,
+		"Print the format of each input and how it was detected to stderr")
+	rootCmd.Flags().BoolVarP(&inPlace, "in-place", "I", false,
+		"Write the output back to each input file, in its own format unless -o is given")
+	rootCmd.Flags().StringVar(&backup, "backup", "",
+		"With --in-place, keep a copy of each original file with this suffix")
 
 	// Query options
 	rootCmd.Flags().BoolVarP(&exitStatus, "exit-status", "e", false,
 		"Set exit code based on output")
 	rootCmd.Flags().StringVarP(&fromFile, "from-file", "f", "",
 		"Read query from file")
+	rootCmd.Flags().BoolVar(&argsMode, "args", false,
+		"Take the arguments after the query as strings for $ARGS.positional, not files")
+	rootCmd.Flags().BoolVar(&jsonArgs, "jsonargs", false,
+		"Take the arguments after the query as JSON texts for $ARGS.positional, not files")
+	// Listed for --help only: splitNamedArgs takes them out before parsing
+	for flag, usage := range namedFlags {
+		rootCmd.Flags().String(strings.TrimPrefix(flag, "--"), "", usage)
+	}
 
 	// TOON-specific options
 	rootCmd.Flags().IntVar(&indent, "indent", 2,
@@ -87,16 +207,77 @@ readability and structure - perfect for LLM workflows.`,
 	rootCmd.Flags().BoolVar(&useTab, "tab", false,
 		"Use tabs for indentation")
 	rootCmd.Flags().StringVar(&delimiter, "delimiter", ",",
-		"TOON delimiter character")
+		"TOON array delimiter: ',', 'tab', '|' or 'auto' (least quoting per array)")
+	rootCmd.Flags().BoolVar(&foldKeys, "fold-keys", false,
+		"Fold single-key object chains into dotted keys (a.b.c: value)")
+	rootCmd.Flags().IntVar(&flattenDepth, "flatten-depth", 0,
+		"Maximum segments in a folded key (0 = unlimited)")
+	rootCmd.Flags().BoolVar(&expandPaths, "expand-paths", false,
+		"Expand dotted keys into nested objects when reading TOON")
+	rootCmd.Flags().StringVar(&pathConflict, "path-collision", "error",
+		"On conflicting expanded paths: error or overwrite")
+	rootCmd.Flags().BoolVar(&sparseTable, "sparse-tabular", false,
+		"Tabulate arrays of objects with differing keys when shorter; read empty cells as absent")
+	rootCmd.Flags().StringVar(&sparseFill, "sparse-fill", "empty",
+		"Missing fields in sparse tables: empty or null")
+	rootCmd.Flags().BoolVar(&lengthMarker, "length-marker", false,
+		"Prefix TOON array lengths with '#' ([#3])")
+	rootCmd.Flags().BoolVar(&omitLengths, "omit-lengths", false,
+		"Leave array lengths out of TOON headers ([])")
+	rootCmd.Flags().StringVar(&blankLines, "blank-lines", "none",
+		"Blank lines between top-level TOON keys: none, all or blocks")
+	rootCmd.Flags().StringVar(&quoting, "quoting", "minimal",
+		"TOON and CSV string quoting: minimal or always")
+	rootCmd.Flags().BoolVar(&trailingNL, "trailing-newline", true,
+		"End TOON output with a newline")
+	// CSV/TSV options
+	rootCmd.Flags().StringSliceVar(&columns, "columns", nil,
+		"CSV/TSV, Markdown and HTML table columns, in order (default: every key, sorted)")
+	rootCmd.Flags().BoolVar(&csvHeader, "header", true,
+		"CSV/TSV has a header row; without it rows are arrays")
+	rootCmd.Flags().BoolVar(&inferTypes, "infer-types", true,
+		"Read CSV/TSV, INI, dotenv and properties numbers, booleans and null (or empty values) as typed values")
+
+	rootCmd.Flags().StringVar(&tomlDates, "toml-datetimes", "string",
+		"TOML datetimes: string, or tagged ({type, value} objects that convert back to datetimes)")
+
+	// XML options
+	rootCmd.Flags().StringVar(&xmlAttr, "xml-attr-prefix", "@",
+		"Prefix of keys holding XML attributes")
+	rootCmd.Flags().StringVar(&xmlText, "xml-text-key", "#text",
+		"Key holding the text of XML elements with attributes or children")
+	rootCmd.Flags().StringVar(&xmlNS, "xml-namespaces", "keep",
+		"XML namespaces: keep prefixes and xmlns attributes, or strip them")
+	rootCmd.Flags().StringArrayVar(&xmlArrays, "xml-array", nil,
+		"Always read the XML elements at this dotted path as an array (repeatable)")
+
+	// MessagePack/CBOR options
+	rootCmd.Flags().StringVar(&binaryMode, "binary", "base64",
+		"MessagePack/CBOR byte strings and timestamps in text output: base64 (RFC 3339 for timestamps) or tagged")
+
 	rootCmd.Flags().BoolVar(&showStats, "stats", false,
 		"Show token usage statistics (JSON vs TOON)")
 	rootCmd.Flags().BoolVar(&showCompare, "compare", false,
 		"Show format comparison (JSON/YAML/TOON sizes)")
 
+	args, named, err := splitNamedArgs(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	namedArgs = named
+	rootCmd.SetArgs(args)
+
 	return rootCmd.Execute()
 }
 
 func run(cmd *cobra.Command, args []string) error {
+	// Named argument flags only reach cobra as --arg=VALUE
+	for flag := range namedFlags {
+		if cmd.Flags().Changed(strings.TrimPrefix(flag, "--")) {
+			return fmt.Errorf("%s takes a name and a value: %s NAME VALUE", flag, flag)
+		}
+	}
+
 	// Parse query and input files
 	var queryStr string
 	var inputFiles []string
@@ -115,7 +296,7 @@ func run(cmd *cobra.Command, args []string) error {
 		inputFiles = args
 	} else if len(args) > 0 {
 		// If the first arg looks like a file path and exists, treat it as input file with default query
-		if len(args) == 1 && !strings.HasPrefix(args[0], ".") && !strings.HasPrefix(args[0], "[") {
+		if len(args) == 1 && !argsMode && !jsonArgs && !strings.HasPrefix(args[0], ".") && !strings.HasPrefix(args[0], "[") {
 			if _, err := os.Stat(args[0]); err == nil {
 				queryStr = "."
 				inputFiles = args
@@ -132,75 +313,317 @@ func run(cmd *cobra.Command, args []string) error {
 		queryStr = "."
 	}
 
-	// Determine input source
-	var input io.Reader
-	if nullInput {
-		input = nil
-	} else if len(inputFiles) == 0 {
-		input = os.Stdin
-	} else {
-		// For now, just use the first file
-		// TODO: Support multiple files
-		// Sanitize file path to prevent path traversal
-		cleanPath := filepath.Clean(inputFiles[0])
-		if strings.Contains(cleanPath, "..") {
-			return fmt.Errorf("invalid input file path: path traversal not allowed")
+	// With --args or --jsonargs, the arguments after the query are values
+	// for $ARGS.positional, and input is read from stdin
+	var positional []string
+	if argsMode || jsonArgs {
+		positional, inputFiles = inputFiles, nil
+	}
+	vars, err := queryVariables(namedArgs, positional, jsonArgs)
+	if err != nil {
+		return err
+	}
+	variables = vars
+
+	if delimiter == "tab" || delimiter == `\t` {
+		delimiter = toon.DelimiterTab
+	}
+
+	// Create converter
+	opts := converter.Options{
+		InputFormat:   inputFormat,
+		OutputFormat:  outputFormat,
+		Indent:        indent,
+		UseTab:        useTab,
+		Delimiter:     delimiter,
+		Compact:       compact,
+		RawOutput:     rawOutput,
+		JoinOutput:    joinOutput,
+		RawOutput0:    rawOutput0,
+		ASCIIOutput:   asciiOutput,
+		RawInput:      rawInput,
+		ShowStats:     showStats,
+		ShowCompare:   showCompare,
+		Slurp:         slurp,
+		StreamErrors:  streamErrors,
+		Seq:           seq,
+		SkipBadLines:  skipBadLines,
+		DebugFormat:   debugFormat,
+		MaxInputSize:  100 * 1024 * 1024, // 100MB default limit
+		KeyFolding:    foldKeys,
+		FlattenDepth:  flattenDepth,
+		ExpandPaths:   expandPaths,
+		PathCollision: pathConflict,
+		SparseTabular: sparseTable,
+		SparseFill:    sparseFill,
+		CSVColumns:    columns,
+		CSVNoHeader:   !csvHeader,
+		CSVNoInfer:    !inferTypes,
+		TOMLDatetimes: tomlDates,
+		XMLAttrPrefix: xmlAttr,
+		XMLTextKey:    xmlText,
+		XMLNamespaces: xmlNS,
+		XMLArrays:     xmlArrays,
+		Binary:        binaryMode,
+
+		LengthMarker:        lengthMarker,
+		OmitLengths:         omitLengths,
+		BlankLines:          blankLines,
+		Quoting:             quoting,
+		OmitTrailingNewline: !trailingNL,
+	}
+
+	if inPlace {
+		if nullInput || streamRows || stream || streamErrors {
+			return fmt.Errorf("--in-place cannot be used with --null-input or streaming modes")
+		}
+		if len(inputFiles) == 0 {
+			return fmt.Errorf("--in-place needs input files")
 		}
-		f, err := os.Open(cleanPath)
+		opts.KeepFormat = !cmd.Flags().Changed("output-format")
+		return runInPlace(opts, queryStr, inputFiles)
+	}
+	if backup != "" {
+		return fmt.Errorf("--backup can only be used with --in-place")
+	}
+
+	if useColor() {
+		opts.Color = true
+		palette, err := converter.ParsePalette(os.Getenv("TQ_COLORS"))
 		if err != nil {
-			return fmt.Errorf("failed to open input file: %w", err)
+			fmt.Fprintf(os.Stderr, "tq: ignoring TQ_COLORS: %v\n", err)
 		}
-		defer f.Close()
-		input = f
+		opts.Palette = &palette
 	}
 
-	// Create converter
-	conv := converter.New(converter.Options{
-		InputFormat:  inputFormat,
-		OutputFormat: outputFormat,
-		Indent:       indent,
-		UseTab:       useTab,
-		Delimiter:    delimiter,
-		Compact:      compact,
-		RawOutput:    rawOutput,
-		ShowStats:    showStats,
-		ShowCompare:  showCompare,
-		Slurp:        slurp,
-		MaxInputSize: 100 * 1024 * 1024, // 100MB default limit
-	})
+	conv := converter.New(opts)
+	inputs := newInputSource(conv, inputFiles)
+	defer inputs.Close()
 
-	// Read and parse input
-	var data interface{}
-	var err error
-	if nullInput {
-		// null-input mode: use null (nil) as input
-		data = nil
-	} else {
-		// Normal mode: read from input
-		data, err = conv.Read(input)
-		if err != nil {
-			return fmt.Errorf("failed to read input: %w", err)
+	engine := newEngine(conv, inputs)
+
+	// Row and event streaming run the query per row or event
+	if streamRows || stream || streamErrors {
+		if nullInput {
+			return fmt.Errorf("streaming modes cannot be used with --null-input")
 		}
+		if rawInput {
+			return fmt.Errorf("streaming modes cannot be used with --raw-input")
+		}
+		if streamRows && slurp {
+			return fmt.Errorf("--stream-rows cannot be used with --slurp")
+		}
+		read := conv.FileEvents
+		if streamRows {
+			read = conv.FileRows
+		}
+		if !slurp {
+			return runEach(os.Stdout, conv, engine, queryStr, func(fn func(interface{}) error) error {
+				return inputs.each(func(r io.Reader, name string) error {
+					return read(r, name, fn)
+				})
+			})
+		}
+
+		// --stream with --slurp: the query sees every event in one array
+		return runEach(os.Stdout, conv, engine, queryStr, func(fn func(interface{}) error) error {
+			events := make([]interface{}, 0)
+			err := inputs.each(func(r io.Reader, name string) error {
+				return read(r, name, func(event interface{}) error {
+					events = append(events, event)
+					return nil
+				})
+			})
+			if err != nil {
+				return err
+			}
+			return fn(events)
+		})
+	}
+
+	return runEach(os.Stdout, conv, engine, queryStr, readValues(inputs))
+}
+
+// useColor reports whether to color the output: with -C, or by default when
+// standard output is a terminal and NO_COLOR is not set. -M turns color off.
+func useColor() bool {
+	if monochrome {
+		return false
+	}
+	if colorOutput {
+		return true
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
 	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	// Execute query
+// newEngine returns a query engine reading further input from inputs, with
+// the YAML source information of conv and the query's variables
+func newEngine(conv *converter.Converter, inputs *inputSource) *query.Engine {
 	engine := query.New()
-	result, err := engine.Execute(queryStr, data)
-	if err != nil {
-		return fmt.Errorf("query failed: %w", err)
+	engine.SetInput(inputs)
+	engine.SetNodes(conv)
+	for name, value := range variables {
+		engine.SetVariable(name, value)
 	}
+	return engine
+}
+
+// readValues returns a function that runs fn on the input values: null with
+// --null-input, an array of every value with --slurp, and otherwise each
+// value across all files in turn
+func readValues(inputs *inputSource) func(fn func(interface{}) error) error {
+	return func(fn func(interface{}) error) error {
+		switch {
+		case nullInput:
+			// null-input mode: use null (nil) as input; input and inputs
+			// still read the input files
+			return fn(nil)
+
+		case slurp && rawInput:
+			// Raw input of every file as a single string
+			var all strings.Builder
+			for {
+				value, err := inputs.Next()
+				if err == io.EOF {
+					return fn(all.String())
+				}
+				if err != nil {
+					return err
+				}
+				all.WriteString(value.(string))
+			}
 
-	// Write output
-	if err := conv.Write(os.Stdout, result); err != nil {
-		return fmt.Errorf("failed to write output: %w", err)
+		case slurp:
+			// Slurp every value of every file into a single array
+			all := make([]interface{}, 0)
+			for {
+				value, err := inputs.Next()
+				if err == io.EOF {
+					return fn(all)
+				}
+				if err != nil {
+					return err
+				}
+				all = append(all, value)
+			}
+
+		default:
+			// Run the query once per input value, across all files
+			for {
+				value, err := inputs.Next()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := fn(value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// runEach runs the query over each value produced by read, writing results
+// to w as they are produced. Values the query produces nothing for, such as
+// rows rejected by select, have no output; a null result is written.
+func runEach(w io.Writer, conv *converter.Converter, engine *query.Engine, queryStr string, read func(fn func(interface{}) error) error) error {
+	_, err := runOutputs(w, conv, engine, queryStr, read)
+	return err
+}
+
+// runOutputs is runEach, also returning the number of outputs written
+func runOutputs(w io.Writer, conv *converter.Converter, engine *query.Engine, queryStr string, read func(fn func(interface{}) error) error) (int, error) {
+	// Results may be small and many; buffer them rather than writing each
+	out := bufio.NewWriter(w)
+
+	var last interface{}
+	var outputs int
+	var runErr error
+	err := read(func(value interface{}) error {
+		result, err := engine.Execute(queryStr, value)
+		if errors.Is(err, query.ErrEmpty) {
+			// No output for this input, as when select rejects it
+			return nil
+		}
+		if err != nil {
+			runErr = queryError(err)
+			return runErr
+		}
+		last = result
+		outputs++
+		if err := conv.Write(out, result); err != nil {
+			runErr = fmt.Errorf("failed to write output: %w", err)
+			return runErr
+		}
+		// Statistics go to stderr; keep them after the output they describe
+		if showStats || showCompare {
+			return out.Flush()
+		}
+		return nil
+	})
+
+	var halt *query.HaltError
+	if errors.As(runErr, &halt) {
+		// halt and halt_error keep the output so far
+		if err := out.Flush(); err != nil {
+			return outputs, fmt.Errorf("failed to write output: %w", err)
+		}
+		fmt.Fprint(os.Stderr, halt.Message)
+		return outputs, &ExitError{Code: halt.Code}
+	}
+	if runErr != nil || err != nil {
+		// Keep the output that came before the error
+		out.Flush()
+		if runErr != nil {
+			return outputs, runErr
+		}
+		return outputs, readError(err)
+	}
+	if err := out.Flush(); err != nil {
+		return outputs, fmt.Errorf("failed to write output: %w", err)
 	}
 
 	// Handle exit status
 	if exitStatus {
-		if result == nil || result == false {
-			return ErrExitWithStatus
+		if outputs == 0 {
+			return outputs, ErrNoOutput
+		}
+		if last == nil || last == false {
+			return outputs, ErrExitWithStatus
 		}
 	}
 
-	return nil
+	return outputs, nil
+}
+
+// queryError gives an error from the query engine its exit status: that of
+// a compile error if the query itself is at fault, otherwise that of a
+// runtime error. A halt is returned as it is.
+func queryError(err error) error {
+	var halt *query.HaltError
+	if errors.As(err, &halt) {
+		return halt
+	}
+	code := exitRuntime
+	var compile *query.CompileError
+	if errors.As(err, &compile) {
+		code = exitCompile
+	}
+	return &ExitError{Code: code, Err: fmt.Errorf("query failed: %w", err)}
+}
+
+// readError wraps an input error, appending a source excerpt with a caret
+// for TOON syntax errors
+func readError(err error) error {
+	var syntaxErr *toon.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("failed to read input: %w\n%s", err, syntaxErr.Excerpt())
+	}
+	return fmt.Errorf("failed to read input: %w", err)
 }
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ssccio/tq/pkg/converter"
+)
+
+// runFile runs the query over a file holding input, as tq does
+func runFile(t *testing.T, queryStr, input string) error {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(name, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conv := converter.New(converter.Options{InputFormat: "auto", OutputFormat: "json"})
+	inputs := newInputSource(conv, []string{name})
+	defer inputs.Close()
+	return runEach(io.Discard, conv, newEngine(conv, inputs), queryStr, readValues(inputs))
+}
+
+func TestExitStatus(t *testing.T) {
+	defer func(saved bool) { exitStatus = saved }(exitStatus)
+	exitStatus = true
+
+	tests := []struct {
+		name  string
+		query string
+		input string
+		want  int
+	}{
+		{"true", ".a", `{"a": true}`, 0},
+		{"false", ".a", `{"a": false}`, exitFalse},
+		{"null", ".b", `{"a": 1}`, exitFalse},
+		{"select_matches_nothing", ".a | select(. > 5)", `{"a": 1}`, exitNoOutput},
+		{"empty_input", ".", "", exitNoOutput},
+		{"blank_input", ".", "\n  \n", exitNoOutput},
+		{"malformed_object", ".", "{bad", exitUsage},
+		{"malformed_literal", ".", `{"a": tru}`, exitUsage},
+		{"trailing_comma", ".", `{"a":1,}`, exitUsage},
+		{"compile_error", "nosuch()", `{}`, exitCompile},
+		{"runtime_error", ".a | split(\",\")", `{"a": 1}`, exitRuntime},
+		{"halt_error", ".a | halt_error(7)", `{"a": ""}`, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(runFile(t, tt.query, tt.input)); got != tt.want {
+				t.Errorf("Expected exit status %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStreamKeepsNulls(t *testing.T) {
+	conv := converter.New(converter.Options{InputFormat: "auto", OutputFormat: "json", Compact: true})
+	var out strings.Builder
+	err := runEach(&out, conv, newEngine(conv, nil), ".[1]", func(fn func(interface{}) error) error {
+		return conv.ReadEvents(strings.NewReader(`{"a":null}`), fn)
+	})
+	if err != nil {
+		t.Fatalf("runEach failed: %v", err)
+	}
+	// The leaf event's null value, then the closing event's missing one
+	if out.String() != "null\nnull\n" {
+		t.Errorf("Expected the null leaf in the output, got %q", out.String())
+	}
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ssccio/tq/pkg/converter"
+)
+
+// createTemp creates the temporary file for the new content of a file
+var createTemp = os.CreateTemp
+
+// runInPlace runs the query over each file on its own and replaces the file
+// with the output. A file whose query fails is left as it was, and the
+// files after it are not touched.
+func runInPlace(opts converter.Options, queryStr string, files []string) error {
+	var status error
+	for _, name := range files {
+		err := editFile(opts, queryStr, name)
+		if isStatus(err) {
+			status = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return status
+}
+
+// editFile writes the output of the query on one file to a temporary file
+// next to it, then renames it over the original, so readers see either the
+// old or the new content. The new file keeps the original's permissions;
+// a symlink is followed and its target edited. A query with no output
+// leaves the file alone rather than emptying it.
+func editFile(opts converter.Options, queryStr, name string) error {
+	path, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", name)
+	}
+
+	tmp, err := createTemp(filepath.Dir(path), "."+filepath.Base(path)+".tq-*")
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	conv := converter.New(opts)
+	inputs := newInputSource(conv, []string{name})
+	defer inputs.Close()
+	engine := newEngine(conv, inputs)
+
+	outputs, status := runOutputs(tmp, conv, engine, queryStr, readValues(inputs))
+	if status != nil && !isStatus(status) {
+		tmp.Close()
+		return status
+	}
+	if outputs == 0 {
+		tmp.Close()
+		fmt.Fprintf(os.Stderr, "tq: %s: no output, file left unchanged\n", name)
+		return status
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if backup != "" {
+		if err := copyFile(path, path+backup, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("%s: failed to write backup: %w", name, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return status
+}
+
+// isStatus reports whether err is only the --exit-status verdict on the
+// output, so the output is still written
+func isStatus(err error) bool {
+	return err == ErrExitWithStatus || err == ErrNoOutput
+}
+
+// copyFile copies src to dst, replacing dst, with the given permissions
+func copyFile(src, dst string, perm os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
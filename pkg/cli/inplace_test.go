@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ssccio/tq/pkg/converter"
+	"github.com/ssccio/tq/pkg/query"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestRunEachWriteError(t *testing.T) {
+	conv := converter.New(converter.Options{OutputFormat: "json"})
+	err := runEach(failingWriter{}, conv, query.New(), ".", func(fn func(interface{}) error) error {
+		return fn(map[string]interface{}{"a": 1.0})
+	})
+	if err == nil {
+		t.Fatal("Expected the write error to be returned")
+	}
+}
+
+func TestEditFileWriteError(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "data.json")
+	original := "{\"a\": 1}\n"
+	if err := os.WriteFile(name, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A temporary file opened read-only fails every write
+	defer func(saved func(string, string) (*os.File, error)) { createTemp = saved }(createTemp)
+	createTemp = func(dir, pattern string) (*os.File, error) {
+		f, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		return os.Open(f.Name())
+	}
+
+	opts := converter.Options{InputFormat: "auto", OutputFormat: "json", KeepFormat: true}
+	if err := editFile(opts, ".a = 2", name); err == nil {
+		t.Fatal("Expected the write error to be returned")
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Errorf("Expected the file to be left alone, got %q", data)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the original file to remain, got %d files", len(entries))
+	}
+}
+
+// writeFile writes content to a new file in a temporary directory
+func writeFile(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(name, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// readFile returns the content of a file
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+var inPlaceOpts = converter.Options{InputFormat: "auto", OutputFormat: "json", Compact: true, KeepFormat: true}
+
+func TestEditFileNoOutput(t *testing.T) {
+	defer func(saved bool) { exitStatus = saved }(exitStatus)
+	original := "{\"a\": 1}\n"
+
+	for _, status := range []bool{false, true} {
+		exitStatus = status
+		name := writeFile(t, original, 0o644)
+		err := editFile(inPlaceOpts, ".a | select(. > 5)", name)
+		if status && err != ErrNoOutput || !status && err != nil {
+			t.Errorf("With -e %v: unexpected error %v", status, err)
+		}
+		if got := readFile(t, name); got != original {
+			t.Errorf("Expected the file to be left alone, got %q", got)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+			t.Errorf("Expected only the original file to remain, got %d files", len(entries))
+		}
+	}
+}
+
+func TestEditFilePermissions(t *testing.T) {
+	name := writeFile(t, `{"a": 1}`, 0o600)
+	if err := editFile(inPlaceOpts, ".a = 2", name); err != nil {
+		t.Fatalf("editFile failed: %v", err)
+	}
+	if got := readFile(t, name); got != "{\"a\":2}\n" {
+		t.Errorf("Expected the edited content, got %q", got)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestEditFileBackup(t *testing.T) {
+	defer func(saved string) { backup = saved }(backup)
+	backup = ".bak"
+
+	original := `{"a": 1}`
+	name := writeFile(t, original, 0o640)
+	if err := editFile(inPlaceOpts, ".a = 2", name); err != nil {
+		t.Fatalf("editFile failed: %v", err)
+	}
+	if got := readFile(t, name+".bak"); got != original {
+		t.Errorf("Expected the original content in the backup, got %q", got)
+	}
+	if got := readFile(t, name); got != "{\"a\":2}\n" {
+		t.Errorf("Expected the edited content, got %q", got)
+	}
+	info, err := os.Stat(name + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("Expected the backup to keep mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+func TestEditFileSymlink(t *testing.T) {
+	target := writeFile(t, `{"a": 1}`, 0o644)
+	link := filepath.Join(t.TempDir(), "link.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := editFile(inPlaceOpts, ".a = 2", link); err != nil {
+		t.Fatalf("editFile failed: %v", err)
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Expected the link to stay a symlink")
+	}
+	if got := readFile(t, target); got != "{\"a\":2}\n" {
+		t.Errorf("Expected the target to be edited, got %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ssccio/tq/pkg/converter"
+)
+
+// inputSource reads input values from each input file in turn, or from
+// stdin when no files are given. Each file is opened only when the previous
+// one is exhausted and has its format detected separately. It implements
+// query.InputSource for input, inputs and input_filename.
+type inputSource struct {
+	conv   *converter.Converter
+	files  []string // "-" reads stdin
+	file   *os.File
+	values *converter.ValueReader
+	name   interface{}
+}
+
+func newInputSource(conv *converter.Converter, files []string) *inputSource {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	return &inputSource{conv: conv, files: files}
+}
+
+// Next returns the next input value across all files, or io.EOF
+func (s *inputSource) Next() (interface{}, error) {
+	for {
+		if s.values == nil {
+			if len(s.files) == 0 {
+				return nil, io.EOF
+			}
+			r, err := s.open()
+			if err != nil {
+				return nil, err
+			}
+			name, _ := s.name.(string)
+			if s.values, err = s.conv.FileValues(r, name); err != nil {
+				return nil, s.wrap(err)
+			}
+		}
+
+		value, err := s.values.Next()
+		if err == io.EOF {
+			s.values = nil
+			continue
+		}
+		if err != nil {
+			return nil, s.wrap(err)
+		}
+		return value, nil
+	}
+}
+
+// Filename returns the name of the file being read, or nil for stdin
+func (s *inputSource) Filename() interface{} {
+	return s.name
+}
+
+// each calls fn with a reader for each remaining input in turn, and its
+// file name ("" for stdin), for modes that consume the raw input themselves
+func (s *inputSource) each(fn func(r io.Reader, name string) error) error {
+	for len(s.files) > 0 {
+		r, err := s.open()
+		if err != nil {
+			return err
+		}
+		name, _ := s.name.(string)
+		if err := fn(r, name); err != nil {
+			return s.wrap(err)
+		}
+	}
+	return nil
+}
+
+// Close closes the file currently open
+func (s *inputSource) Close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// open closes the current file and opens the next one
+func (s *inputSource) open() (io.Reader, error) {
+	s.Close()
+	name := s.files[0]
+	s.files = s.files[1:]
+
+	if name == "-" {
+		s.name = nil
+		return os.Stdin, nil
+	}
+
+	// Sanitize file path to prevent path traversal
+	cleanPath := filepath.Clean(name)
+	if strings.Contains(cleanPath, "..") {
+		return nil, fmt.Errorf("invalid input file path: path traversal not allowed")
+	}
+	f, err := os.Open(cleanPath)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	s.name = name
+	return f, nil
+}
+
+// wrap prefixes an input error with the name of the file it came from
+func (s *inputSource) wrap(err error) error {
+	if s.name == nil {
+		return err
+	}
+	return fmt.Errorf("%s: %w", s.name, err)
+}
@@ -0,0 +1,169 @@
+package converter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ssccio/tq/pkg/cbor"
+	"github.com/ssccio/tq/pkg/msgpack"
+)
+
+// Binary value policies for text output of MessagePack and CBOR input
+const (
+	BinaryBase64 = "base64" // Byte strings as base64 text, timestamps as RFC 3339 (default)
+	BinaryTagged = "tagged" // {"type": ..., "value": ...} objects that convert back
+)
+
+// Tagged value types
+const (
+	tagBinary    = "binary"
+	tagTimestamp = "timestamp"
+	tagExt       = "ext"
+)
+
+// isBinaryFormat reports whether format is a binary serialization
+func isBinaryFormat(format string) bool {
+	return format == "msgpack" || format == "cbor"
+}
+
+// binaryReader reads a stream of MessagePack or CBOR values. Unless the
+// output is binary too, byte strings, timestamps and extensions are turned
+// into text-friendly values as they are read, so queries see strings.
+func (c *Converter) binaryReader(name string, decode func(interface{}) error) func() (interface{}, error) {
+	next := c.streamReader(name, decode)
+	if isBinaryFormat(c.opts.OutputFormat) {
+		return next
+	}
+	if c.opts.Binary != "" && c.opts.Binary != BinaryBase64 && c.opts.Binary != BinaryTagged {
+		return func() (interface{}, error) {
+			return nil, fmt.Errorf("unsupported binary handling %q: use %q or %q",
+				c.opts.Binary, BinaryBase64, BinaryTagged)
+		}
+	}
+	return func() (interface{}, error) {
+		value, err := next()
+		if err != nil {
+			return nil, err
+		}
+		return c.textValue(value), nil
+	}
+}
+
+// textValue replaces byte strings with base64 and timestamps with RFC 3339
+// text, or with tagged objects under BinaryTagged. MessagePack extensions
+// are always tagged: {"type": "ext", "code": n, "value": base64}.
+func (c *Converter) textValue(v interface{}) interface{} {
+	tagged := c.opts.Binary == BinaryTagged
+	switch val := v.(type) {
+	case []byte:
+		s := base64.StdEncoding.EncodeToString(val)
+		if tagged {
+			return map[string]interface{}{"type": tagBinary, "value": s}
+		}
+		return s
+	case time.Time:
+		s := val.Format(time.RFC3339Nano)
+		if tagged {
+			return map[string]interface{}{"type": tagTimestamp, "value": s}
+		}
+		return s
+	case msgpack.Ext:
+		return map[string]interface{}{
+			"type":  tagExt,
+			"code":  int64(val.Type),
+			"value": base64.StdEncoding.EncodeToString(val.Data),
+		}
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = c.textValue(item)
+		}
+		return items
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			obj[k] = c.textValue(item)
+		}
+		return obj
+	}
+	return v
+}
+
+// binaryValue turns tagged objects back into byte strings, timestamps and
+// extensions for binary output
+func binaryValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = binaryValue(item)
+		}
+		return items
+	case map[string]interface{}:
+		if tagged, ok := untag(val); ok {
+			return tagged
+		}
+		obj := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			obj[k] = binaryValue(item)
+		}
+		return obj
+	}
+	return v
+}
+
+// untag decodes a tagged object, reporting whether obj was one
+func untag(obj map[string]interface{}) (interface{}, bool) {
+	s, ok := obj["value"].(string)
+	if !ok {
+		return nil, false
+	}
+	switch obj["type"] {
+	case tagBinary:
+		if data, err := base64.StdEncoding.DecodeString(s); err == nil && len(obj) == 2 {
+			return data, true
+		}
+	case tagTimestamp:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil && len(obj) == 2 {
+			return t, true
+		}
+	case tagExt:
+		data, err := base64.StdEncoding.DecodeString(s)
+		code, ok := toInt(obj["code"])
+		if err == nil && ok && len(obj) == 3 && code >= -128 && code <= 127 {
+			return msgpack.Ext{Type: int8(code), Data: data}, true
+		}
+	}
+	return nil, false
+}
+
+// toInt reads an integral number of any numeric type
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), n == float64(int64(n))
+	}
+	return 0, false
+}
+
+// writeBinary writes data as MessagePack or CBOR
+func (c *Converter) writeBinary(w io.Writer, data interface{}, format string) (int, error) {
+	encode, name := msgpack.Encode, "MessagePack"
+	if format == "cbor" {
+		encode, name = cbor.Encode, "CBOR"
+	}
+	output, err := encode(binaryValue(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	if _, err := w.Write(output); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return len(output), nil
+}
@@ -0,0 +1,460 @@
+package converter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Palette holds the SGR parameters, such as "1;34", that color each kind of
+// token. An empty parameter leaves the token uncolored.
+type Palette struct {
+	Null      string
+	False     string
+	True      string
+	Number    string
+	String    string
+	Array     string // Array brackets and list item markers
+	Object    string // Object braces and key separators
+	Key       string
+	Header    string // TOON array headers such as [3]{id,name}
+	Delimiter string // Delimiters between TOON values
+	Comment   string // YAML comments
+}
+
+// DefaultPalette is jq's palette, plus cyan TOON array headers and gray
+// comments
+var DefaultPalette = Palette{
+	Null:      "0;90",
+	False:     "0;39",
+	True:      "0;39",
+	Number:    "0;39",
+	String:    "0;32",
+	Array:     "1;39",
+	Object:    "1;39",
+	Key:       "34;1",
+	Header:    "0;36",
+	Delimiter: "1;39",
+	Comment:   "0;90",
+}
+
+// fields returns the palette's entries in the order of TQ_COLORS
+func (p *Palette) fields() []*string {
+	return []*string{&p.Null, &p.False, &p.True, &p.Number, &p.String,
+		&p.Array, &p.Object, &p.Key, &p.Header, &p.Delimiter, &p.Comment}
+}
+
+// ParsePalette reads a palette in the form of TQ_COLORS: SGR parameters
+// separated by colons for null, false, true, numbers, strings, arrays,
+// objects, object keys, TOON array headers, TOON delimiters and comments,
+// in that order. The first eight are those of JQ_COLORS. Colors left out or
+// empty keep their default.
+func ParsePalette(spec string) (Palette, error) {
+	p := DefaultPalette
+	fields := p.fields()
+	parts := strings.Split(spec, ":")
+	if len(parts) > len(fields) {
+		return DefaultPalette, fmt.Errorf("too many colors: %d, at most %d", len(parts), len(fields))
+	}
+	for i, part := range parts {
+		if strings.Trim(part, "0123456789;") != "" {
+			return DefaultPalette, fmt.Errorf("invalid color %q", part)
+		}
+		if part != "" {
+			*fields[i] = part
+		}
+	}
+	return p, nil
+}
+
+// colorFormats are the output formats that can be colored
+var colorFormats = map[string]bool{"json": true, "jsonl": true, "yaml": true, "toon": true}
+
+// colorize adds terminal colors to text written in the output format
+func (c *Converter) colorize(text string) string {
+	p := c.opts.Palette
+	if p == nil {
+		p = &DefaultPalette
+	}
+	switch c.opts.OutputFormat {
+	case "json", "jsonl":
+		return colorJSON(text, p)
+	case "yaml":
+		return colorYAML(text, p)
+	case "toon":
+		return colorTOON(text, p)
+	}
+	return text
+}
+
+// paint writes text in the color with SGR parameters code
+func paint(b *strings.Builder, code, text string) {
+	if code == "" || text == "" {
+		b.WriteString(text)
+		return
+	}
+	b.WriteString("\x1b[" + code + "m" + text + "\x1b[0m")
+}
+
+// scalarColor returns the color of a scalar as written in the output: a
+// quoted or unquoted string, a number, a boolean or null
+func scalarColor(p *Palette, text string) string {
+	switch text {
+	case "null":
+		return p.Null
+	case "true":
+		return p.True
+	case "false":
+		return p.False
+	}
+	if isNumberText(text) {
+		return p.Number
+	}
+	return p.String
+}
+
+// isNumberText reports whether text is a decimal number
+func isNumberText(text string) bool {
+	if _, err := strconv.ParseFloat(text, 64); err != nil {
+		return false
+	}
+	return strings.IndexFunc(text, func(r rune) bool {
+		return unicode.IsLetter(r) && r != 'e' && r != 'E'
+	}) == -1
+}
+
+// quotedEnd returns the index just after the string quoted with text[i],
+// where backslash escapes a double-quoted string's quote
+func quotedEnd(text string, i int) int {
+	quote := text[i]
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			if quote == '"' {
+				j++
+			}
+		case quote:
+			return j + 1
+		}
+	}
+	return len(text)
+}
+
+// colorJSON colors JSON text, and the flow collections of YAML, whose
+// unquoted scalars it colors as JSON literals or strings
+func colorJSON(text string, p *Palette) string {
+	var b strings.Builder
+	var open []byte // Brackets of the enclosing collections
+	expectKey := false
+	for i := 0; i < len(text); {
+		ch := text[i]
+		switch ch {
+		case '{', '[':
+			code := p.Object
+			if ch == '[' {
+				code = p.Array
+			}
+			paint(&b, code, text[i:i+1])
+			open = append(open, ch)
+			expectKey = ch == '{'
+			i++
+		case '}', ']':
+			code := p.Object
+			if ch == ']' {
+				code = p.Array
+			}
+			paint(&b, code, text[i:i+1])
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			expectKey = false
+			i++
+		case ',':
+			inObject := len(open) > 0 && open[len(open)-1] == '{'
+			code := p.Array
+			if inObject {
+				code = p.Object
+			}
+			paint(&b, code, ",")
+			expectKey = inObject
+			i++
+		case ':':
+			paint(&b, p.Object, ":")
+			expectKey = false
+			i++
+		case ' ', '\t', '\r', '\n':
+			b.WriteByte(ch)
+			i++
+		default:
+			end := i + 1
+			if ch == '"' || ch == '\'' {
+				end = quotedEnd(text, i)
+			} else {
+				for end < len(text) && !strings.ContainsRune(",:[]{} \t\r\n", rune(text[end])) {
+					end++
+				}
+			}
+			code := scalarColor(p, text[i:end])
+			if expectKey {
+				code = p.Key
+			}
+			paint(&b, code, text[i:end])
+			i = end
+		}
+	}
+	return b.String()
+}
+
+// colorYAML colors YAML text a line at a time
+func colorYAML(text string, p *Palette) string {
+	var b strings.Builder
+	block := -1 // Indentation of the line that starts a block scalar, if any
+	for _, line := range strings.SplitAfter(text, "\n") {
+		body := strings.TrimSuffix(line, "\n")
+		rest := strings.TrimLeft(body, " ")
+		indent := len(body) - len(rest)
+
+		// The lines of a block scalar are indented below its start
+		if block >= 0 {
+			if rest == "" || indent > block {
+				paint(&b, p.String, body)
+				b.WriteString(line[len(body):])
+				continue
+			}
+			block = -1
+		}
+		b.WriteString(body[:indent])
+
+		for strings.HasPrefix(rest, "- ") || rest == "-" {
+			paint(&b, p.Array, "-")
+			rest = rest[1:]
+			value := strings.TrimLeft(rest, " ")
+			b.WriteString(rest[:len(rest)-len(value)])
+			rest = value
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "#"):
+			paint(&b, p.Comment, rest)
+		case rest == "---" || rest == "..." || strings.HasPrefix(rest, "--- "):
+			b.WriteString(rest)
+		default:
+			if key, value, ok := yamlKey(rest); ok {
+				paint(&b, p.Key, key)
+				paint(&b, p.Object, ":")
+				rest = value
+			}
+			if colorYAMLValue(&b, p, rest) {
+				block = indent
+			}
+		}
+		b.WriteString(line[len(body):])
+	}
+	return b.String()
+}
+
+// yamlKey splits a line of a block mapping into its key and what follows
+// the colon after it
+func yamlKey(line string) (key, value string, ok bool) {
+	var end int
+	switch {
+	case line == "" || strings.ContainsRune("[{&*!|>?%@`", rune(line[0])):
+		return "", "", false
+	case line[0] == '"' || line[0] == '\'':
+		end = quotedEnd(line, 0)
+	default:
+		end = strings.Index(line, ": ")
+		if end < 0 && strings.HasSuffix(line, ":") {
+			end = len(line) - 1
+		}
+		if end <= 0 || strings.Contains(line[:end], " #") {
+			return "", "", false
+		}
+	}
+	if end >= len(line) || line[end] != ':' || (end+1 < len(line) && line[end+1] != ' ') {
+		return "", "", false
+	}
+	return line[:end], line[end+1:], true
+}
+
+// colorYAMLValue colors the value of a YAML line, with any anchor, tag or
+// comment, and reports whether it starts a block scalar
+func colorYAMLValue(b *strings.Builder, p *Palette, value string) bool {
+	comment := ""
+	for i := 0; i < len(value); i++ {
+		switch {
+		case (value[i] == '"' || value[i] == '\'') && (i == 0 || value[i-1] == ' '):
+			i = quotedEnd(value, i) - 1
+		case value[i] == '#' && (i == 0 || value[i-1] == ' '):
+			value, comment = value[:i], value[i:]
+		}
+	}
+
+	// Anchors, aliases and tags are left uncolored
+	for {
+		text := strings.TrimLeft(value, " ")
+		b.WriteString(value[:len(value)-len(text)])
+		value = text
+		if value == "" || !strings.ContainsRune("&*!", rune(value[0])) {
+			break
+		}
+		end := strings.IndexByte(value, ' ')
+		if end < 0 {
+			end = len(value)
+		}
+		b.WriteString(value[:end])
+		value = value[end:]
+	}
+
+	scalar := strings.TrimRight(value, " ")
+	isBlock := false
+	switch {
+	case scalar == "":
+	case scalar[0] == '|' || scalar[0] == '>':
+		isBlock = true
+		b.WriteString(scalar)
+	case scalar[0] == '[' || scalar[0] == '{':
+		b.WriteString(colorJSON(scalar, p))
+	case scalar == "~" || scalar == ".inf" || scalar == "-.inf" || scalar == ".nan":
+		code := p.Number
+		if scalar == "~" {
+			code = p.Null
+		}
+		paint(b, code, scalar)
+	default:
+		paint(b, scalarColor(p, scalar), scalar)
+	}
+	b.WriteString(value[len(scalar):])
+	paint(b, p.Comment, comment)
+	return isBlock
+}
+
+// toonTable is a tabular array whose rows are being colored
+type toonTable struct {
+	indent int
+	delim  byte
+}
+
+// colorTOON colors TOON text a line at a time
+func colorTOON(text string, p *Palette) string {
+	var b strings.Builder
+	var tables []toonTable
+	for _, line := range strings.SplitAfter(text, "\n") {
+		body := strings.TrimSuffix(line, "\n")
+		rest := strings.TrimLeft(body, " \t")
+		indent := len(body) - len(rest)
+		b.WriteString(body[:indent])
+
+		if rest != "" {
+			for len(tables) > 0 && indent <= tables[len(tables)-1].indent {
+				tables = tables[:len(tables)-1]
+			}
+		}
+		switch {
+		case rest == "":
+		case len(tables) > 0:
+			// A row of the innermost table
+			colorTOONValues(&b, p, rest, tables[len(tables)-1].delim)
+		default:
+			for strings.HasPrefix(rest, "- ") || rest == "-" {
+				paint(&b, p.Array, "-")
+				rest = rest[1:]
+				value := strings.TrimLeft(rest, " ")
+				b.WriteString(rest[:len(rest)-len(value)])
+				rest = value
+			}
+
+			key, header, value, ok := toonKey(rest)
+			if !ok {
+				// A primitive list item
+				colorTOONValues(&b, p, rest, 0)
+				break
+			}
+			paint(&b, p.Key, key)
+			paint(&b, p.Header, header)
+			paint(&b, p.Object, ":")
+
+			if header == "" {
+				colorTOONValues(&b, p, value, 0)
+				break
+			}
+			delim := toonDelimiter(header)
+			if strings.HasSuffix(header, "}") && strings.TrimSpace(value) == "" {
+				tables = append(tables, toonTable{indent: indent, delim: delim})
+			}
+			colorTOONValues(&b, p, value, delim)
+		}
+		b.WriteString(line[len(body):])
+	}
+	return b.String()
+}
+
+// toonKey splits a TOON line into its key, its array header if any, and
+// what follows the colon after them
+func toonKey(line string) (key, header, value string, ok bool) {
+	i := 0
+	if strings.HasPrefix(line, `"`) {
+		i = quotedEnd(line, 0)
+	} else {
+		for i < len(line) && line[i] != ':' && line[i] != '[' {
+			i++
+		}
+	}
+
+	j := i
+	if j < len(line) && line[j] == '[' {
+		end := strings.IndexByte(line[j:], ']')
+		if end < 0 {
+			return "", "", "", false
+		}
+		j += end + 1
+		if j < len(line) && line[j] == '{' {
+			end := strings.IndexByte(line[j:], '}')
+			if end < 0 {
+				return "", "", "", false
+			}
+			j += end + 1
+		}
+	}
+	if j >= len(line) || line[j] != ':' {
+		return "", "", "", false
+	}
+	return line[:i], line[i:j], line[j+1:], true
+}
+
+// toonDelimiter returns the delimiter declared by an array header
+func toonDelimiter(header string) byte {
+	end := strings.IndexByte(header, ']')
+	if end > 1 {
+		switch header[end-1] {
+		case '|', '\t':
+			return header[end-1]
+		}
+	}
+	return ','
+}
+
+// colorTOONValues colors the values of a TOON line separated by delim, or
+// a single value if delim is 0
+func colorTOONValues(b *strings.Builder, p *Palette, text string, delim byte) {
+	value := strings.TrimLeft(text, " ")
+	b.WriteString(text[:len(text)-len(value)])
+
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) && value[i] == '"' {
+			i = quotedEnd(value, i) - 1
+			continue
+		}
+		if i < len(value) && (delim == 0 || value[i] != delim) {
+			continue
+		}
+		field := value[start:i]
+		paint(b, scalarColor(p, field), field)
+		if i < len(value) {
+			paint(b, p.Delimiter, value[i:i+1])
+		}
+		start = i + 1
+	}
+}
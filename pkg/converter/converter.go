@@ -1,13 +1,15 @@
 package converter
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strings"
 
+	"github.com/ssccio/tq/pkg/toml"
 	"github.com/ssccio/tq/pkg/toon"
 	"gopkg.in/yaml.v3"
 )
@@ -20,16 +22,73 @@ type Options struct {
 	UseTab       bool
 	Delimiter    string
 	Compact      bool
-	RawOutput    bool
+	RawOutput    bool // Write strings as they are, in any output format
+	JoinOutput   bool // Raw output without a newline after each value
+	RawOutput0   bool // Raw output with a NUL after each value
+	ASCIIOutput  bool // Escape non-ASCII characters in JSON output and raw strings
+	RawInput     bool // Read each line of input as a string (the whole input with Slurp)
+	Color        bool // Color JSON, JSONL, YAML and TOON output for a terminal
 	ShowStats    bool
 	ShowCompare  bool  // Show input vs output size comparison
 	Slurp        bool  // Read entire input into single array
 	MaxInputSize int64 // Maximum input size in bytes (0 = unlimited)
+	StreamErrors bool  // Report parse errors as a final streaming event
+	Seq          bool  // Read and write RS-separated JSON texts (RFC 7464)
+	SkipBadLines bool  // Skip malformed JSONL lines with a warning instead of failing
+	DebugFormat  bool  // Report each input's detected format on stderr
+	KeepFormat   bool  // Write output in the format of the input being read
+
+	// TOON key folding (encode) and path expansion (decode)
+	KeyFolding    bool
+	FlattenDepth  int
+	ExpandPaths   bool
+	PathCollision string
+
+	// TOON sparse tables for arrays of objects with differing keys
+	SparseTabular bool
+	SparseFill    string
+
+	// CSV/TSV: the columns to write (default: every key, sorted; Markdown
+	// and HTML tables use them too), whether there is a header row, and
+	// whether cell types are inferred on read
+	CSVColumns  []string
+	CSVNoHeader bool
+	CSVNoInfer  bool
+
+	// MessagePack/CBOR byte strings and timestamps in text output:
+	// BinaryBase64 or BinaryTagged
+	Binary string
+
+	// TOML datetimes: toml.DatetimeString or toml.DatetimeTagged
+	TOMLDatetimes string
+
+	// XML mapping: attribute key prefix (default "@"), text key (default
+	// "#text"), XMLNamespaces* handling, and dotted element paths
+	// (rss.channel.item) that are always read as arrays
+	XMLAttrPrefix string
+	XMLTextKey    string
+	XMLNamespaces string
+	XMLArrays     []string
+
+	// Colors for Color output (default: DefaultPalette)
+	Palette *Palette
+
+	// TOON encoder style; see toon.Options
+	LengthMarker        bool
+	OmitLengths         bool
+	BlankLines          string
+	Quoting             string
+	OmitTrailingNewline bool
 }
 
 // Converter handles format conversion
 type Converter struct {
-	opts Options
+	opts       Options
+	documents  int        // Documents written so far, for separators
+	csvColumns []string   // Columns of the first CSV/TSV document
+	document   *yaml.Node // YAML document of the current input value, if any
+	records    bool       // Input values are records of their own, such as JSONL lines
+	header     []string   // Header row of the last CSV/TSV input, for column order
 }
 
 // New creates a new converter
@@ -37,60 +96,160 @@ func New(opts Options) *Converter {
 	return &Converter{opts: opts}
 }
 
-// Read reads and parses input in the specified format
+// Read reads and parses input in the specified format. In slurp mode the
+// result is an array of every input value.
 func (c *Converter) Read(r io.Reader) (interface{}, error) {
 	if r == nil {
 		return nil, nil
 	}
 
-	// Apply size limit if configured
-	if c.opts.MaxInputSize > 0 {
-		r = io.LimitReader(r, c.opts.MaxInputSize)
+	values, err := c.Values(r)
+	if err != nil {
+		return nil, err
 	}
 
-	// For JSON and YAML, use streaming decoders
-	// Peek at first bytes to detect format
-	data := make([]byte, 0, 512)
-	buf := make([]byte, 512)
-	n, err := r.Read(buf)
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read input: %w", err)
+	if !c.opts.Slurp {
+		return values.Next()
 	}
-	data = append(data, buf[:n]...)
 
-	format := c.opts.InputFormat
-	if format == "auto" {
-		format = detectFormat(data)
+	var results []interface{}
+	for {
+		value, err := values.Next()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+}
+
+// inputFormat resolves the input format of r, read from the named file (""
+// for stdin). When it is "auto", up to SniffSize bytes are read to detect
+// it; the returned reader yields the whole input again.
+func (c *Converter) inputFormat(r io.Reader, name string) (string, io.Reader, error) {
+	if c.opts.InputFormat != "auto" {
+		c.debugFormat(name, Detection{Format: c.opts.InputFormat, Confidence: 1, Method: DetectOption})
+		return c.opts.InputFormat, r, nil
 	}
 
+	sample := make([]byte, SniffSize)
+	n, err := io.ReadFull(r, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	sample = sample[:n]
+
+	d := Detect(sample, name)
+	c.debugFormat(name, d)
+
 	// Create MultiReader with peeked data + remaining
-	fullReader := io.MultiReader(strings.NewReader(string(data)), r)
+	return d.Format, io.MultiReader(bytes.NewReader(sample), r), nil
+}
 
-	switch format {
-	case "json":
-		return c.readJSONStream(fullReader)
-	case "yaml":
-		return c.readYAMLStream(fullReader)
-	case "toon":
-		// Use streaming reader for TOON as well
-		return toon.DecodeReader(bufio.NewReader(fullReader))
-	default:
-		return nil, fmt.Errorf("unsupported input format: %s", format)
+// ReadRows streams the rows of a TOON document whose top level is a single
+// array, calling fn for each one. MaxInputSize does not apply: only the
+// current row is held in memory.
+func (c *Converter) ReadRows(r io.Reader, fn func(row interface{}) error) error {
+	return c.FileRows(r, "", fn)
+}
+
+// FileRows is ReadRows for input read from the named file, which must not
+// have the extension of another format when it is detected automatically
+func (c *Converter) FileRows(r io.Reader, name string, fn func(row interface{}) error) error {
+	format := c.opts.InputFormat
+	if ext := FormatForFile(name); format == "auto" && ext != "" {
+		format = ext
+	}
+	if format != "auto" && format != "toon" {
+		return fmt.Errorf("row streaming requires TOON input, got %s", format)
+	}
+	c.records = true
+
+	scanner := toon.NewRowScanner(r)
+	scanner.SetOptions(c.toonOptions())
+	for scanner.Scan() {
+		if err := fn(scanner.Row()); err != nil {
+			return err
+		}
 	}
+	return scanner.Err()
 }
 
-// Write writes data in the specified output format
+// Write writes data in the specified output format. Raw output writes an
+// array one element per output, as jq does for .[], so every output format
+// can feed line- or NUL-separated consumers.
 func (c *Converter) Write(w io.Writer, data interface{}) error {
+	if arr, ok := data.([]interface{}); ok && c.rawOutput() {
+		for _, item := range arr {
+			if err := c.write(w, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.write(w, data)
+}
+
+// write writes one output value
+func (c *Converter) write(w io.Writer, data interface{}) error {
 	var err error
 	var outputSize int
 
+	// Raw output writes strings as they are, whatever the output format
+	if s, ok := data.(string); ok && c.rawOutput() {
+		return c.writeRaw(w, s)
+	}
+
+	// Joined and NUL-separated output end each value with a terminator in
+	// place of the trailing newline, and have no document separators
+	joined := (c.opts.JoinOutput || c.opts.RawOutput0) && !isBinaryFormat(c.opts.OutputFormat)
+	colored := c.opts.Color && colorFormats[c.opts.OutputFormat]
+	out := w
+	var buf bytes.Buffer
+	if joined || colored {
+		out = &buf
+	}
+
+	// application/json-seq starts every text with RS
+	if c.opts.Seq {
+		if _, err := w.Write([]byte{recordSeparator}); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	} else if c.documents > 0 && !joined {
+		if _, err := io.WriteString(w, c.separator()); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+
 	switch c.opts.OutputFormat {
 	case "json":
-		outputSize, err = c.writeJSON(w, data)
+		outputSize, err = c.writeJSON(out, data)
+	case "jsonl":
+		outputSize, err = c.writeJSONL(out, data)
+	case "csv", "tsv":
+		outputSize, err = c.writeCSV(out, data, c.opts.OutputFormat)
+	case "toml":
+		outputSize, err = c.writeTOML(out, data)
+	case "xml":
+		outputSize, err = c.writeXML(out, data)
+	case "markdown":
+		outputSize, err = c.writeMarkdown(out, data)
+	case "html":
+		outputSize, err = c.writeHTML(out, data)
+	case "ini":
+		outputSize, err = c.writeINI(out, data)
+	case "dotenv":
+		outputSize, err = c.writeDotenv(out, data)
+	case "properties":
+		outputSize, err = c.writeProperties(out, data)
+	case "msgpack", "cbor":
+		outputSize, err = c.writeBinary(out, data, c.opts.OutputFormat)
 	case "yaml":
-		outputSize, err = c.writeYAML(w, data)
+		outputSize, err = c.writeYAML(out, data)
 	case "toon":
-		outputSize, err = c.writeTOON(w, data)
+		outputSize, err = c.writeTOON(out, data)
 	default:
 		return fmt.Errorf("unsupported output format: %s", c.opts.OutputFormat)
 	}
@@ -98,6 +257,19 @@ func (c *Converter) Write(w io.Writer, data interface{}) error {
 	if err != nil {
 		return err
 	}
+	if joined || colored {
+		text := buf.String()
+		if colored {
+			text = c.colorize(text)
+		}
+		if joined {
+			text = strings.TrimSuffix(text, "\n") + c.terminator()
+		}
+		if _, err := io.WriteString(w, text); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+	}
+	c.documents++
 
 	// Show comparison statistics if requested
 	if c.opts.ShowCompare {
@@ -107,36 +279,28 @@ func (c *Converter) Write(w io.Writer, data interface{}) error {
 	return nil
 }
 
-func (c *Converter) readJSON(data []byte) (interface{}, error) {
-	var result interface{}
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
-	}
-	return result, nil
-}
-
-func (c *Converter) readJSONStream(r io.Reader) (interface{}, error) {
-	decoder := json.NewDecoder(r)
-
-	// Slurp mode: read all values into array
-	if c.opts.Slurp {
-		var results []interface{}
-		for {
-			var value interface{}
-			if err := decoder.Decode(&value); err != nil {
-				if err == io.EOF {
-					break
-				}
-				return nil, fmt.Errorf("failed to parse JSON: %w", err)
-			}
-			results = append(results, value)
+// separator returns what goes between two output documents: a YAML or TOON
+// document marker, which reads back as separate values, or a blank line
+// between TOML, INI or Markdown documents. JSON values already end in a
+// newline, so compact output is NDJSON.
+func (c *Converter) separator() string {
+	switch c.opts.OutputFormat {
+	case "yaml":
+		return "---\n"
+	case "toml", "ini", "markdown":
+		return "\n"
+	case "toon":
+		if c.opts.OmitTrailingNewline {
+			return "\n" + toon.DocumentSeparator + "\n"
 		}
-		return results, nil
+		return toon.DocumentSeparator + "\n"
 	}
+	return ""
+}
 
-	// Normal mode: read single value
+func (c *Converter) readJSON(data []byte) (interface{}, error) {
 	var result interface{}
-	if err := decoder.Decode(&result); err != nil {
+	if err := json.Unmarshal(data, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 	return result, nil
@@ -150,44 +314,21 @@ func (c *Converter) readYAML(data []byte) (interface{}, error) {
 	return result, nil
 }
 
-func (c *Converter) readYAMLStream(r io.Reader) (interface{}, error) {
-	decoder := yaml.NewDecoder(r)
-
-	// Slurp mode: read all documents into array
-	if c.opts.Slurp {
-		var results []interface{}
-		for {
-			var value interface{}
-			if err := decoder.Decode(&value); err != nil {
-				if err == io.EOF {
-					break
-				}
-				return nil, fmt.Errorf("failed to parse YAML: %w", err)
-			}
-			results = append(results, value)
-		}
-		return results, nil
-	}
-
-	// Normal mode: read single document
-	var result interface{}
-	if err := decoder.Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML: %w", err)
-	}
-	return result, nil
-}
-
 func (c *Converter) writeJSON(w io.Writer, data interface{}) (int, error) {
 	var buf strings.Builder
 	encoder := json.NewEncoder(&buf)
 	if !c.opts.Compact {
 		encoder.SetIndent("", strings.Repeat(" ", c.opts.Indent))
 	}
+	data, _ = jsonFinite(data)
 	if err := encoder.Encode(data); err != nil {
 		return 0, fmt.Errorf("failed to encode JSON: %w", err)
 	}
 
 	output := buf.String()
+	if c.opts.ASCIIOutput {
+		output = asciiEscape(output)
+	}
 	if _, err := w.Write([]byte(output)); err != nil {
 		return 0, fmt.Errorf("failed to write JSON: %w", err)
 	}
@@ -195,11 +336,72 @@ func (c *Converter) writeJSON(w io.Writer, data interface{}) (int, error) {
 	return len(output), nil
 }
 
+// jsonFinite replaces infinities with the largest finite float and NaN with
+// null, as jq does, since JSON has no literal for them. Containers are
+// copied only if something in them changes, which the second result
+// reports.
+func jsonFinite(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(val):
+			return nil, true
+		case math.IsInf(val, 1):
+			return math.MaxFloat64, true
+		case math.IsInf(val, -1):
+			return -math.MaxFloat64, true
+		}
+	case map[string]interface{}:
+		var copied map[string]interface{}
+		for k, item := range val {
+			if item, changed := jsonFinite(item); changed {
+				if copied == nil {
+					copied = make(map[string]interface{}, len(val))
+					for k, item := range val {
+						copied[k] = item
+					}
+				}
+				copied[k] = item
+			}
+		}
+		if copied != nil {
+			return copied, true
+		}
+	case []interface{}:
+		var copied []interface{}
+		for i, item := range val {
+			if item, changed := jsonFinite(item); changed {
+				if copied == nil {
+					copied = append([]interface{}(nil), val...)
+				}
+				copied[i] = item
+			}
+		}
+		if copied != nil {
+			return copied, true
+		}
+	}
+	return v, false
+}
+
 func (c *Converter) writeYAML(w io.Writer, data interface{}) (int, error) {
+	// Write an edit of a YAML input document from the document itself
+	var value interface{} = data
+	if c.document != nil {
+		doc, err := mergeYAML(c.document, data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode YAML: %w", err)
+		}
+		if doc != nil {
+			value = doc
+		}
+		c.document = nil
+	}
+
 	var buf strings.Builder
 	encoder := yaml.NewEncoder(&buf)
 	encoder.SetIndent(c.opts.Indent)
-	if err := encoder.Encode(data); err != nil {
+	if err := encoder.Encode(value); err != nil {
 		return 0, fmt.Errorf("failed to encode YAML: %w", err)
 	}
 
@@ -211,14 +413,21 @@ func (c *Converter) writeYAML(w io.Writer, data interface{}) (int, error) {
 	return len(output), nil
 }
 
-func (c *Converter) writeTOON(w io.Writer, data interface{}) (int, error) {
-	opts := toon.Options{
-		Indent:    c.opts.Indent,
-		Delimiter: c.opts.Delimiter,
-		UseTab:    c.opts.UseTab,
+func (c *Converter) writeTOML(w io.Writer, data interface{}) (int, error) {
+	output, err := toml.Encode(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode TOML: %w", err)
 	}
 
-	output, err := toon.Encode(data, opts)
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write TOML: %w", err)
+	}
+
+	return len(output), nil
+}
+
+func (c *Converter) writeTOON(w io.Writer, data interface{}) (int, error) {
+	output, err := toon.Encode(data, c.toonOptions())
 	if err != nil {
 		return 0, fmt.Errorf("failed to encode TOON: %w", err)
 	}
@@ -229,13 +438,6 @@ func (c *Converter) writeTOON(w io.Writer, data interface{}) (int, error) {
 
 	outputSize := len(output)
 
-	if !strings.HasSuffix(output, "\n") {
-		if _, err := w.Write([]byte("\n")); err != nil {
-			return 0, fmt.Errorf("failed to write newline: %w", err)
-		}
-		outputSize++ // Count the newline
-	}
-
 	// Show token statistics if requested (legacy --stats flag)
 	if c.opts.ShowStats {
 		c.showTokenStats(data, output)
@@ -244,6 +446,27 @@ func (c *Converter) writeTOON(w io.Writer, data interface{}) (int, error) {
 	return outputSize, nil
 }
 
+// toonOptions maps converter options onto TOON encoder/decoder options
+func (c *Converter) toonOptions() toon.Options {
+	return toon.Options{
+		Indent:        c.opts.Indent,
+		Delimiter:     c.opts.Delimiter,
+		UseTab:        c.opts.UseTab,
+		KeyFolding:    c.opts.KeyFolding,
+		FlattenDepth:  c.opts.FlattenDepth,
+		ExpandPaths:   c.opts.ExpandPaths,
+		PathCollision: c.opts.PathCollision,
+		SparseTabular: c.opts.SparseTabular,
+		SparseFill:    c.opts.SparseFill,
+
+		LengthMarker:    c.opts.LengthMarker,
+		OmitLengths:     c.opts.OmitLengths,
+		BlankLines:      c.opts.BlankLines,
+		Quoting:         c.opts.Quoting,
+		TrailingNewline: !c.opts.OmitTrailingNewline,
+	}
+}
+
 func (c *Converter) showTokenStats(original interface{}, toonOutput string) {
 	// Compare JSON vs TOON token usage
 	jsonData, err := json.Marshal(original)
@@ -282,12 +505,7 @@ func (c *Converter) showComparison(data interface{}, inputFormat, outputFormat s
 	_ = yamlEnc.Encode(data)
 	yamlSize := len(yamlBuf.String())
 
-	toonOpts := toon.Options{
-		Indent:    c.opts.Indent,
-		Delimiter: c.opts.Delimiter,
-		UseTab:    c.opts.UseTab,
-	}
-	toonData, _ := toon.Encode(data, toonOpts)
+	toonData, _ := toon.Encode(data, c.toonOptions())
 	toonSize := len(toonData)
 
 	// Estimate tokens (rough: ~4 chars per token)
@@ -300,7 +518,7 @@ func (c *Converter) showComparison(data interface{}, inputFormat, outputFormat s
 	var inputName string
 
 	switch inputFormat {
-	case "json":
+	case "json", "jsonl":
 		inputTokens = jsonTokens
 		inputName = "JSON"
 	case "yaml":
@@ -319,7 +537,7 @@ func (c *Converter) showComparison(data interface{}, inputFormat, outputFormat s
 	var outputName string
 
 	switch outputFormat {
-	case "json":
+	case "json", "jsonl":
 		outputTokens = jsonTokens
 		outputName = "JSON"
 	case "yaml":
@@ -328,6 +546,9 @@ func (c *Converter) showComparison(data interface{}, inputFormat, outputFormat s
 	case "toon":
 		outputTokens = toonTokens
 		outputName = "TOON"
+	default:
+		outputTokens = outputSize / 4
+		outputName = strings.ToUpper(outputFormat)
 	}
 
 	// Calculate reduction/increase
@@ -356,22 +577,3 @@ func estimateTokens(s string) int {
 	// This is a simplification; real tokenization is more complex
 	return len(s) / 4
 }
-
-func detectFormat(data []byte) string {
-	trimmed := strings.TrimSpace(string(data))
-
-	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
-		return "json"
-	}
-
-	if strings.Contains(trimmed, "---") || strings.Contains(trimmed, ":") {
-		// Could be YAML or TOON
-		// Check for TOON-specific patterns
-		if strings.Contains(trimmed, "[") && strings.Contains(trimmed, "]{") {
-			return "toon"
-		}
-		return "yaml"
-	}
-
-	return "json" // default
-}
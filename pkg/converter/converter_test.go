@@ -1,6 +1,12 @@
 package converter
 
 import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -48,21 +54,1063 @@ func TestReadWithSizeLimit(t *testing.T) {
 	}
 }
 
-func TestDetectFormat(t *testing.T) {
+func TestDetect(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
 		{`{"key": "value"}`, "json"},
 		{`[1, 2, 3]`, "json"},
-		{`key: value`, "yaml"},
+		{"1\n2\n3", "json"},
+		{`"a: b"`, "json"},
+		{"{\"a\": [1, 2]}\n{\"a\": [3]}", "json"},
+		{`key: value`, "toon"},
+		{"name: tq\ntags[3]: a,b,c", "toon"},
 		{`users[2]{id,name}:`, "toon"},
+		{"[3]{id,name}:\n  1,a\n  2,b\n  3,c", "toon"},
+		{"[2]: 1,2", "toon"},
+		{"# comment\nkey: value", "yaml"},
+		{"items:\n  - a\n  - b", "yaml"},
+		{"---\na: 1", "yaml"},
+		{"ports: [80, 443]", "yaml"},
+		{"name: 'Alice'", "yaml"},
+		{"user:\n  name: 'Alice'\n  role: admin", "yaml"},
+		{"{a: 1}", "json"},
+		{"{bad", "json"},
+		{`{"a": tru}`, "json"},
+		{`{"a":1,}`, "json"},
+		{"[1,\n2,", "json"},
+		{"# config\ntitle = \"tq\"", "toml"},
+		{"[server]\nhost = \"localhost\"", "toml"},
+		{"[[products]]\nname = \"Nail\"", "toml"},
+		{"[server]\nhost = localhost ; comment", "ini"},
+		{"server.port=8080\nserver.host=local host", "properties"},
+		{"export PORT=8080\nHOST=local host", "dotenv"},
+		{"id,name\n1,Alice\n2,Bob", "csv"},
+		{"id\tname\n1\tAlice", "tsv"},
+		{"[\"a\"]", "json"},
+		{"<?xml version=\"1.0\"?>\n<rss/>", "xml"},
+		{"\x82\xa1a\x01\xa1b\x02", "msgpack"},
+		{"\xa2\x61a\x01\x61b\x02", "cbor"},
+		{"\xd9\xd9\xf7\x01", "cbor"},
 	}
 
 	for _, tt := range tests {
-		result := detectFormat([]byte(tt.input))
-		if result != tt.expected {
-			t.Errorf("detectFormat(%q) = %q, want %q", tt.input, result, tt.expected)
+		result := Detect([]byte(tt.input), "")
+		if result.Format != tt.expected {
+			t.Errorf("Detect(%q) = %v, want %q", tt.input, result, tt.expected)
 		}
 	}
+
+	// A TOON table cut off by the sample size still parses
+	long := "rows[1000]{id,name}:\n" + strings.Repeat("  1,name\n", SniffSize/9+1)
+	if d := Detect([]byte(long[:SniffSize]), ""); d.Format != "toon" {
+		t.Errorf("Expected toon for a truncated table, got %v", d)
+	}
+
+	// The file name wins over the content
+	if d := Detect([]byte(`{"a": 1}`), "data.yaml"); d.Format != "yaml" || d.Method != DetectExtension || d.Confidence != 1 {
+		t.Errorf("Expected yaml by extension, got %v", d)
+	}
+	if d := Detect([]byte("key: value"), ""); d.Method != DetectTrial || d.Confidence >= 1 {
+		t.Errorf("Expected a trial parse with less than full confidence, got %v", d)
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	conv := New(Options{
+		InputFormat:  "auto",
+		OutputFormat: "json",
+		MaxInputSize: 10, // Not applied when streaming rows
+	})
+
+	var names []interface{}
+	input := strings.NewReader("users[2]{id,name}:\n  1,Alice\n  2,Bob\n")
+	err := conv.ReadRows(input, func(row interface{}) error {
+		names = append(names, row.(map[string]interface{})["name"])
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadRows failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "Alice" || names[1] != "Bob" {
+		t.Errorf("Expected [Alice Bob], got %v", names)
+	}
+
+	conv = New(Options{InputFormat: "json"})
+	if err := conv.ReadRows(strings.NewReader("[]"), func(interface{}) error { return nil }); err == nil {
+		t.Error("Expected error for non-TOON input")
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	collect := func(opts Options, input string) ([]interface{}, error) {
+		var events []interface{}
+		err := New(opts).ReadEvents(strings.NewReader(input), func(event interface{}) error {
+			events = append(events, event)
+			return nil
+		})
+		return events, err
+	}
+
+	expected := []interface{}{
+		[]interface{}{[]interface{}{"a", 0}, float64(1)},
+		[]interface{}{[]interface{}{"a", 1}, float64(2)},
+		[]interface{}{[]interface{}{"a", 1}},
+		[]interface{}{[]interface{}{"b"}, map[string]interface{}{}},
+		[]interface{}{[]interface{}{"b"}},
+	}
+	events, err := collect(Options{InputFormat: "json"}, `{"a": [1, 2], "b": {}}`)
+	if err != nil {
+		t.Fatalf("ReadEvents failed: %v", err)
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("JSON events:\nwant %v\ngot  %v", expected, events)
+	}
+
+	// TOON numbers decode as int64; compare the shape only
+	events, err = collect(Options{InputFormat: "toon"}, "a[2]: 1,2\nb:\n")
+	if err != nil {
+		t.Fatalf("ReadEvents failed: %v", err)
+	}
+	if len(events) != len(expected) || !reflect.DeepEqual(events[4], expected[4]) {
+		t.Errorf("TOON events: got %v", events)
+	}
+
+	// Parse errors are returned, or reported as a final event
+	if _, err := collect(Options{InputFormat: "json"}, `{"a": [1,`); err == nil {
+		t.Error("Expected error for truncated JSON")
+	}
+	events, err = collect(Options{InputFormat: "json", StreamErrors: true}, `{"a": [1,`)
+	if err != nil {
+		t.Fatalf("Expected error event, got %v", err)
+	}
+	last := events[len(events)-1].([]interface{})
+	if _, ok := last[0].(string); !ok || !reflect.DeepEqual(last[1], []interface{}{"a", 1}) {
+		t.Errorf("Expected [message, path] event, got %v", last)
+	}
+}
+
+func TestValues(t *testing.T) {
+	read := func(opts Options, input string) []interface{} {
+		values, err := New(opts).Values(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Values failed: %v", err)
+		}
+		var result []interface{}
+		for {
+			value, err := values.Next()
+			if err == io.EOF {
+				return result
+			}
+			if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			result = append(result, value)
+		}
+	}
+
+	// Every document is read, skipping the empty one after a trailing ---
+	got := read(Options{InputFormat: "yaml"}, "a: 1\n---\na: 2\n---\n")
+	if len(got) != 2 {
+		t.Errorf("Expected 2 YAML documents, got %v", got)
+	}
+
+	got = read(Options{InputFormat: "json"}, "{\"a\":1} 2\n\"x\"")
+	if len(got) != 3 {
+		t.Errorf("Expected 3 JSON values, got %v", got)
+	}
+
+	// Malformed texts in a json-seq stream are skipped
+	got = read(Options{InputFormat: "json", Seq: true}, "\x1e{\"a\":1}\n\x1e{bad\n\x1e[2]\n")
+	expected := []interface{}{map[string]interface{}{"a": float64(1)}, []interface{}{float64(2)}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	if got := read(Options{InputFormat: "toon"}, "a: 1\n"); len(got) != 1 {
+		t.Errorf("Expected one TOON document, got %v", got)
+	}
+}
+
+func TestWriteDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"yaml", Options{OutputFormat: "yaml", Indent: 2}, "a: 1\n---\na: 2\n"},
+		{"toon", Options{OutputFormat: "toon", Indent: 2}, "a: 1\n---\na: 2\n"},
+		{"toon without trailing newline", Options{OutputFormat: "toon", Indent: 2, OmitTrailingNewline: true}, "a: 1\n---\na: 2"},
+		{"ndjson", Options{OutputFormat: "json", Compact: true}, "{\"a\":1}\n{\"a\":2}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			c := New(tt.opts)
+			for _, n := range []int{1, 2} {
+				if err := c.Write(&buf, map[string]interface{}{"a": n}); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+
+	// TOON documents read back as separate values, even with blank lines
+	// between their keys
+	docs := []interface{}{
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": int64(2)}},
+		map[string]interface{}{"a": int64(3)},
+		"text",
+	}
+	var buf strings.Builder
+	c := New(Options{OutputFormat: "toon", Indent: 2, BlankLines: "all"})
+	for _, doc := range docs {
+		if err := c.Write(&buf, doc); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	values, err := New(Options{InputFormat: "toon"}).Values(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Values failed: %v", err)
+	}
+	var got []interface{}
+	for {
+		value, err := values.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		got = append(got, value)
+	}
+	if !reflect.DeepEqual(got, docs) {
+		t.Errorf("Expected %v, got %v from:\n%s", docs, got, buf.String())
+	}
+}
+
+func TestJSONNonFinite(t *testing.T) {
+	data, err := New(Options{InputFormat: "toml"}).Read(strings.NewReader("a = inf\nb = -inf\nc = nan\nd = [1.5, nan]\n"))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	// As in jq: the largest finite floats for infinities, null for NaN
+	for format, want := range map[string]string{
+		"json":  `{"a":1.7976931348623157e+308,"b":-1.7976931348623157e+308,"c":null,"d":[1.5,null]}` + "\n",
+		"jsonl": `{"a":1.7976931348623157e+308,"b":-1.7976931348623157e+308,"c":null,"d":[1.5,null]}` + "\n",
+	} {
+		var buf strings.Builder
+		if err := New(Options{OutputFormat: format, Compact: true}).Write(&buf, data); err != nil {
+			t.Fatalf("%s: Write failed: %v", format, err)
+		}
+		if buf.String() != want {
+			t.Errorf("%s: expected %q, got %q", format, want, buf.String())
+		}
+	}
+	if d := data.(map[string]interface{})["d"].([]interface{}); !math.IsNaN(d[1].(float64)) {
+		t.Errorf("Expected the input to be left alone, got %v", d)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	input := "{\"id\":1}\n\n{bad\n{\"id\":2}\n"
+
+	values, err := New(Options{InputFormat: "jsonl"}).Values(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Values failed: %v", err)
+	}
+	if _, err := values.Next(); err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	_, err = values.Next()
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) || lineErr.Line != 3 {
+		t.Fatalf("Expected error on line 3, got %v", err)
+	}
+
+	// Skipped lines and lines over the size limit do not stop the stream
+	c := New(Options{InputFormat: "jsonl", Slurp: true, SkipBadLines: true, MaxInputSize: 10})
+	got, err := c.Read(strings.NewReader(input + "{\"long\":\"xxxxxxxx\"}\n{\"id\":3}"))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"id": float64(1)},
+		map[string]interface{}{"id": float64(2)},
+		map[string]interface{}{"id": float64(3)},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	var buf strings.Builder
+	if err := New(Options{OutputFormat: "jsonl"}).Write(&buf, expected); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if want := "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"; buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+
+	// Array records read from JSONL stay one record each
+	records := "[1,2]\n{\"a\":[3]}\n[]\n"
+	c = New(Options{InputFormat: "jsonl", OutputFormat: "jsonl"})
+	values, err = c.FileValues(strings.NewReader(records), "")
+	if err != nil {
+		t.Fatalf("FileValues failed: %v", err)
+	}
+	buf.Reset()
+	for {
+		v, err := values.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if err := c.Write(&buf, v); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if buf.String() != records {
+		t.Errorf("Expected %q, got %q", records, buf.String())
+	}
+}
+
+func TestCSV(t *testing.T) {
+	input := "id,name,zip,,name\n1,\"Smith, J\",007,x,dup\n2,Ann,,y\n"
+	got, err := New(Options{InputFormat: "csv"}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"id": int64(1), "name": "Smith, J", "zip": "007", "field4": "x", "name_2": "dup"},
+		map[string]interface{}{"id": int64(2), "name": "Ann", "zip": nil, "field4": "y"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	if _, err := New(Options{InputFormat: "csv"}).Read(strings.NewReader("a,b\n1,2,3\n")); err == nil {
+		t.Error("Expected error for a row longer than the header")
+	}
+
+	data := []interface{}{
+		map[string]interface{}{"id": 1, "user": map[string]interface{}{"name": "a", "tags": []interface{}{"x"}}},
+		map[string]interface{}{"id": 2, "note": "say \"hi\""},
+	}
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{"csv", Options{OutputFormat: "csv"}, "id,note,user.name,user.tags\n1,,a,\"[\"\"x\"\"]\"\n2,\"say \"\"hi\"\"\",,\n"},
+		{"tsv columns", Options{OutputFormat: "tsv", CSVColumns: []string{"user.name", "id"}}, "user.name\tid\na\t1\n\t2\n"},
+		{"always quote", Options{OutputFormat: "csv", CSVColumns: []string{"id"}, Quoting: "always"}, "\"id\"\n\"1\"\n\"2\"\n"},
+		{"no header", Options{OutputFormat: "csv", CSVColumns: []string{"id"}, CSVNoHeader: true}, "1\n2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			if err := New(tt.opts).Write(&buf, data); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+
+	if err := New(Options{OutputFormat: "csv"}).Write(io.Discard, "text"); err == nil {
+		t.Error("Expected error writing a string as CSV")
+	}
+
+	// Tables read back as written: header order, number text and null stay
+	for _, tt := range []struct {
+		format, input string
+		expected      interface{}
+	}{
+		{"csv", "name,id,size\nb,2,1e3\na,1,null\n", []interface{}{
+			map[string]interface{}{"name": "b", "id": int64(2), "size": "1e3"},
+			map[string]interface{}{"name": "a", "id": int64(1), "size": "null"},
+		}},
+		{"tsv", "note\tid\n\"quoted\t1\nx\\ty\t2\n", []interface{}{
+			map[string]interface{}{"note": "\"quoted", "id": int64(1)},
+			map[string]interface{}{"note": "x\ty", "id": int64(2)},
+		}},
+	} {
+		t.Run("round trip "+tt.format, func(t *testing.T) {
+			c := New(Options{InputFormat: tt.format, OutputFormat: tt.format})
+			got, err := c.Read(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+			var buf strings.Builder
+			if err := c.Write(&buf, got); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if buf.String() != tt.input {
+				t.Errorf("Expected %q, got %q", tt.input, buf.String())
+			}
+		})
+	}
+}
+
+func TestXML(t *testing.T) {
+	input := `<?xml version="1.0"?>
+<feed xmlns:dc="urn:dc" version="2">
+  <!-- comment -->
+  <entry id="1"><dc:title>One &amp; two</dc:title><empty/></entry>
+  <entry id="2">Text<b>x</b></entry>
+  <single><item>only</item></single>
+</feed>`
+
+	got, err := New(Options{InputFormat: "xml", XMLArrays: []string{"feed.single.item"}}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"feed": map[string]interface{}{
+			"@xmlns:dc": "urn:dc",
+			"@version":  "2",
+			"entry": []interface{}{
+				map[string]interface{}{"@id": "1", "dc:title": "One & two", "empty": nil},
+				map[string]interface{}{"@id": "2", "#text": "Text", "b": "x"},
+			},
+			"single": map[string]interface{}{"item": []interface{}{"only"}},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// Stripped namespaces and a custom mapping convention
+	opts := Options{InputFormat: "xml", XMLNamespaces: XMLNamespacesStrip, XMLAttrPrefix: "_", XMLTextKey: "value"}
+	got, err = New(opts).Read(strings.NewReader(`<a xmlns="urn:x" xmlns:p="urn:p" p:k="v">t<p:b/></a>`))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected = map[string]interface{}{"a": map[string]interface{}{"_k": "v", "value": "t", "b": nil}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	for _, bad := range []string{"<a><b></a>", "<a/><b/>", "<a>"} {
+		if _, err := New(Options{InputFormat: "xml"}).Read(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+
+	var buf strings.Builder
+	data := map[string]interface{}{
+		"feed": map[string]interface{}{
+			"@version": 2,
+			"entry":    []interface{}{map[string]interface{}{"#text": "a < b", "@id": "1"}, "plain"},
+			"empty":    nil,
+		},
+	}
+	if err := New(Options{OutputFormat: "xml", Indent: 2}).Write(&buf, data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<feed version="2">
+  <empty/>
+  <entry id="1">a &lt; b</entry>
+  <entry>plain</entry>
+</feed>
+`
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	// Arrays are wrapped in a single root element
+	for _, tt := range []struct {
+		data interface{}
+		want string
+	}{
+		{[]interface{}{1, "a"}, "<root><item>1</item><item>a</item></root>"},
+		{map[string]interface{}{"entry": []interface{}{1, 2}}, "<root><entry>1</entry><entry>2</entry></root>"},
+		{[]interface{}{}, "<root/>"},
+	} {
+		buf.Reset()
+		if err := New(Options{OutputFormat: "xml", Compact: true}).Write(&buf, tt.data); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if want := xml.Header + tt.want + "\n"; buf.String() != want {
+			t.Errorf("Expected %q, got %q", want, buf.String())
+		}
+	}
+
+	if err := New(Options{OutputFormat: "xml"}).Write(io.Discard, map[string]interface{}{"bad name": 1}); err == nil {
+		t.Error("Expected error for an invalid element name")
+	}
+}
+
+func TestBinary(t *testing.T) {
+	// {"b": bin 0x01 0x02, "n": 1, "t": timestamp 1s}
+	input := "\x83\xa1b\xc4\x02\x01\x02\xa1n\x01\xa1t\xd6\xff\x00\x00\x00\x01"
+
+	got, err := New(Options{InputFormat: "msgpack", OutputFormat: "json"}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := map[string]interface{}{"b": "AQI=", "n": int64(1), "t": "1970-01-01T00:00:01Z"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	tagged, err := New(Options{InputFormat: "msgpack", OutputFormat: "json", Binary: BinaryTagged}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected = map[string]interface{}{
+		"b": map[string]interface{}{"type": "binary", "value": "AQI="},
+		"n": int64(1),
+		"t": map[string]interface{}{"type": "timestamp", "value": "1970-01-01T00:00:01Z"},
+	}
+	if !reflect.DeepEqual(tagged, expected) {
+		t.Errorf("Expected %v, got %v", expected, tagged)
+	}
+
+	// Tagged values convert back to the original bytes
+	var buf strings.Builder
+	if err := New(Options{OutputFormat: "msgpack"}).Write(&buf, tagged); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if buf.String() != input {
+		t.Errorf("Expected %x, got %x", input, buf.String())
+	}
+
+	// JSON integers stay integers on the way to a binary format
+	got, err = New(Options{InputFormat: "json", OutputFormat: "cbor"}).Read(strings.NewReader(`{"id": 1, "f": 1.0}`))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	buf.Reset()
+	if err := New(Options{OutputFormat: "cbor"}).Write(&buf, got); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if want := "\xa2\x61f\xfb\x3f\xf0\x00\x00\x00\x00\x00\x00\x62id\x01"; buf.String() != want {
+		t.Errorf("Expected %x, got %x", want, buf.String())
+	}
+
+	// A stream of values, and a truncated one
+	values, err := New(Options{InputFormat: "cbor", OutputFormat: "json"}).Values(strings.NewReader("\x01\x02"))
+	if err != nil {
+		t.Fatalf("Values failed: %v", err)
+	}
+	for _, want := range []int64{1, 2} {
+		if v, err := values.Next(); err != nil || v != want {
+			t.Errorf("Expected %d, got %v (%v)", want, v, err)
+		}
+	}
+	if _, err := values.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if _, err := New(Options{InputFormat: "msgpack"}).Read(strings.NewReader("\x92\x01")); err == nil {
+		t.Error("Expected error for truncated MessagePack")
+	}
+}
+
+func TestTables(t *testing.T) {
+	data := []interface{}{
+		map[string]interface{}{"id": int64(1), "name": "a|b", "tags": []interface{}{"x", "<y>"}},
+		map[string]interface{}{"id": int64(22), "name": "two\nlines"},
+	}
+
+	var buf strings.Builder
+	if err := New(Options{OutputFormat: "markdown"}).Write(&buf, data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `| id  | name         | tags              |
+| --- | ------------ | ----------------- |
+| 1   | a\|b         | ["x","&lt;y&gt;"] |
+| 22  | two<br>lines |                   |
+`
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	// Objects are key/value tables, and documents are separated by a blank line
+	buf.Reset()
+	conv := New(Options{OutputFormat: "markdown", Compact: true})
+	for _, doc := range []interface{}{map[string]interface{}{"b": nil, "a": true}, "a &lt; b"} {
+		if err := conv.Write(&buf, doc); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	want = "| key | value |\n| --- | --- |\n| a | true |\n| b |  |\n\n| value |\n| --- |\n| a &amp;lt; b |\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%q\nGot:\n%q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := New(Options{OutputFormat: "html", Indent: 2, CSVColumns: []string{"name", "id"}}).Write(&buf, data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want = `<table>
+  <thead>
+    <tr><th>name</th><th>id</th></tr>
+  </thead>
+  <tbody>
+    <tr><td>a|b</td><td>1</td></tr>
+    <tr><td>two
+lines</td><td>22</td></tr>
+  </tbody>
+</table>
+`
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, buf.String())
+	}
+}
+
+func TestFormatForFile(t *testing.T) {
+	tests := map[string]string{
+		"settings.ini":      "ini",
+		"setup.CFG":         "ini",
+		".env":              "dotenv",
+		"config/.env.local": "dotenv",
+		"prod.env":          "dotenv",
+		".env.json":         "json",
+		"app.properties":    "properties",
+		"events.ndjson":     "jsonl",
+		"environment.yaml":  "yaml",
+		"data.toon":         "toon",
+		"notes.txt":         "",
+		"-":                 "",
+		"":                  "",
+	}
+	for name, want := range tests {
+		if got := FormatForFile(name); got != want {
+			t.Errorf("FormatForFile(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestINI(t *testing.T) {
+	input := `; comment
+name = top
+[server]
+host = "example.com" ; inline comment
+port=8080
+tags = a
+tags = b
+enabled
+[db]
+url: postgres://db ; c
+[db.pool]
+size = 4
+`
+	got, err := New(Options{InputFormat: "ini"}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"name": "top",
+		"server": map[string]interface{}{
+			"host": "example.com", "port": int64(8080), "tags": []interface{}{"a", "b"}, "enabled": nil,
+		},
+		"db": map[string]interface{}{"url": "postgres://db", "pool": map[string]interface{}{"size": int64(4)}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	var buf strings.Builder
+	data := map[string]interface{}{
+		"name": "top",
+		"server": map[string]interface{}{
+			"port": int64(8080), "version": "1.0", "note": " padded ", "tls": map[string]interface{}{"on": true},
+		},
+	}
+	if err := New(Options{OutputFormat: "ini"}).Write(&buf, data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `name = top
+
+[server]
+note = " padded "
+port = 8080
+version = "1.0"
+
+[server.tls]
+on = true
+`
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, buf.String())
+	}
+	back, err := New(Options{InputFormat: "ini"}).Read(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !reflect.DeepEqual(back, data) {
+		t.Errorf("Expected %v to read back, got %v", data, back)
+	}
+
+	for _, bad := range []interface{}{
+		map[string]interface{}{"a.b": map[string]interface{}{}},
+		map[string]interface{}{"a": map[string]interface{}{"[b]": map[string]interface{}{}}},
+	} {
+		if err := New(Options{OutputFormat: "ini"}).Write(io.Discard, bad); err == nil {
+			t.Errorf("Expected an error writing %v", bad)
+		}
+	}
+
+	for _, bad := range []string{"[unclosed\n", "= value\n", "a = 1\n[a]\n", "a = 1\n[a.b]\n", "[a..b]\n"} {
+		_, err := New(Options{InputFormat: "ini"}).Read(strings.NewReader(bad))
+		var lineErr *LineError
+		if !errors.As(err, &lineErr) {
+			t.Errorf("Expected a LineError for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestDotenv(t *testing.T) {
+	input := "# comment\nexport PORT=8080\nMSG=\"two\nlines \\\"quoted\\\"\"\nRAW='$HOME' # c\nNAME=app # c\nEMPTY=\nVERSION=\"1.0\"\n"
+	got, err := New(Options{InputFormat: "dotenv"}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"PORT": int64(8080), "MSG": "two\nlines \"quoted\"", "RAW": "$HOME", "NAME": "app", "EMPTY": nil, "VERSION": "1.0",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	var buf strings.Builder
+	if err := New(Options{OutputFormat: "dotenv"}).Write(&buf, got); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := "EMPTY=\nMSG=\"two\\nlines \\\"quoted\\\"\"\nNAME=app\nPORT=8080\nRAW=\"\\$HOME\"\nVERSION=\"1.0\"\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%q\nGot:\n%q", want, buf.String())
+	}
+
+	_, err = New(Options{InputFormat: "dotenv"}).Read(strings.NewReader("A=1\nB=\"open\n"))
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	input := "# comment\n! comment\nserver.port = 8080\nserver.name=caf\\u00e9 \\\n    au lait\nkey\\ with\\:colon: v\nemoji \\ud83d\\ude00\n"
+	got, err := New(Options{InputFormat: "properties"}).Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"server":         map[string]interface{}{"port": int64(8080), "name": "café au lait"},
+		"key with:colon": "v",
+		"emoji":          "😀",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	var buf strings.Builder
+	if err := New(Options{OutputFormat: "properties"}).Write(&buf, got); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := "emoji=\\ud83d\\ude00\nkey\\ with\\:colon=v\nserver.name=caf\\u00e9 au lait\nserver.port=8080\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	_, err = New(Options{InputFormat: "properties"}).Read(strings.NewReader("a=1\na.b=2\n"))
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) || lineErr.Line != 2 {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	input := `# Service
+base: &base
+  timeout: 30 # seconds
+  retries: 3
+server:
+  <<: *base
+  host: 'localhost' # bind address
+  tags: [web, api]
+  note: |
+    two
+    lines
+clients:
+  - settings: *base
+`
+	edit := func(change func(obj map[string]interface{})) string {
+		t.Helper()
+		conv := New(Options{InputFormat: "yaml", OutputFormat: "yaml", Indent: 2})
+		got, err := conv.Read(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		change(got.(map[string]interface{}))
+		var buf strings.Builder
+		if err := conv.Write(&buf, got); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		return buf.String()
+	}
+	server := func(obj map[string]interface{}) map[string]interface{} {
+		return obj["server"].(map[string]interface{})
+	}
+
+	tests := []struct {
+		name   string
+		change func(obj map[string]interface{})
+		want   string
+	}{
+		{"unchanged", func(obj map[string]interface{}) {}, input},
+		{"scalar", func(obj map[string]interface{}) {
+			server(obj)["host"] = "0.0.0.0"
+			server(obj)["note"] = "three\nlines\nnow\n"
+			server(obj)["tags"] = []interface{}{"web"}
+		}, strings.NewReplacer("'localhost'", "'0.0.0.0'", "two\n", "three\n", "lines\n", "lines\n    now\n", "[web, api]", "[web]").Replace(input)},
+		{"added_and_removed", func(obj map[string]interface{}) {
+			delete(server(obj), "note")
+			server(obj)["port"] = 8080
+		}, strings.Replace(input, "  note: |\n    two\n    lines\n", "  port: 8080\n", 1)},
+		{"merged_value_overridden", func(obj map[string]interface{}) {
+			server(obj)["retries"] = 5
+		}, strings.Replace(input, "  <<: *base\n", "  <<: *base\n  retries: 5\n", 1)},
+		{"anchor_changed", func(obj map[string]interface{}) {
+			obj["base"].(map[string]interface{})["timeout"] = 60
+		}, strings.NewReplacer("timeout: 30", "timeout: 60", "  <<: *base\n", "  <<: *base\n  timeout: 30\n",
+			"  - settings: *base\n", "  - settings:\n      retries: 3\n      timeout: 30\n").Replace(input)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := edit(tt.change); got != tt.want {
+				t.Errorf("Expected:\n%s\nGot:\n%s", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("unrelated_result", func(t *testing.T) {
+		conv := New(Options{InputFormat: "yaml", OutputFormat: "yaml", Indent: 2})
+		if _, err := conv.Read(strings.NewReader(input)); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		var buf strings.Builder
+		if err := conv.Write(&buf, "localhost"); err != nil || buf.String() != "localhost\n" {
+			t.Errorf("Expected the bare value, got %q (%v)", buf.String(), err)
+		}
+	})
+
+	t.Run("nodes", func(t *testing.T) {
+		conv := New(Options{InputFormat: "yaml", OutputFormat: "yaml", Indent: 2})
+		got, err := conv.Read(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if line := conv.Line([]interface{}{"server", "host"}); line != 7 {
+			t.Errorf("Expected server.host on line 7, got %d", line)
+		}
+		if line := conv.Line([]interface{}{"server", "retries"}); line != 4 {
+			t.Errorf("Expected the merged server.retries on line 4, got %d", line)
+		}
+		if line := conv.Line([]interface{}{"missing"}); line != 0 {
+			t.Errorf("Expected 0 for a missing key, got %d", line)
+		}
+		if comment := conv.HeadComment([]interface{}{"base"}); comment != "Service" {
+			t.Errorf("Expected head comment %q, got %q", "Service", comment)
+		}
+
+		conv.Explode([]interface{}{})
+		var buf strings.Builder
+		if err := conv.Write(&buf, got); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if out := buf.String(); strings.ContainsAny(out, "&*<") || !strings.Contains(out, "  - settings:\n      timeout: 30") {
+			t.Errorf("Expected anchors and aliases to be expanded, got:\n%s", out)
+		}
+	})
+}
+
+func TestKeepFormat(t *testing.T) {
+	conv := New(Options{InputFormat: "auto", OutputFormat: "json", KeepFormat: true, Indent: 2})
+	values, err := conv.FileValues(strings.NewReader("a = 1\n"), "config.toml")
+	if err != nil {
+		t.Fatalf("FileValues failed: %v", err)
+	}
+	value, err := values.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	var buf strings.Builder
+	if err := conv.Write(&buf, value); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if buf.String() != "a = 1\n" {
+		t.Errorf("Expected TOML output, got %q", buf.String())
+	}
+}
+
+func TestRawOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		values []interface{}
+		want   string
+	}{
+		{"toon", Options{OutputFormat: "toon", RawOutput: true}, []interface{}{"a: b", "c"}, "a: b\nc\n"},
+		{"json", Options{OutputFormat: "json", RawOutput: true, Compact: true}, []interface{}{"a", 1.0}, "a\n1\n"},
+		{"yaml", Options{OutputFormat: "yaml", RawOutput: true}, []interface{}{"a", "b"}, "a\nb\n"},
+		{"jsonl", Options{OutputFormat: "jsonl", RawOutput: true}, []interface{}{[]interface{}{"a", 1.0}}, "a\n1\n"},
+		{"join", Options{OutputFormat: "json", JoinOutput: true, Compact: true}, []interface{}{"a", map[string]interface{}{"b": 1.0}}, "a{\"b\":1}"},
+		{"nul", Options{OutputFormat: "json", RawOutput0: true, Compact: true}, []interface{}{"a", 1.0}, "a\x001\x00"},
+		// An array is written one element per output, nested values in the format
+		{"nul_array", Options{OutputFormat: "toon", RawOutput0: true}, []interface{}{[]interface{}{"a b", "c"}}, "a b\x00c\x00"},
+		{"json_array", Options{OutputFormat: "json", RawOutput: true, Compact: true},
+			[]interface{}{[]interface{}{"a", map[string]interface{}{"b": 1.0}, []interface{}{2.0}}}, "a\n{\"b\":1}\n[2]\n"},
+		{"yaml_array", Options{OutputFormat: "yaml", RawOutput: true},
+			[]interface{}{[]interface{}{map[string]interface{}{"b": 1.0}, "c"}}, "b: 1\nc\n"},
+		{"toon_array", Options{OutputFormat: "toon", RawOutput: true},
+			[]interface{}{[]interface{}{map[string]interface{}{"b": 1.0}, map[string]interface{}{"b": 2.0}}}, "b: 1\n---\nb: 2\n"},
+		{"empty_array", Options{OutputFormat: "json", RawOutput: true}, []interface{}{[]interface{}{}}, ""},
+		{"ascii", Options{OutputFormat: "json", ASCIIOutput: true, Compact: true}, []interface{}{"é😀"}, "\"\\u00e9\\ud83d\\ude00\"\n"},
+		{"ascii_raw", Options{OutputFormat: "toon", ASCIIOutput: true, RawOutput: true}, []interface{}{"é"}, "\\u00e9\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := New(tt.opts)
+			var buf strings.Builder
+			for _, value := range tt.values {
+				if err := conv.Write(&buf, value); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, buf.String())
+			}
+		})
+	}
+
+	conv := New(Options{OutputFormat: "json", RawOutput0: true})
+	if err := conv.Write(io.Discard, "a\x00b"); err == nil {
+		t.Error("Expected an error for a string containing NUL")
+	}
+}
+
+func TestRawInput(t *testing.T) {
+	read := func(opts Options, input string) []interface{} {
+		values, err := New(opts).Values(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("Values failed: %v", err)
+		}
+		var all []interface{}
+		for {
+			value, err := values.Next()
+			if err == io.EOF {
+				return all
+			}
+			if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			all = append(all, value)
+		}
+	}
+
+	lines := read(Options{InputFormat: "auto", RawInput: true}, "{\"a\": 1}\n\nb")
+	if !reflect.DeepEqual(lines, []interface{}{`{"a": 1}`, "", "b"}) {
+		t.Errorf("Unexpected lines: %#v", lines)
+	}
+	whole := read(Options{InputFormat: "auto", RawInput: true, Slurp: true}, "a\nb\n")
+	if !reflect.DeepEqual(whole, []interface{}{"a\nb\n"}) {
+		t.Errorf("Unexpected slurped input: %#v", whole)
+	}
+}
+
+func TestColor(t *testing.T) {
+	data := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"id": 1.0, "name": "Al, B", "ok": true},
+			map[string]interface{}{"id": 2.0, "name": "C", "ok": nil},
+		},
+		"tags": []interface{}{"a", false},
+		"text": "one\ntwo\n",
+	}
+	sgr := regexp.MustCompile("\x1b\\[[0-9;]*m")
+	palette := Palette{Null: "1", False: "2", True: "3", Number: "4", String: "5", Array: "6",
+		Object: "7", Key: "8", Header: "9", Delimiter: "10", Comment: "11"}
+
+	tests := []struct {
+		format string
+		want   []string // Colored tokens expected in the output
+	}{
+		{"json", []string{"\x1b[8m\"users\"\x1b[0m", "\x1b[3mtrue\x1b[0m", "\x1b[1mnull\x1b[0m", "\x1b[6m[\x1b[0m"}},
+		{"yaml", []string{"\x1b[8musers\x1b[0m", "\x1b[6m-\x1b[0m", "\x1b[2mfalse\x1b[0m", "\x1b[5m  one\x1b[0m"}},
+		{"toon", []string{"\x1b[9m[2]{id,name,ok}\x1b[0m", "\x1b[4m1\x1b[0m", "\x1b[10m,\x1b[0m", "\x1b[5m\"Al, B\"\x1b[0m"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var plain, colored strings.Builder
+			if err := New(Options{OutputFormat: tt.format, Indent: 2}).Write(&plain, data); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			conv := New(Options{OutputFormat: tt.format, Indent: 2, Color: true, Palette: &palette})
+			if err := conv.Write(&colored, data); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			if got := sgr.ReplaceAllString(colored.String(), ""); got != plain.String() {
+				t.Errorf("Colors changed the text:\n%s\nwant:\n%s", got, plain.String())
+			}
+			for _, token := range tt.want {
+				if !strings.Contains(colored.String(), token) {
+					t.Errorf("Expected %q in %q", token, colored.String())
+				}
+			}
+		})
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	p, err := ParsePalette("1;31::0;32")
+	if err != nil {
+		t.Fatalf("ParsePalette failed: %v", err)
+	}
+	if p.Null != "1;31" || p.False != DefaultPalette.False || p.True != "0;32" || p.Key != DefaultPalette.Key {
+		t.Errorf("Unexpected palette: %+v", p)
+	}
+
+	for _, spec := range []string{"red", "1:2:3:4:5:6:7:8:9:10:11:12"} {
+		if _, err := ParsePalette(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestFileEventsByExtension(t *testing.T) {
+	// "a: 0x1F" reads as TOON from its content; the extension says YAML
+	conv := New(Options{InputFormat: "auto"})
+	var events []interface{}
+	err := conv.FileEvents(strings.NewReader("a: 0x1F\n"), "config.yaml", func(event interface{}) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FileEvents failed: %v", err)
+	}
+	want := []interface{}{[]interface{}{[]interface{}{"a"}, 31}, []interface{}{[]interface{}{"a"}}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Expected %v, got %v", want, events)
+	}
+
+	err = conv.FileRows(strings.NewReader("[1]"), "data.json", func(interface{}) error { return nil })
+	if err == nil {
+		t.Error("Expected FileRows to reject a .json file")
+	}
 }
@@ -0,0 +1,358 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ssccio/tq/pkg/toon"
+)
+
+// numberPattern matches JSON number literals. Cells such as "007" or "1e"
+// are not numbers and stay strings.
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// csvDelimiter returns the field delimiter for csv or tsv
+func csvDelimiter(format string) rune {
+	if format == "tsv" {
+		return '\t'
+	}
+	return ','
+}
+
+// readCSV reads a CSV or TSV table as an array of objects keyed by the
+// header row, or as an array of arrays with CSVNoHeader. Empty header cells
+// are named after their column (field3) and repeated names get a suffix
+// (name_2). A row with more cells than the header is an error; missing
+// trailing cells are left out of the object. The header is kept so output
+// can write the columns in the same order.
+func (c *Converter) readCSV(r io.Reader, format string) (interface{}, error) {
+	next := csvRecords(r)
+	if format == "tsv" {
+		next = tsvRecords(r)
+	}
+
+	var header []string
+	rows := make([]interface{}, 0)
+	for {
+		record, line, err := next()
+		if err == io.EOF {
+			c.header = header
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", strings.ToUpper(format), err)
+		}
+
+		if c.opts.CSVNoHeader {
+			row := make([]interface{}, len(record))
+			for i, cell := range record {
+				row[i] = c.cellValue(cell)
+			}
+			rows = append(rows, row)
+			continue
+		}
+
+		if header == nil {
+			header = csvHeader(record)
+			continue
+		}
+		if len(record) > len(header) {
+			return nil, fmt.Errorf("failed to parse %s: %w", strings.ToUpper(format),
+				&LineError{Line: line, Err: fmt.Errorf("row has %d fields, header has %d", len(record), len(header))})
+		}
+		row := make(map[string]interface{}, len(record))
+		for i, cell := range record {
+			row[header[i]] = c.cellValue(cell)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// csvRecords returns a function reading one CSV record at a time, with the
+// line it starts on
+func csvRecords(r io.Reader) func() ([]string, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return func() ([]string, int, error) {
+		record, err := reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				err = &LineError{Line: parseErr.Line, Err: parseErr.Err}
+			}
+			return nil, 0, err
+		}
+		line, _ := reader.FieldPos(0)
+		return record, line, nil
+	}
+}
+
+// tsvRecords returns a function reading one TSV record at a time. Cells
+// are split on tabs without quoting; the escapes \t, \n, \r and \\ stand
+// for the characters a cell cannot hold. Blank lines are skipped.
+func tsvRecords(r io.Reader) func() ([]string, int, error) {
+	br := bufio.NewReader(r)
+	line := 0
+	return func() ([]string, int, error) {
+		for {
+			text, err := readLine(br, 0)
+			if err != nil {
+				return nil, 0, err
+			}
+			line++
+			text = bytes.TrimSuffix(text, []byte("\r"))
+			if len(text) == 0 {
+				continue
+			}
+			record := strings.Split(string(text), "\t")
+			for i, cell := range record {
+				record[i] = tsvUnescaper.Replace(cell)
+			}
+			return record, line, nil
+		}
+	}
+}
+
+var (
+	tsvEscaper   = strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	tsvUnescaper = strings.NewReplacer(`\\`, "\\", `\t`, "\t", `\n`, "\n", `\r`, "\r")
+)
+
+// csvHeader names every column of a header row uniquely
+func csvHeader(record []string) []string {
+	header := make([]string, len(record))
+	seen := make(map[string]int, len(record))
+	for i, name := range record {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = fmt.Sprintf("field%d", i+1)
+		}
+		seen[name]++
+		if n := seen[name]; n > 1 {
+			name = fmt.Sprintf("%s_%d", name, n)
+		}
+		header[i] = name
+	}
+	return header
+}
+
+// csvValue infers the type of a cell: numbers, true/false, and null or an
+// empty cell as null. With CSVNoInfer every cell is a string.
+func (c *Converter) csvValue(cell string) interface{} {
+	if c.opts.CSVNoInfer {
+		return cell
+	}
+	switch cell {
+	case "", "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if numberPattern.MatchString(cell) {
+		if n, err := strconv.ParseInt(cell, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(cell, 64); err == nil {
+			return f
+		}
+	}
+	return cell
+}
+
+// cellValue is csvValue for a CSV/TSV cell, which stays a string unless
+// it is written back the same: null and numbers such as 1e3 or 1.50 keep
+// their text
+func (c *Converter) cellValue(cell string) interface{} {
+	v := c.csvValue(cell)
+	if scalarText(v) != cell {
+		return cell
+	}
+	return v
+}
+
+// writeCSV writes an array of objects as a CSV or TSV table. Nested objects
+// are flattened into dotted column names (user.name) and arrays are written
+// as JSON text. The columns are CSVColumns, or else every key in the order
+// of the CSV/TSV input's header and then sorted; later documents reuse the
+// columns of the first, which is the only one with a header row. An array
+// of arrays is written as rows as is. TSV cells are escaped rather than
+// quoted.
+func (c *Converter) writeCSV(w io.Writer, data interface{}, format string) (int, error) {
+	items, ok := data.([]interface{})
+	if !ok {
+		items = []interface{}{data}
+	}
+
+	var rows [][]string
+	var flat []map[string]interface{}
+	for _, item := range items {
+		switch v := item.(type) {
+		case map[string]interface{}:
+			row := make(map[string]interface{})
+			flatten(row, "", v)
+			flat = append(flat, row)
+		case []interface{}:
+			cells := make([]string, len(v))
+			for i, cell := range v {
+				cells[i] = scalarText(cell)
+			}
+			rows = append(rows, cells)
+		default:
+			return 0, fmt.Errorf("%s output requires an array of objects or arrays, got %s",
+				strings.ToUpper(format), typeName(item))
+		}
+	}
+	if len(flat) > 0 && len(rows) > 0 {
+		return 0, fmt.Errorf("%s output cannot mix objects and arrays in one table", strings.ToUpper(format))
+	}
+
+	if len(flat) > 0 {
+		header := c.documents == 0 && !c.opts.CSVNoHeader
+		if c.csvColumns == nil {
+			c.csvColumns = c.opts.CSVColumns
+			if len(c.csvColumns) == 0 {
+				c.csvColumns = csvColumns(flat, c.header)
+			}
+		}
+		if header {
+			rows = append(rows, c.csvColumns)
+		}
+		for _, row := range flat {
+			cells := make([]string, len(c.csvColumns))
+			for i, col := range c.csvColumns {
+				if v, ok := row[col]; ok {
+					cells[i] = scalarText(v)
+				}
+			}
+			rows = append(rows, cells)
+		}
+	}
+
+	var buf strings.Builder
+	delim := string(csvDelimiter(format))
+	always := c.opts.Quoting == toon.QuoteAlways
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				buf.WriteString(delim)
+			}
+			if format == "tsv" {
+				cell = tsvEscaper.Replace(cell)
+			} else if always || csvNeedsQuotes(cell, delim) {
+				cell = `"` + strings.ReplaceAll(cell, `"`, `""`) + `"`
+			}
+			buf.WriteString(cell)
+		}
+		buf.WriteString("\n")
+	}
+
+	output := buf.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", strings.ToUpper(format), err)
+	}
+	return len(output), nil
+}
+
+// flatten copies the leaves of obj into row under dotted keys
+func flatten(row map[string]interface{}, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		key := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(row, key+".", nested)
+			continue
+		}
+		row[key] = v
+	}
+}
+
+// csvColumns returns every key of the rows: those in header first, in its
+// order, and then the rest sorted
+func csvColumns(rows []map[string]interface{}, header []string) []string {
+	seen := make(map[string]bool)
+	var rest []string
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				rest = append(rest, k)
+			}
+		}
+	}
+
+	columns := make([]string, 0, len(rest))
+	for _, k := range header {
+		if seen[k] {
+			columns = append(columns, k)
+			delete(seen, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		if seen[k] {
+			columns = append(columns, k)
+		}
+	}
+	return columns
+}
+
+// scalarText formats a value as CSV cell or XML text; null is empty and
+// arrays and objects are written as JSON
+func scalarText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(data)
+	}
+}
+
+// csvNeedsQuotes reports whether a cell must be quoted to read back as is
+func csvNeedsQuotes(cell, delim string) bool {
+	if cell == "" {
+		return false
+	}
+	return strings.ContainsAny(cell, delim+"\"\r\n") || cell[0] == ' ' || cell[len(cell)-1] == ' '
+}
+
+// typeName names the JSON type of v for error messages
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return "number"
+	}
+}
@@ -0,0 +1,403 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ssccio/tq/pkg/cbor"
+	"github.com/ssccio/tq/pkg/msgpack"
+	"github.com/ssccio/tq/pkg/toml"
+	"github.com/ssccio/tq/pkg/toon"
+	"gopkg.in/yaml.v3"
+)
+
+// SniffSize is how much of an input is read to detect its format
+const SniffSize = 4096
+
+// How a format was decided
+const (
+	DetectOption    = "option"      // Given explicitly (-i)
+	DetectExtension = "extension"   // Implied by the file name
+	DetectContent   = "content"     // Telltale content, such as a TOON array header
+	DetectTrial     = "trial parse" // The likeliest format that parses the sample
+	DetectDefault   = "default"     // Nothing matched; JSON is assumed
+)
+
+// Detection is the outcome of input format detection
+type Detection struct {
+	Format     string
+	Confidence float64 // From 0 (a guess) to 1 (certain)
+	Method     string  // One of the Detect* methods
+}
+
+func (d Detection) String() string {
+	return fmt.Sprintf("%s (%s, confidence %.2f)", d.Format, d.Method, d.Confidence)
+}
+
+// Detect works out the format of an input from its file name, if any, and
+// the first SniffSize bytes of its content. An extension settles it;
+// otherwise every format is scored on telltale content, a clear winner is
+// taken as is, and close calls go to the likeliest format that parses the
+// sample.
+func Detect(sample []byte, name string) Detection {
+	if format := FormatForFile(name); format != "" {
+		return Detection{Format: format, Confidence: 1, Method: DetectExtension}
+	}
+	return detectContent(sample)
+}
+
+// extensionFormats maps file extensions to input formats
+var extensionFormats = map[string]string{
+	".json":       "json",
+	".jsonl":      "jsonl",
+	".ndjson":     "jsonl",
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".toon":       "toon",
+	".toml":       "toml",
+	".xml":        "xml",
+	".csv":        "csv",
+	".tsv":        "tsv",
+	".ini":        "ini",
+	".cfg":        "ini",
+	".env":        "dotenv",
+	".properties": "properties",
+	".msgpack":    "msgpack",
+	".cbor":       "cbor",
+}
+
+// FormatForFile returns the input format implied by a file name, or "" if
+// the name does not settle it. Besides the usual extensions, .env.local
+// and the like are dotenv files.
+func FormatForFile(name string) string {
+	if name == "" || name == "-" {
+		return ""
+	}
+	base := strings.ToLower(filepath.Base(name))
+	if format, ok := extensionFormats[filepath.Ext(base)]; ok {
+		return format
+	}
+	if strings.HasPrefix(base, ".env.") {
+		return "dotenv"
+	}
+	return ""
+}
+
+// candidate is a format with a score for how well the content fits it
+type candidate struct {
+	format string
+	score  float64
+}
+
+func detectContent(sample []byte) Detection {
+	truncated := len(sample) >= SniffSize
+	sample = bytes.TrimPrefix(sample, []byte("\xef\xbb\xbf"))
+	if !isText(sample, truncated) {
+		return detectBinary(sample, truncated)
+	}
+
+	// Only whole lines are sniffed and parsed
+	text := string(sample)
+	if i := strings.LastIndexByte(text, '\n'); truncated && i >= 0 {
+		text = text[:i+1]
+	}
+	trimmed := strings.TrimSpace(text)
+	switch {
+	case trimmed == "":
+		return Detection{Format: "json", Method: DetectDefault}
+	case trimmed[0] == '<':
+		return Detection{Format: "xml", Confidence: 0.95, Method: DetectContent}
+	case trimmed[0] == recordSeparator:
+		return Detection{Format: "json", Confidence: 0.95, Method: DetectContent}
+	}
+
+	candidates := scoreText(trimmed)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	best := candidates[0]
+	if best.score >= 0.9 {
+		return Detection{Format: best.format, Confidence: best.score, Method: DetectContent}
+	}
+	for _, cand := range candidates {
+		if cand.score > 0 && trialParse(cand.format, text, truncated) {
+			return Detection{Format: cand.format, Confidence: (cand.score + 1) / 2, Method: DetectTrial}
+		}
+	}
+	return Detection{Format: best.format, Confidence: best.score, Method: DetectContent}
+}
+
+// isText reports whether sample is UTF-8 text without control characters
+// other than whitespace and RS. A character cut off by the end of a
+// truncated sample is allowed.
+func isText(sample []byte, truncated bool) bool {
+	for i := 0; i < len(sample); {
+		r, size := utf8.DecodeRune(sample[i:])
+		if r == utf8.RuneError && size == 1 {
+			return truncated && len(sample)-i < utf8.UTFMax
+		}
+		if r < 0x20 && !strings.ContainsRune("\t\n\r\f\x1e", r) || r == 0x7f {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+var (
+	// toonHeader matches a TOON array header: tags[3]: or users[2]{id,name}:
+	toonHeader = regexp.MustCompile(`^\s*(- )?("(?:[^"\\]|\\.)*"|[A-Za-z_][\w.]*)?\[#?\d+[,|\t]?\](\{[^}]*\})?:`)
+	// keyColon matches a "key: value" or "key:" line
+	keyColon = regexp.MustCompile(`^\s*(- )?("(?:[^"\\]|\\.)*"|[^\s#"'{\[][^:]*):(\s|$)`)
+	// yamlOnly matches lines that are YAML but not TOON: document markers,
+	// directives, list items, flow collections, anchors, aliases, tags,
+	// block scalars, single-quoted scalars and ~
+	yamlOnly = regexp.MustCompile(`^(---|\.\.\.|%YAML|%TAG)(\s|$)|^\s*- |:\s+(['\[{&*!|>]|~\s*$)`)
+	// dotenvLine matches KEY=value, optionally exported
+	dotenvLine = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_]*=`)
+	// propertyLine matches key=value or key: value with a dotted key
+	propertyLine = regexp.MustCompile(`^[^\s=:#!\[]+\.[^\s=:]+\s*[=:]`)
+	// iniSection matches an INI [section] header
+	iniSection = regexp.MustCompile(`^\[\s*[A-Za-z_][^\[\],]*\]\s*([;#].*)?$`)
+)
+
+// scoreText scores text against each text format, in order of preference
+// for ties
+func scoreText(text string) []candidate {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+	}
+
+	var toonHeaders, yamlMarks, keyLines, comments, dotenvLines, propLines, sections int
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case toonHeader.MatchString(line):
+			toonHeaders++
+		case strings.HasPrefix(trimmed, "#"):
+			comments++
+			continue
+		case yamlOnly.MatchString(line):
+			yamlMarks++
+		}
+		if keyColon.MatchString(line) {
+			keyLines++
+		}
+		if dotenvLine.MatchString(trimmed) {
+			dotenvLines++
+		}
+		if propertyLine.MatchString(trimmed) || strings.HasPrefix(trimmed, "!") {
+			propLines++
+		}
+		if iniSection.MatchString(trimmed) {
+			sections++
+		}
+	}
+	significant := len(lines) - comments
+
+	jsonScore, toonScore, yamlScore, tomlScore := 0.0, 0.2, 0.3, 0.0
+	c := text[0]
+	bracketed := (c == '{' || c == '[') && toonHeaders == 0
+	switch {
+	case bracketed:
+		// JSON, or else an INI/TOML section; the JSON trial parse tells
+		// them apart
+		jsonScore = 0.85
+	case c == '"' || c == '-' && len(text) > 1 && text[1] >= '0' && text[1] <= '9' || c >= '0' && c <= '9',
+		strings.HasPrefix(text, "true"), strings.HasPrefix(text, "false"), strings.HasPrefix(text, "null"):
+		jsonScore = 0.6
+	}
+
+	if !bracketed {
+		switch {
+		case toonHeaders > 0:
+			toonScore = 0.95
+		case yamlMarks > 0:
+			yamlScore = 0.9
+		case keyLines > 0 && comments > 0:
+			// TOON has no comments
+			yamlScore = 0.75
+		case keyLines > 0:
+			// Plain key: value documents read the same as TOON and YAML
+			toonScore, yamlScore = 0.6, 0.55
+		}
+	}
+	if isTOML(text) {
+		tomlScore = 0.8
+	}
+
+	csvScore, tsvScore := delimited(lines, ','), delimited(lines, '\t')
+	if keyLines > 0 {
+		csvScore, tsvScore = csvScore/2, tsvScore/2
+	}
+
+	var iniScore, propScore, dotenvScore float64
+	if sections > 0 {
+		iniScore = 0.6
+	}
+	if propLines == significant && significant > 0 && sections == 0 {
+		propScore = 0.65
+	}
+	if dotenvLines == significant && significant > 0 {
+		dotenvScore = 0.7
+	}
+
+	// Any line parses as a TOON or YAML scalar, so bracketed text that is
+	// not JSON is left to the JSON reader to report, unless it has sections
+	if bracketed {
+		return []candidate{{"json", jsonScore}, {"toml", tomlScore}, {"ini", iniScore}}
+	}
+
+	return []candidate{
+		{"json", jsonScore}, {"toon", toonScore}, {"yaml", yamlScore}, {"toml", tomlScore},
+		{"csv", csvScore}, {"tsv", tsvScore}, {"properties", propScore}, {"ini", iniScore},
+		{"dotenv", dotenvScore},
+	}
+}
+
+// delimited scores lines as a table: at least two rows with the same
+// number (two or more) of delim-separated fields
+func delimited(lines []string, delim rune) float64 {
+	if len(lines) < 2 {
+		return 0
+	}
+	reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	reader.Comma = delim
+	reader.LazyQuotes = delim == '\t'
+	records, err := reader.ReadAll()
+	if err != nil || len(records) < 2 || len(records[0]) < 2 {
+		return 0
+	}
+	if delim == '\t' {
+		return 0.75
+	}
+	return 0.7
+}
+
+// trialParse reports whether text parses as format. text ends on a whole
+// line; when it was cut from a longer input, errors caused by the missing
+// rest are forgiven.
+func trialParse(format, text string, truncated bool) bool {
+	c := New(Options{})
+	var err error
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(strings.NewReader(text))
+		for err == nil {
+			var v interface{}
+			err = decoder.Decode(&v)
+		}
+		return err == io.EOF || truncated && err == io.ErrUnexpectedEOF
+	case "toon":
+		_, err = toon.Decode(text)
+		var syntaxErr *toon.SyntaxError
+		if truncated && errors.As(err, &syntaxErr) && syntaxErr.Code == toon.CodeLengthMismatch {
+			return true
+		}
+	case "yaml":
+		decoder := yaml.NewDecoder(strings.NewReader(text))
+		for err == nil {
+			var node yaml.Node
+			err = decoder.Decode(&node)
+		}
+		return err == io.EOF
+	case "toml":
+		_, err = toml.Decode(text)
+	case "csv", "tsv":
+		// Scoring already read the table
+	case "ini":
+		_, err = c.readINI(strings.NewReader(text))
+	case "properties":
+		_, err = c.readProperties(strings.NewReader(text))
+	case "dotenv":
+		_, err = c.readDotenv(strings.NewReader(text))
+	}
+	return err == nil
+}
+
+// detectBinary tells MessagePack from CBOR by decoding the sample as each
+func detectBinary(sample []byte, truncated bool) Detection {
+	// Self-described CBOR (tag 55799)
+	if bytes.HasPrefix(sample, []byte{0xd9, 0xd9, 0xf7}) {
+		return Detection{Format: "cbor", Confidence: 1, Method: DetectContent}
+	}
+
+	decodes := func(decode func(interface{}) error) bool {
+		for {
+			var v interface{}
+			err := decode(&v)
+			if err == io.EOF {
+				return true
+			}
+			if err != nil {
+				return truncated && err == io.ErrUnexpectedEOF
+			}
+		}
+	}
+	isMsgpack := decodes(msgpack.NewDecoder(bytes.NewReader(sample)).Decode)
+	isCBOR := decodes(cbor.NewDecoder(bytes.NewReader(sample)).Decode)
+
+	switch {
+	case isMsgpack && isCBOR:
+		// A leading CBOR map or tag reads in MessagePack as a short string,
+		// which seldom starts a document
+		if b := sample[0]; b >= 0xa0 && b <= 0xbf || b >= 0xd8 && b <= 0xdb {
+			return Detection{Format: "cbor", Confidence: 0.6, Method: DetectTrial}
+		}
+		return Detection{Format: "msgpack", Confidence: 0.6, Method: DetectTrial}
+	case isMsgpack:
+		return Detection{Format: "msgpack", Confidence: 0.8, Method: DetectTrial}
+	case isCBOR:
+		return Detection{Format: "cbor", Confidence: 0.8, Method: DetectTrial}
+	}
+	return Detection{Format: "json", Method: DetectDefault}
+}
+
+var (
+	tomlKeyValue = regexp.MustCompile(`^([A-Za-z0-9_-]+|"[^"]*"|'[^']*')(\s*\.\s*([A-Za-z0-9_-]+|"[^"]*"|'[^']*'))*\s*=`)
+	tomlHeader   = regexp.MustCompile(`^\[\[?\s*[A-Za-z0-9_."' -]+\]\]?\s*(#.*)?$`)
+)
+
+// isTOML reports whether the first line that is not blank or a comment is
+// a key = value pair, or a [table] header followed by one
+func isTOML(s string) bool {
+	header := false
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tomlKeyValue.MatchString(line) {
+			return true
+		}
+		if header || !tomlHeader.MatchString(line) {
+			return false
+		}
+		header = true
+	}
+	return false
+}
+
+// debugFormat reports how the format of an input was decided
+func (c *Converter) debugFormat(name string, d Detection) {
+	if !c.opts.DebugFormat {
+		return
+	}
+	if name == "" || name == "-" {
+		name = "stdin"
+	}
+	fmt.Fprintf(os.Stderr, "tq: %s: %s\n", name, d)
+}
@@ -0,0 +1,158 @@
+package converter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// dotenvKey matches the variable names accepted in dotenv files
+var dotenvKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// dotenvPlain matches values written without quotes
+var dotenvPlain = regexp.MustCompile(`^[A-Za-z0-9_./:@,+%=-]*$`)
+
+// readDotenv reads a dotenv file as a flat object of KEY=value pairs. An
+// "export " prefix is ignored, # starts a comment at the start of a line or
+// after whitespace in an unquoted value, single-quoted values are literal,
+// and double-quoted values take \n, \t, \" and \\ escapes; either may span
+// lines. Variables are not expanded. Unquoted values are typed as in CSV;
+// quoted values are always strings.
+func (c *Converter) readDotenv(r io.Reader) (interface{}, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dotenv: %w", err)
+	}
+	src := strings.ReplaceAll(strings.TrimPrefix(string(data), "\ufeff"), "\r\n", "\n")
+
+	result := make(map[string]interface{})
+	line := 1
+	for len(src) > 0 {
+		var text string
+		text, src, _ = strings.Cut(src, "\n")
+		start := line
+		line++
+
+		text = strings.TrimSpace(text)
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, "export "))
+
+		key, value, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !found || !dotenvKey.MatchString(key) {
+			return nil, fmt.Errorf("failed to parse dotenv: %w",
+				&LineError{Line: start, Err: fmt.Errorf("expected KEY=value, got %q", text)})
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			// A quoted value runs to the closing quote, across lines if need be
+			quoted, rest, lines, err := dotenvQuoted(value, src)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse dotenv: %w", &LineError{Line: start, Err: err})
+			}
+			src = rest
+			line += lines
+			result[key] = quoted
+			continue
+		}
+
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		if i := strings.Index(value, "\t#"); i >= 0 {
+			value = value[:i]
+		}
+		result[key] = c.csvValue(strings.TrimSpace(value))
+	}
+	return result, nil
+}
+
+// dotenvQuoted reads a quoted value that starts value, taking more lines
+// from rest until the closing quote. It returns the unquoted text, what is
+// left of rest, and how many lines of rest it used.
+func dotenvQuoted(value, rest string) (string, string, int, error) {
+	quote := value[0]
+	text := value[1:]
+	lines := 0
+	for {
+		end := -1
+		for i := 0; i < len(text); i++ {
+			if quote == '"' && text[i] == '\\' {
+				i++
+				continue
+			}
+			if text[i] == quote {
+				end = i
+				break
+			}
+		}
+		if end >= 0 {
+			if after := strings.TrimSpace(text[end+1:]); after != "" && after[0] != '#' {
+				return "", "", 0, fmt.Errorf("unexpected %q after closing quote", after)
+			}
+			text = text[:end]
+			break
+		}
+		if rest == "" {
+			return "", "", 0, fmt.Errorf("unterminated quoted value")
+		}
+		var next string
+		next, rest, _ = strings.Cut(rest, "\n")
+		text += "\n" + next
+		lines++
+	}
+
+	if quote == '\'' {
+		return text, rest, lines, nil
+	}
+	return dotenvUnescaper.Replace(text), rest, lines, nil
+}
+
+var (
+	dotenvUnescaper = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`, `\$`, `$`)
+	dotenvEscaper   = strings.NewReplacer("\n", `\n`, "\r", `\r`, "\t", `\t`, `"`, `\"`, `\`, `\\`, `$`, `\$`)
+)
+
+// typedString reports whether v is a string that would be read back as a
+// number, boolean or null unless quoted
+func (c *Converter) typedString(v interface{}) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	read, ok := c.csvValue(s).(string)
+	return !ok || read != s
+}
+
+// writeDotenv writes a flat object as KEY=value lines in sorted order.
+// Values that are not plain words, or strings that would read back as
+// another type, are double-quoted; nested values are written as JSON and
+// null as an empty value.
+func (c *Converter) writeDotenv(w io.Writer, data interface{}) (int, error) {
+	obj, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("dotenv output requires an object, got %s", typeName(data))
+	}
+
+	var buf strings.Builder
+	for _, k := range sortedKeys(obj) {
+		if !dotenvKey.MatchString(k) {
+			return 0, fmt.Errorf("cannot write %q as a dotenv variable name", k)
+		}
+		text := scalarText(obj[k])
+		if !dotenvPlain.MatchString(text) || c.typedString(obj[k]) {
+			text = `"` + dotenvEscaper.Replace(text) + `"`
+		}
+		buf.WriteString(k + "=" + text + "\n")
+	}
+
+	output := buf.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write dotenv: %w", err)
+	}
+	return len(output), nil
+}
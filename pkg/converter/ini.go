@@ -0,0 +1,226 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// readINI reads an INI file as an object with a nested object per
+// [section], and a dotted [section.sub] nested in it. Keys before the first
+// section are top-level. Lines starting
+// with ; or # are comments, as is the rest of an unquoted value after " ;"
+// or " #". Unquoted values are typed as in CSV and quoted values are
+// strings; a repeated key becomes an array, and a key without "=" is null.
+func (c *Converter) readINI(r io.Reader) (interface{}, error) {
+	result := make(map[string]interface{})
+	section := result
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			name := ""
+			if end > 0 {
+				name = strings.TrimSpace(line[1:end])
+			}
+			if name == "" || strings.TrimSpace(line[end+1:]) != "" && !isComment(line[end+1:]) {
+				return nil, iniError(n, fmt.Errorf("invalid section header %q", line))
+			}
+			var err error
+			if section, err = openSection(result, name); err != nil {
+				return nil, iniError(n, err)
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if i := strings.IndexByte(line, ':'); i >= 0 && (!found || i < len(key)) {
+			key, value, found = line[:i], line[i+1:], true
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, iniError(n, fmt.Errorf("missing key before %q", line))
+		}
+		var v interface{}
+		if found {
+			v = c.iniValue(strings.TrimSpace(value))
+		}
+
+		switch existing := section[key].(type) {
+		case []interface{}:
+			section[key] = append(existing, v)
+		case map[string]interface{}:
+			return nil, iniError(n, fmt.Errorf("key %q conflicts with a section", key))
+		default:
+			if _, ok := section[key]; ok {
+				section[key] = []interface{}{existing, v}
+			} else {
+				section[key] = v
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read INI: %w", err)
+	}
+	return result, nil
+}
+
+// openSection returns the object of a section, creating it and the sections
+// it is nested in
+func openSection(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	section := root
+	for _, part := range strings.Split(name, ".") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("invalid section name %q", name)
+		}
+		switch existing := section[part].(type) {
+		case map[string]interface{}:
+			section = existing
+		case nil:
+			if _, ok := section[part]; ok {
+				return nil, fmt.Errorf("section %q conflicts with a key", name)
+			}
+			next := make(map[string]interface{})
+			section[part] = next
+			section = next
+		default:
+			return nil, fmt.Errorf("section %q conflicts with a key", name)
+		}
+	}
+	return section, nil
+}
+
+func iniError(line int, err error) error {
+	return fmt.Errorf("failed to parse INI: %w", &LineError{Line: line, Err: err})
+}
+
+// isComment reports whether s is blank up to a ; or # comment
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == ';' || s[0] == '#'
+}
+
+// iniValue strips quotes or an inline comment from a value and types it
+func (c *Converter) iniValue(s string) interface{} {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		if end := strings.IndexByte(s[1:], s[0]); end >= 0 && isComment(s[end+2:]) {
+			return s[1 : end+1]
+		}
+	}
+	for i := 1; i < len(s); i++ {
+		if (s[i] == ';' || s[i] == '#') && (s[i-1] == ' ' || s[i-1] == '\t') {
+			s = strings.TrimSpace(s[:i])
+			break
+		}
+	}
+	return c.csvValue(s)
+}
+
+// writeINI writes an object as INI: scalar and array keys first, then a
+// [section] per nested object, and a [section.sub] per object nested in
+// it. Arrays are written as repeated keys, and null as "key =".
+func (c *Converter) writeINI(w io.Writer, data interface{}) (int, error) {
+	obj, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("INI output requires an object, got %s", typeName(data))
+	}
+
+	var buf strings.Builder
+	if err := c.writeINISection(&buf, "", obj); err != nil {
+		return 0, err
+	}
+
+	output := buf.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write INI: %w", err)
+	}
+	return len(output), nil
+}
+
+// writeINISection writes the keys of a section, then its nested sections
+func (c *Converter) writeINISection(buf *strings.Builder, name string, obj map[string]interface{}) error {
+	var sections []string
+	for _, k := range sortedKeys(obj) {
+		if _, ok := obj[k].(map[string]interface{}); ok {
+			sections = append(sections, k)
+			continue
+		}
+		if err := c.writeINIKey(buf, k, obj[k]); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range sections {
+		// A dot would read back as a further level of nesting
+		if k == "" || strings.ContainsAny(k, "[].\n") || strings.TrimSpace(k) != k {
+			return fmt.Errorf("cannot write %q as an INI section name", k)
+		}
+		sub := k
+		if name != "" {
+			sub = name + "." + k
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("[" + sub + "]\n")
+		if err := c.writeINISection(buf, sub, obj[k].(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeINIKey writes one key, or one line per item of an array
+func (c *Converter) writeINIKey(buf *strings.Builder, key string, value interface{}) error {
+	if key == "" || strings.ContainsAny(key, "=:;#[\n") || strings.TrimSpace(key) != key {
+		return fmt.Errorf("cannot write %q as an INI key", key)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+	for _, item := range items {
+		text := scalarText(item)
+		if strings.ContainsAny(text, "\r\n") {
+			return fmt.Errorf("cannot write a multi-line value for INI key %q", key)
+		}
+		if iniNeedsQuotes(text) || c.typedString(item) {
+			text = `"` + text + `"`
+		}
+		buf.WriteString(strings.TrimRight(key+" = "+text, " ") + "\n")
+	}
+	return nil
+}
+
+// iniNeedsQuotes reports whether a value must be quoted to read back as is
+func iniNeedsQuotes(s string) bool {
+	if s == "" {
+		return false
+	}
+	return s != strings.TrimSpace(s) || s[0] == '"' || s[0] == '\'' ||
+		strings.Contains(s, " ;") || strings.Contains(s, " #") ||
+		strings.Contains(s, "\t;") || strings.Contains(s, "\t#")
+}
+
+// sortedKeys returns the keys of obj in sorted order
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
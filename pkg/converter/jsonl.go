@@ -0,0 +1,133 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errLineTooLong is reported for a line longer than MaxInputSize
+var errLineTooLong = errors.New("line exceeds maximum input size")
+
+// LineError is an error in one line of line-oriented input such as JSONL
+type LineError struct {
+	Line int // 1-based line number
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// jsonlReader reads one JSON value per line, holding only the current line
+// in memory. Blank lines are ignored. A line that fails to parse is a
+// *LineError, or with SkipBadLines is skipped with a warning.
+func (c *Converter) jsonlReader(r io.Reader) func() (interface{}, error) {
+	br := bufio.NewReader(r)
+	line := 0
+	return func() (interface{}, error) {
+		for {
+			text, err := readLine(br, c.opts.MaxInputSize)
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			if err != nil && err != errLineTooLong {
+				return nil, err
+			}
+			line++
+
+			if err == nil {
+				text = bytes.TrimSpace(text)
+				if len(text) == 0 {
+					continue
+				}
+				var value interface{}
+				if err = json.Unmarshal(text, &value); err == nil {
+					return value, nil
+				}
+			}
+
+			lineErr := &LineError{Line: line, Err: err}
+			if !c.opts.SkipBadLines {
+				return nil, fmt.Errorf("failed to parse JSONL: %w", lineErr)
+			}
+			fmt.Fprintf(os.Stderr, "tq: skipping %v\n", lineErr)
+		}
+	}
+}
+
+// readLine reads the next line without its newline. A final line without a
+// newline is returned as is; io.EOF means there are no more lines. A line
+// longer than limit (when limit > 0) is consumed and reported as
+// errLineTooLong.
+func readLine(br *bufio.Reader, limit int64) ([]byte, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if !tooLong {
+			if limit > 0 && int64(len(line)+len(chunk)) > limit {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && (tooLong || len(line) > 0):
+			err = nil
+		case err != nil:
+			return nil, err
+		}
+		if tooLong {
+			return nil, errLineTooLong
+		}
+		return bytes.TrimSuffix(line, []byte("\n")), nil
+	}
+}
+
+// writeJSONL writes data as compact JSON on one line. A top-level array is
+// written one element per line, so an array read from another format
+// converts to JSONL records, unless the input is already made of records
+// (JSONL lines, or streamed rows and events): then each result stays on
+// its own line. With RawOutput, string elements are written as they are.
+func (c *Converter) writeJSONL(w io.Writer, data interface{}) (int, error) {
+	items, ok := data.([]interface{})
+	if !ok || c.records {
+		items = []interface{}{data}
+	}
+
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	for _, item := range items {
+		if s, ok := item.(string); ok && c.rawOutput() {
+			buf.WriteString(s + "\n")
+			continue
+		}
+		item, _ = jsonFinite(item)
+		if err := encoder.Encode(item); err != nil {
+			return 0, fmt.Errorf("failed to encode JSONL: %w", err)
+		}
+	}
+
+	output := buf.String()
+	if c.opts.ASCIIOutput {
+		output = asciiEscape(output)
+	}
+	if _, err := w.Write([]byte(output)); err != nil {
+		return 0, fmt.Errorf("failed to write JSONL: %w", err)
+	}
+
+	return len(output), nil
+}
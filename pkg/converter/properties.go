@@ -0,0 +1,227 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// readProperties reads a Java .properties file. Keys end at the first
+// unescaped =, : or whitespace; lines ending in an odd number of
+// backslashes continue on the next; # and ! start comments; and \uXXXX,
+// \t, \n, \r and \f escapes are decoded. Dotted keys are expanded into
+// nested objects (server.port=8080 is {"server": {"port": 8080}}) and
+// values are typed as in CSV.
+func (c *Converter) readProperties(r io.Reader) (interface{}, error) {
+	result := make(map[string]interface{})
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	n := 0
+	for scanner.Scan() {
+		n++
+		start := n
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if start == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continued(line) && scanner.Scan() {
+			n++
+			line = line[:len(line)-1] + strings.TrimLeft(scanner.Text(), " \t\f")
+		}
+
+		key, value, err := splitProperty(line)
+		if err != nil {
+			return nil, propertiesError(start, err)
+		}
+		if err := setPath(result, key, c.csvValue(value)); err != nil {
+			return nil, propertiesError(start, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read properties: %w", err)
+	}
+	return result, nil
+}
+
+func propertiesError(line int, err error) error {
+	return fmt.Errorf("failed to parse properties: %w", &LineError{Line: line, Err: err})
+}
+
+// continued reports whether a line ends in an odd number of backslashes
+func continued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits a logical line into its unescaped key and value
+func splitProperty(line string) (string, string, error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+
+	key, err := unescapeProperty(line[:end])
+	if err != nil {
+		return "", "", err
+	}
+	value, err := unescapeProperty(rest)
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// unescapeProperty decodes the escapes of a key or value
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape")
+			}
+			code, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape %q", s[i-1:i+5])
+			}
+			i += 4
+			r := rune(code)
+			// Characters outside the BMP are written as a surrogate pair
+			if utf16.IsSurrogate(r) && i+7 <= len(s) && s[i+1:i+3] == `\u` {
+				if low, err := strconv.ParseUint(s[i+3:i+7], 16, 16); err == nil {
+					if pair := utf16.DecodeRune(r, rune(low)); pair != utf8.RuneError {
+						r = pair
+						i += 6
+					}
+				}
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// setPath stores value under a dotted key, creating objects on the way
+func setPath(obj map[string]interface{}, key string, value interface{}) error {
+	parts := strings.Split(key, ".")
+	for i, part := range parts[:len(parts)-1] {
+		switch next := obj[part].(type) {
+		case map[string]interface{}:
+			obj = next
+		case nil:
+			if _, ok := obj[part]; ok {
+				return fmt.Errorf("key %q conflicts with %q", key, strings.Join(parts[:i+1], "."))
+			}
+			child := make(map[string]interface{})
+			obj[part] = child
+			obj = child
+		default:
+			return fmt.Errorf("key %q conflicts with %q", key, strings.Join(parts[:i+1], "."))
+		}
+	}
+
+	last := parts[len(parts)-1]
+	if _, ok := obj[last].(map[string]interface{}); ok {
+		return fmt.Errorf("key %q conflicts with keys below it", key)
+	}
+	obj[last] = value
+	return nil
+}
+
+// writeProperties writes an object as .properties with nested objects
+// flattened into dotted keys, in sorted order, and arrays as JSON.
+// Non-ASCII characters are written as \uXXXX escapes, so the output reads
+// the same as ISO-8859-1 or UTF-8.
+func (c *Converter) writeProperties(w io.Writer, data interface{}) (int, error) {
+	obj, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("properties output requires an object, got %s", typeName(data))
+	}
+
+	flat := make(map[string]interface{})
+	flatten(flat, "", obj)
+
+	var buf strings.Builder
+	for _, k := range sortedKeys(flat) {
+		buf.WriteString(escapeProperty(k, true) + "=" + escapeProperty(scalarText(flat[k]), false) + "\n")
+	}
+
+	output := buf.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write properties: %w", err)
+	}
+	return len(output), nil
+}
+
+// escapeProperty escapes a key or value so it reads back as is
+func escapeProperty(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (key || i == 0):
+			b.WriteString(`\ `)
+		case key && strings.ContainsRune("=:#!", r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x10000:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&b, `\u%04x\u%04x`, r1, r2)
+		case r >= utf8.RuneSelf:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package converter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// rawOutput reports whether strings are written as they are rather than in
+// the output format
+func (c *Converter) rawOutput() bool {
+	return c.opts.RawOutput || c.opts.JoinOutput || c.opts.RawOutput0
+}
+
+// terminator returns what follows each raw output value: a newline, nothing
+// with JoinOutput, or a NUL with RawOutput0
+func (c *Converter) terminator() string {
+	switch {
+	case c.opts.RawOutput0:
+		return "\x00"
+	case c.opts.JoinOutput:
+		return ""
+	}
+	return "\n"
+}
+
+// writeRaw writes a string as it is, followed by the terminator. A string
+// containing NUL cannot be written NUL-separated.
+func (c *Converter) writeRaw(w io.Writer, s string) error {
+	if c.opts.RawOutput0 && strings.ContainsRune(s, 0) {
+		return fmt.Errorf("cannot write a string containing NUL with --raw-output0")
+	}
+	if c.opts.ASCIIOutput {
+		s = asciiEscape(s)
+	}
+	if _, err := io.WriteString(w, s+c.terminator()); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// asciiEscape replaces every non-ASCII character with a \uXXXX escape, using
+// a surrogate pair outside the Basic Multilingual Plane. Non-ASCII
+// characters only occur inside strings in JSON text, so the result is the
+// same JSON.
+func asciiEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x80 {
+			b.WriteRune(r)
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+			fmt.Fprintf(&b, `\u%04x\u%04x`, r1, r2)
+			continue
+		}
+		fmt.Fprintf(&b, `\u%04x`, r)
+	}
+	return b.String()
+}
+
+// rawReader reads each line of input as a string without its newline, or
+// with Slurp the whole input as one string. The size limit applies per
+// line.
+func (c *Converter) rawReader(r io.Reader) func() (interface{}, error) {
+	if c.opts.Slurp {
+		if c.opts.MaxInputSize > 0 {
+			r = io.LimitReader(r, c.opts.MaxInputSize)
+		}
+		return single(func() (interface{}, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read input: %w", err)
+			}
+			return string(data), nil
+		})
+	}
+
+	br := bufio.NewReader(r)
+	line := 0
+	return func() (interface{}, error) {
+		text, err := readLine(br, c.opts.MaxInputSize)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		line++
+		if err != nil {
+			return nil, &LineError{Line: line, Err: err}
+		}
+		return string(text), nil
+	}
+}
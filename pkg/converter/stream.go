@@ -0,0 +1,271 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ssccio/tq/pkg/toon"
+)
+
+// ReadEvents converts the input into jq streaming form, calling fn with one
+// event at a time: [path, leaf] for every scalar and empty container, and
+// [path] after the last child of a non-empty container. JSON and TOON are
+// tokenized incrementally, so inputs of any size are never held in memory;
+// JSON nesting is limited to 10000 levels, as in encoding/json. Other
+// formats are read whole and then walked.
+//
+// With StreamErrors set, a parse error is reported as a final
+// [message, path] event instead of being returned.
+func (c *Converter) ReadEvents(r io.Reader, fn func(event interface{}) error) error {
+	return c.FileEvents(r, "", fn)
+}
+
+// FileEvents is ReadEvents for input read from the named file, whose
+// extension decides the format when it is detected automatically
+func (c *Converter) FileEvents(r io.Reader, name string, fn func(event interface{}) error) error {
+	format, fullReader, err := c.inputFormat(r, name)
+	if err != nil {
+		return err
+	}
+	c.records = true
+
+	s := &eventStream{emit: fn}
+	switch format {
+	case "json", "jsonl":
+		err = s.readJSON(fullReader)
+	case "toon":
+		err = s.readTOON(fullReader, c.toonOptions())
+	default:
+		// Read the whole input in the format already detected
+		whole := *c
+		whole.opts.InputFormat = format
+		whole.opts.DebugFormat = false
+		var data interface{}
+		if data, err = whole.Read(fullReader); err == nil {
+			err = s.walk(data)
+		}
+	}
+
+	var parseErr *streamParseError
+	if !errors.As(err, &parseErr) {
+		return err
+	}
+	if c.opts.StreamErrors {
+		return fn([]interface{}{parseErr.err.Error(), parseErr.path})
+	}
+	return parseErr.err
+}
+
+// streamParseError marks an input error, as opposed to one returned by the
+// event callback, and records where in the document it happened
+type streamParseError struct {
+	err  error
+	path []interface{}
+}
+
+func (e *streamParseError) Error() string {
+	return e.err.Error()
+}
+
+// eventStream turns container and leaf callbacks into streaming events
+type eventStream struct {
+	frames []*eventFrame
+	emit   func(event interface{}) error
+}
+
+// eventFrame is one open container
+type eventFrame struct {
+	object bool
+	key    interface{} // Key or index of the current child
+	count  int
+}
+
+func (s *eventStream) path() []interface{} {
+	path := make([]interface{}, len(s.frames))
+	for i, f := range s.frames {
+		path[i] = f.key
+	}
+	return path
+}
+
+// parseError records err at the position being parsed, which in an array is
+// the element after the last complete one
+func (s *eventStream) parseError(err error) error {
+	path := s.path()
+	if n := len(s.frames); n > 0 && !s.frames[n-1].object {
+		path[n-1] = s.frames[n-1].count
+	}
+	return &streamParseError{err: err, path: path}
+}
+
+// setKey names the next child of the current object
+func (s *eventStream) setKey(key string) {
+	s.frames[len(s.frames)-1].key = key
+}
+
+// beginValue assigns the index of the next child of the current array
+func (s *eventStream) beginValue() {
+	if n := len(s.frames); n > 0 && !s.frames[n-1].object {
+		s.frames[n-1].key = s.frames[n-1].count
+	}
+}
+
+func (s *eventStream) endValue() {
+	if n := len(s.frames); n > 0 {
+		s.frames[n-1].count++
+	}
+}
+
+func (s *eventStream) leaf(v interface{}) error {
+	s.beginValue()
+	if err := s.emit([]interface{}{s.path(), v}); err != nil {
+		return err
+	}
+	s.endValue()
+	return nil
+}
+
+func (s *eventStream) open(object bool) {
+	s.beginValue()
+	s.frames = append(s.frames, &eventFrame{object: object})
+}
+
+// close ends the current container. An empty container is itself a leaf;
+// otherwise the closing event names its last child.
+func (s *eventStream) close() error {
+	f := s.frames[len(s.frames)-1]
+	s.frames = s.frames[:len(s.frames)-1]
+
+	var event []interface{}
+	switch {
+	case f.count > 0:
+		event = []interface{}{append(s.path(), f.key)}
+	case f.object:
+		event = []interface{}{s.path(), map[string]interface{}{}}
+	default:
+		event = []interface{}{s.path(), []interface{}{}}
+	}
+	if err := s.emit(event); err != nil {
+		return err
+	}
+	s.endValue()
+	return nil
+}
+
+// walk streams an already decoded value
+func (s *eventStream) walk(v interface{}) error {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		s.open(true)
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.setKey(k)
+			if err := s.walk(val[k]); err != nil {
+				return err
+			}
+		}
+		return s.close()
+	case []interface{}:
+		s.open(false)
+		for _, item := range val {
+			if err := s.walk(item); err != nil {
+				return err
+			}
+		}
+		return s.close()
+	default:
+		return s.leaf(v)
+	}
+}
+
+// readJSON streams every top-level JSON value in r
+func (s *eventStream) readJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	expectKey := false
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			if len(s.frames) > 0 {
+				return s.parseError(io.ErrUnexpectedEOF)
+			}
+			return nil
+		}
+		if err != nil {
+			return s.parseError(err)
+		}
+
+		if expectKey {
+			if key, ok := tok.(string); ok {
+				s.setKey(key)
+				expectKey = false
+				continue
+			}
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			switch t {
+			case '{', '[':
+				s.open(t == '{')
+			default:
+				err = s.close()
+			}
+		default:
+			err = s.leaf(t)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The next token of an open object is a key, unless it closes it
+		n := len(s.frames)
+		expectKey = n > 0 && s.frames[n-1].object && decoder.More()
+	}
+}
+
+// readTOON streams a TOON document using the token-level decoder
+func (s *eventStream) readTOON(r io.Reader, opts toon.Options) error {
+	decoder := toon.NewDecoder(r)
+	decoder.SetOptions(opts)
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			if decoder.NextDocument() {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return s.parseError(err)
+		}
+
+		switch tok.Kind {
+		case toon.ObjectStart:
+			s.open(true)
+		case toon.ArrayHeader:
+			s.open(false)
+		case toon.ObjectEnd, toon.ArrayEnd:
+			err = s.close()
+		case toon.Key:
+			s.setKey(tok.Key)
+		case toon.Row:
+			err = s.walk(tok.Value)
+		case toon.Primitive:
+			err = s.leaf(tok.Value)
+		default:
+			err = fmt.Errorf("unexpected TOON token: %s", tok.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
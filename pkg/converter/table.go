@@ -0,0 +1,196 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// table is a value laid out as rows and columns for Markdown or HTML
+type table struct {
+	columns []string
+	rows    [][]string
+}
+
+// tableOf lays out data as a table. An array of objects has a column per key
+// (CSVColumns, or else every key in sorted order) and a row per object; an
+// object has a row per key in "key" and "value" columns; anything else is a
+// single "value" column. Nested values are written as compact JSON.
+func (c *Converter) tableOf(data interface{}) table {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		t := table{columns: []string{"key", "value"}}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			t.rows = append(t.rows, []string{k, cellText(v[k])})
+		}
+		return t
+
+	case []interface{}:
+		objects := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				break
+			}
+			objects = append(objects, obj)
+		}
+		if len(objects) > 0 && len(objects) == len(v) {
+			t := table{columns: c.opts.CSVColumns}
+			if len(t.columns) == 0 {
+				t.columns = csvColumns(objects, c.header)
+			}
+			for _, obj := range objects {
+				row := make([]string, len(t.columns))
+				for i, col := range t.columns {
+					if value, ok := obj[col]; ok {
+						row[i] = cellText(value)
+					}
+				}
+				t.rows = append(t.rows, row)
+			}
+			return t
+		}
+		t := table{columns: []string{"value"}}
+		for _, item := range v {
+			t.rows = append(t.rows, []string{cellText(item)})
+		}
+		return t
+	}
+	return table{columns: []string{"value"}, rows: [][]string{{cellText(data)}}}
+}
+
+// cellText formats a table cell like scalarText, without escaping HTML
+// characters in nested JSON
+func cellText(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		var buf strings.Builder
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return fmt.Sprint(v)
+		}
+		return strings.TrimSuffix(buf.String(), "\n")
+	}
+	return scalarText(v)
+}
+
+// markdownEscaper keeps cells on one line, pipes inside their cell and
+// angle brackets and entities from being read as HTML
+var markdownEscaper = strings.NewReplacer("&", "&amp;", "\\", "\\\\", "|", "\\|", "<", "&lt;", ">", "&gt;", "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// writeMarkdown writes data as a GitHub-flavored Markdown table. Columns are
+// padded to line up unless the output is compact.
+func (c *Converter) writeMarkdown(w io.Writer, data interface{}) (int, error) {
+	t := c.tableOf(data)
+
+	cells := make([][]string, 0, len(t.rows)+1)
+	cells = append(cells, escapeAll(t.columns))
+	for _, row := range t.rows {
+		cells = append(cells, escapeAll(row))
+	}
+
+	widths := make([]int, len(t.columns))
+	for i := range widths {
+		widths[i] = 3
+		for _, row := range cells {
+			if n := utf8.RuneCountInString(row[i]); !c.opts.Compact && n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var buf strings.Builder
+	writeRow := func(row []string) {
+		buf.WriteString("|")
+		for i, cell := range row {
+			buf.WriteString(" " + cell)
+			if !c.opts.Compact {
+				buf.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+			buf.WriteString(" |")
+		}
+		buf.WriteString("\n")
+	}
+
+	writeRow(cells[0])
+	rule := make([]string, len(widths))
+	for i, n := range widths {
+		rule[i] = strings.Repeat("-", n)
+	}
+	writeRow(rule)
+	for _, row := range cells[1:] {
+		writeRow(row)
+	}
+
+	output := buf.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write Markdown: %w", err)
+	}
+	return len(output), nil
+}
+
+func escapeAll(cells []string) []string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = markdownEscaper.Replace(cell)
+	}
+	return escaped
+}
+
+// writeHTML writes data as an HTML <table> with a header row, indented
+// unless the output is compact
+func (c *Converter) writeHTML(w io.Writer, data interface{}) (int, error) {
+	t := c.tableOf(data)
+	indent := strings.Repeat(" ", c.opts.Indent)
+	if c.opts.UseTab {
+		indent = "\t"
+	}
+
+	var buf strings.Builder
+	line := func(depth int, s string) {
+		if c.opts.Compact {
+			buf.WriteString(s)
+			return
+		}
+		buf.WriteString(strings.Repeat(indent, depth) + s + "\n")
+	}
+	row := func(tag string, cells []string) {
+		var b strings.Builder
+		b.WriteString("<tr>")
+		for _, cell := range cells {
+			b.WriteString("<" + tag + ">" + html.EscapeString(cell) + "</" + tag + ">")
+		}
+		b.WriteString("</tr>")
+		line(2, b.String())
+	}
+
+	line(0, "<table>")
+	line(1, "<thead>")
+	row("th", t.columns)
+	line(1, "</thead>")
+	line(1, "<tbody>")
+	for _, cells := range t.rows {
+		row("td", cells)
+	}
+	line(1, "</tbody>")
+	line(0, "</table>")
+	if c.opts.Compact {
+		buf.WriteString("\n")
+	}
+
+	output := buf.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write HTML: %w", err)
+	}
+	return len(output), nil
+}
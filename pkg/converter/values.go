@@ -0,0 +1,268 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ssccio/tq/pkg/cbor"
+	"github.com/ssccio/tq/pkg/msgpack"
+	"github.com/ssccio/tq/pkg/toml"
+	"github.com/ssccio/tq/pkg/toon"
+	"gopkg.in/yaml.v3"
+)
+
+// recordSeparator starts each JSON text in application/json-seq (RFC 7464)
+const recordSeparator = 0x1E
+
+// ValueReader reads the input values of one source one at a time
+type ValueReader struct {
+	next func() (interface{}, error)
+}
+
+// Next returns the next input value, or io.EOF once there are no more
+func (v *ValueReader) Next() (interface{}, error) {
+	return v.next()
+}
+
+// Values returns a reader over the input values in r: every value of a JSON,
+// MessagePack or CBOR stream or every document of a multi-document YAML
+// file in turn. With Seq, JSON input is split on RS characters and texts
+// that fail to parse are skipped with a warning. JSONL input yields one
+// value per line.
+func (c *Converter) Values(r io.Reader) (*ValueReader, error) {
+	return c.FileValues(r, "")
+}
+
+// FileValues is Values for input read from the named file, whose extension
+// decides the format when it is detected automatically (see Detect)
+func (c *Converter) FileValues(r io.Reader, name string) (*ValueReader, error) {
+	// Raw input is text whatever it looks like
+	if c.opts.RawInput {
+		c.document = nil
+		c.records = !c.opts.Slurp
+		c.header = nil
+		return &ValueReader{next: c.rawReader(r)}, nil
+	}
+
+	format, fullReader, err := c.inputFormat(r, name)
+	if err != nil {
+		return nil, err
+	}
+	c.document = nil
+	c.records = format == "jsonl" && !c.opts.Slurp
+	c.header = nil
+	if c.opts.KeepFormat {
+		c.opts.OutputFormat = format
+	}
+
+	// JSONL is read a line at a time, so the size limit applies per line
+	if format == "jsonl" {
+		return &ValueReader{next: c.jsonlReader(fullReader)}, nil
+	}
+
+	// Apply size limit if configured
+	if c.opts.MaxInputSize > 0 {
+		fullReader = io.LimitReader(fullReader, c.opts.MaxInputSize)
+	}
+
+	v := &ValueReader{}
+	switch format {
+	case "json":
+		if c.opts.Seq {
+			v.next = seqReader(fullReader)
+			break
+		}
+		decoder := json.NewDecoder(fullReader)
+		decode := decoder.Decode
+		if isBinaryFormat(c.opts.OutputFormat) {
+			// Binary formats distinguish integers from floats; keep the
+			// distinction made by the JSON text
+			decoder.UseNumber()
+			decode = func(v interface{}) error {
+				if err := decoder.Decode(v); err != nil {
+					return err
+				}
+				ptr := v.(*interface{})
+				*ptr = jsonNumbers(*ptr)
+				return nil
+			}
+		}
+		v.next = c.streamReader("JSON", decode)
+	case "yaml":
+		decoder := yaml.NewDecoder(fullReader)
+		v.next = c.streamReader("YAML", c.yamlDocuments(decoder))
+	case "csv", "tsv":
+		v.next = single(func() (interface{}, error) {
+			return c.readCSV(fullReader, format)
+		})
+	case "msgpack":
+		v.next = c.binaryReader("MessagePack", msgpack.NewDecoder(fullReader).Decode)
+	case "cbor":
+		v.next = c.binaryReader("CBOR", cbor.NewDecoder(fullReader).Decode)
+	case "xml":
+		v.next = single(func() (interface{}, error) {
+			return c.readXML(fullReader)
+		})
+	case "toml":
+		v.next = single(func() (interface{}, error) {
+			data, err := io.ReadAll(fullReader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read input: %w", err)
+			}
+			result, err := toml.DecodeWithOptions(string(data), toml.Options{Datetimes: c.opts.TOMLDatetimes})
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse TOML: %w", err)
+			}
+			return result, nil
+		})
+	case "ini":
+		v.next = single(func() (interface{}, error) {
+			return c.readINI(fullReader)
+		})
+	case "dotenv":
+		v.next = single(func() (interface{}, error) {
+			return c.readDotenv(fullReader)
+		})
+	case "properties":
+		v.next = single(func() (interface{}, error) {
+			return c.readProperties(fullReader)
+		})
+	case "toon":
+		v.next = c.toonReader(fullReader)
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", format)
+	}
+	return v, nil
+}
+
+// jsonNumbers replaces json.Number values with int64 for integer literals
+// that fit, and float64 otherwise
+func jsonNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n
+		}
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		for i, item := range val {
+			val[i] = jsonNumbers(item)
+		}
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = jsonNumbers(item)
+		}
+	}
+	return v
+}
+
+// single reads a format that holds one value per input
+func single(read func() (interface{}, error)) func() (interface{}, error) {
+	done := false
+	return func() (interface{}, error) {
+		if done {
+			return nil, io.EOF
+		}
+		done = true
+		return read()
+	}
+}
+
+// toonReader reads each document of a TOON stream, as separated by
+// toon.DocumentSeparator lines
+func (c *Converter) toonReader(r io.Reader) func() (interface{}, error) {
+	decoder := toon.NewDecoder(r)
+	decoder.SetOptions(c.toonOptions())
+	done := false
+	return func() (interface{}, error) {
+		if done {
+			return nil, io.EOF
+		}
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, err
+		}
+		done = !decoder.NextDocument()
+		return value, nil
+	}
+}
+
+// streamReader reads successive values with decode. An empty stream, like
+// blank input, has no values.
+func (c *Converter) streamReader(format string, decode func(interface{}) error) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		var value interface{}
+		if err := decode(&value); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to parse %s: %w", format, err)
+		}
+		return value, nil
+	}
+}
+
+// yamlDocuments decodes successive YAML documents, skipping empty ones such
+// as the one after a trailing "---". Unless slurping, the converter keeps
+// the node tree of the last document for YAML output.
+func (c *Converter) yamlDocuments(decoder *yaml.Decoder) func(interface{}) error {
+	return func(v interface{}) error {
+		for {
+			var doc yaml.Node
+			if err := decoder.Decode(&doc); err != nil {
+				return err
+			}
+			if !emptyDocument(&doc) {
+				if !c.opts.Slurp {
+					c.document = &doc
+				}
+				return doc.Decode(v)
+			}
+		}
+	}
+}
+
+// emptyDocument reports whether a YAML document has no content at all, as
+// opposed to an explicit null
+func emptyDocument(doc *yaml.Node) bool {
+	if len(doc.Content) == 0 {
+		return true
+	}
+	node := doc.Content[0]
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null" && node.Value == ""
+}
+
+// seqReader reads RS-separated JSON texts, warning about and skipping any
+// that fail to parse
+func seqReader(r io.Reader) func() (interface{}, error) {
+	br := bufio.NewReader(r)
+	return func() (interface{}, error) {
+		for {
+			text, err := br.ReadBytes(recordSeparator)
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("failed to read input: %w", err)
+			}
+			text = bytes.TrimSpace(bytes.TrimSuffix(text, []byte{recordSeparator}))
+
+			if len(text) > 0 {
+				var value interface{}
+				perr := json.Unmarshal(text, &value)
+				if perr == nil {
+					return value, nil
+				}
+				fmt.Fprintf(os.Stderr, "tq: ignoring malformed JSON text: %v\n", perr)
+			}
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package converter
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// XML namespace handling
+const (
+	XMLNamespacesKeep  = "keep"  // Keep prefixes (soap:Body) and xmlns attributes (default)
+	XMLNamespacesStrip = "strip" // Use local names and drop xmlns attributes
+)
+
+// xmlNamePattern matches names that can be written as XML elements
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._:-]*$`)
+
+// xmlMapping is the convention used to map XML to objects and back
+type xmlMapping struct {
+	attrPrefix string          // Prefix of attribute keys
+	textKey    string          // Key of text next to attributes or children
+	strip      bool            // Strip namespace prefixes
+	arrays     map[string]bool // Dotted element paths that are always arrays
+}
+
+func (c *Converter) xmlMapping() xmlMapping {
+	m := xmlMapping{
+		attrPrefix: c.opts.XMLAttrPrefix,
+		textKey:    c.opts.XMLTextKey,
+		strip:      c.opts.XMLNamespaces == XMLNamespacesStrip,
+		arrays:     make(map[string]bool),
+	}
+	if m.attrPrefix == "" {
+		m.attrPrefix = "@"
+	}
+	if m.textKey == "" {
+		m.textKey = "#text"
+	}
+	for _, path := range c.opts.XMLArrays {
+		m.arrays[strings.TrimPrefix(path, ".")] = true
+	}
+	return m
+}
+
+// xmlFrame is an element being read
+type xmlFrame struct {
+	name string
+	path string
+	obj  map[string]interface{}
+	text strings.Builder
+}
+
+// readXML reads an XML document as an object keyed by its root element.
+// Attributes become "@name" keys and text "#text", or the element's value
+// itself when it has neither attributes nor children. Repeated elements
+// become arrays, as do elements whose dotted path is in XMLArrays, and an
+// empty element is null. Comments and processing instructions are dropped.
+func (c *Converter) readXML(r io.Reader) (interface{}, error) {
+	m := c.xmlMapping()
+	if c.opts.XMLNamespaces != "" && c.opts.XMLNamespaces != XMLNamespacesKeep && !m.strip {
+		return nil, fmt.Errorf("unsupported XML namespace handling %q: use %q or %q",
+			c.opts.XMLNamespaces, XMLNamespacesKeep, XMLNamespacesStrip)
+	}
+
+	decoder := xml.NewDecoder(r)
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = charsetReader
+
+	var stack []*xmlFrame
+	var root map[string]interface{}
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XML: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil {
+				return nil, fmt.Errorf("failed to parse XML: line %d: more than one root element", line(decoder))
+			}
+			f := &xmlFrame{name: m.name(t.Name), obj: make(map[string]interface{})}
+			f.path = f.name
+			if n := len(stack); n > 0 {
+				f.path = stack[n-1].path + "." + f.name
+			}
+			for _, attr := range t.Attr {
+				if m.strip && (attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					continue
+				}
+				f.obj[m.attrPrefix+m.name(attr.Name)] = attr.Value
+			}
+			stack = append(stack, f)
+
+		case xml.EndElement:
+			n := len(stack)
+			if n == 0 || stack[n-1].name != m.name(t.Name) {
+				return nil, fmt.Errorf("failed to parse XML: line %d: unexpected closing tag </%s>", line(decoder), m.name(t.Name))
+			}
+			f := stack[n-1]
+			stack = stack[:n-1]
+			value := f.value(m)
+			if n == 1 {
+				if m.arrays[f.path] {
+					value = []interface{}{value}
+				}
+				root = map[string]interface{}{f.name: value}
+				continue
+			}
+			m.add(stack[n-2].obj, f.name, f.path, value)
+
+		case xml.CharData:
+			if n := len(stack); n > 0 {
+				stack[n-1].text.Write(t)
+			} else if strings.TrimSpace(string(t)) != "" {
+				return nil, fmt.Errorf("failed to parse XML: line %d: text outside the root element", line(decoder))
+			}
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("failed to parse XML: unclosed element <%s>", stack[len(stack)-1].name)
+	}
+	if root == nil {
+		return nil, fmt.Errorf("failed to parse XML: no root element")
+	}
+	return root, nil
+}
+
+// name renders an element or attribute name, with its prefix unless
+// namespaces are stripped
+func (m xmlMapping) name(n xml.Name) string {
+	if n.Space == "" || m.strip {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// value is the element's value once it is closed
+func (f *xmlFrame) value(m xmlMapping) interface{} {
+	text := strings.TrimSpace(f.text.String())
+	if len(f.obj) == 0 {
+		if text == "" {
+			return nil
+		}
+		return text
+	}
+	if text != "" {
+		f.obj[m.textKey] = text
+	}
+	return f.obj
+}
+
+// add stores a child element in its parent, turning repeated elements and
+// forced paths into arrays
+func (m xmlMapping) add(parent map[string]interface{}, name, path string, value interface{}) {
+	switch existing := parent[name].(type) {
+	case []interface{}:
+		parent[name] = append(existing, value)
+	default:
+		if _, ok := parent[name]; ok {
+			parent[name] = []interface{}{existing, value}
+		} else if m.arrays[path] {
+			parent[name] = []interface{}{value}
+		} else {
+			parent[name] = value
+		}
+	}
+}
+
+// line returns the line the decoder has reached
+func line(decoder *xml.Decoder) int {
+	l, _ := decoder.InputPos()
+	return l
+}
+
+// charsetReader decodes the Latin-1 encodings still common in legacy feeds;
+// UTF-8 and US-ASCII need no decoding
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "latin-1":
+		return &latin1Reader{r: bufio.NewReader(input)}, nil
+	}
+	return nil, fmt.Errorf("unsupported XML encoding %q", charset)
+}
+
+// latin1Reader converts ISO-8859-1 bytes to UTF-8
+type latin1Reader struct {
+	r       *bufio.Reader
+	pending []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			c := copy(p[n:], l.pending)
+			l.pending = l.pending[c:]
+			n += c
+			continue
+		}
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+		l.pending = utf8.AppendRune(nil, rune(b))
+	}
+	return n, nil
+}
+
+// writeXML writes data as an XML document using the same mapping as
+// readXML. An object with a single key names the root element; anything
+// else is wrapped in <root>. A document has one root element, so an array
+// is wrapped in <root> too, its items named after their key or <item>.
+func (c *Converter) writeXML(w io.Writer, data interface{}) (int, error) {
+	m := c.xmlMapping()
+	x := &xmlWriter{mapping: m, compact: c.opts.Compact, indent: strings.Repeat(" ", c.opts.Indent)}
+	if c.opts.UseTab {
+		x.indent = "\t"
+	}
+
+	name, value := "root", data
+	if obj, ok := data.(map[string]interface{}); ok && len(obj) == 1 {
+		for k, v := range obj {
+			if !strings.HasPrefix(k, m.attrPrefix) && k != m.textKey {
+				name, value = k, v
+			}
+		}
+	}
+	if arr, ok := value.([]interface{}); ok {
+		child := name
+		if _, ok := data.([]interface{}); ok {
+			child = "item"
+		}
+		name, value = "root", map[string]interface{}{child: arr}
+		if len(arr) == 0 {
+			value = nil
+		}
+	}
+
+	x.b.WriteString(xml.Header)
+	if err := x.element(name, value, 0); err != nil {
+		return 0, err
+	}
+	if x.compact {
+		x.b.WriteString("\n")
+	}
+
+	output := x.b.String()
+	if _, err := io.WriteString(w, output); err != nil {
+		return 0, fmt.Errorf("failed to write XML: %w", err)
+	}
+	return len(output), nil
+}
+
+type xmlWriter struct {
+	b       strings.Builder
+	mapping xmlMapping
+	compact bool
+	indent  string
+}
+
+// open starts a line at depth
+func (x *xmlWriter) open(depth int) {
+	if !x.compact {
+		x.b.WriteString(strings.Repeat(x.indent, depth))
+	}
+}
+
+// close ends a line
+func (x *xmlWriter) close() {
+	if !x.compact {
+		x.b.WriteString("\n")
+	}
+}
+
+// element writes value as one element, or one per item of an array
+func (x *xmlWriter) element(name string, value interface{}, depth int) error {
+	if !xmlNamePattern.MatchString(name) {
+		return fmt.Errorf("cannot write %q as an XML element name", name)
+	}
+
+	switch val := value.(type) {
+	case []interface{}:
+		for _, item := range val {
+			if err := x.element(name, item, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		return x.object(name, val, depth)
+	case nil:
+		x.open(depth)
+		x.b.WriteString("<" + name + "/>")
+		x.close()
+		return nil
+	}
+
+	x.open(depth)
+	x.b.WriteString("<" + name + ">" + escapeXMLText(scalarText(value)) + "</" + name + ">")
+	x.close()
+	return nil
+}
+
+// object writes an element with attributes, text and children
+func (x *xmlWriter) object(name string, obj map[string]interface{}, depth int) error {
+	m := x.mapping
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var children []string
+	var text interface{}
+	x.open(depth)
+	x.b.WriteString("<" + name)
+	for _, k := range keys {
+		switch {
+		case k == m.textKey:
+			text = obj[k]
+		case strings.HasPrefix(k, m.attrPrefix):
+			attr := strings.TrimPrefix(k, m.attrPrefix)
+			if !xmlNamePattern.MatchString(attr) {
+				return fmt.Errorf("cannot write %q as an XML attribute name", attr)
+			}
+			x.b.WriteString(" " + attr + `="` + escapeXMLAttr(scalarText(obj[k])) + `"`)
+		default:
+			children = append(children, k)
+		}
+	}
+
+	switch {
+	case len(children) == 0 && text == nil:
+		x.b.WriteString("/>")
+		x.close()
+		return nil
+	case len(children) == 0:
+		x.b.WriteString(">" + escapeXMLText(scalarText(text)) + "</" + name + ">")
+		x.close()
+		return nil
+	}
+
+	x.b.WriteString(">")
+	x.close()
+	if text != nil {
+		x.open(depth + 1)
+		x.b.WriteString(escapeXMLText(scalarText(text)))
+		x.close()
+	}
+	for _, k := range children {
+		if err := x.element(k, obj[k], depth+1); err != nil {
+			return err
+		}
+	}
+	x.open(depth)
+	x.b.WriteString("</" + name + ">")
+	x.close()
+	return nil
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+)
+
+func escapeXMLText(s string) string {
+	return xmlTextEscaper.Replace(s)
+}
+
+func escapeXMLAttr(s string) string {
+	return xmlAttrEscaper.Replace(s)
+}
@@ -0,0 +1,557 @@
+package converter
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAML input keeps the node tree of the document each value came from, so
+// that YAML output can be written from it: the result of a query is merged
+// back into the tree, and every part of the document the query left alone
+// keeps its comments, anchors and aliases, tags and quoting and flow
+// styles. Changed scalars keep their comments and, where the new value
+// allows, their style. The tree also answers where a value came from, for
+// line(), head_comment() and explode() in queries.
+
+// Line returns the line of the current YAML document where the value at
+// path is, or 0 if it is not known
+func (c *Converter) Line(path []interface{}) int {
+	node, _ := c.yamlNodeAt(path)
+	if node == nil {
+		return 0
+	}
+	return node.Line
+}
+
+// HeadComment returns the comment above the value at path in the current
+// YAML document, without the leading # of each line
+func (c *Converter) HeadComment(path []interface{}) string {
+	node, key := c.yamlNodeAt(path)
+	if node == nil {
+		return ""
+	}
+	comment := node.HeadComment
+	switch {
+	case key != nil && key.HeadComment != "":
+		comment = key.HeadComment
+	case len(path) == 0 && c.document.HeadComment != "":
+		comment = c.document.HeadComment
+	}
+	return uncomment(comment)
+}
+
+// Explode replaces the aliases at and below path in the current YAML
+// document with copies of what they refer to and expands merge keys, so
+// YAML output writes them out in full. Exploding the whole document also
+// drops its anchors.
+func (c *Converter) Explode(path []interface{}) {
+	node, _ := c.yamlNodeAt(path)
+	if node == nil {
+		return
+	}
+	*node = *explode(node, false)
+	if len(path) == 0 {
+		dropAnchors(node)
+	}
+}
+
+// yamlNodeAt returns the node of the value at path in the current YAML
+// document, and the key it is under when it is in a mapping
+func (c *Converter) yamlNodeAt(path []interface{}) (node, key *yaml.Node) {
+	if c.document == nil || len(c.document.Content) == 0 {
+		return nil, nil
+	}
+	node = c.document.Content[0]
+	for _, step := range path {
+		node = resolveAlias(node)
+		switch s := step.(type) {
+		case string:
+			if key, node = mappingEntry(node, s); node == nil {
+				return nil, nil
+			}
+		case int:
+			if node.Kind != yaml.SequenceNode || s < 0 || s >= len(node.Content) {
+				return nil, nil
+			}
+			key, node = nil, node.Content[s]
+		default:
+			return nil, nil
+		}
+	}
+	return node, key
+}
+
+// mappingEntry returns the key and value nodes of name in a mapping,
+// looking through merge keys for names the mapping does not set itself
+func mappingEntry(node *yaml.Node, name string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if k := node.Content[i]; k.Kind == yaml.ScalarNode && !isMergeKey(k) && k.Value == name {
+			return k, node.Content[i+1]
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isMergeKey(node.Content[i]) {
+			continue
+		}
+		sources := []*yaml.Node{node.Content[i+1]}
+		if sources[0].Kind == yaml.SequenceNode {
+			sources = sources[0].Content
+		}
+		for _, src := range sources {
+			if k, v := mappingEntry(resolveAlias(src), name); v != nil {
+				return k, v
+			}
+		}
+	}
+	return nil, nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func isMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Value == "<<" && (node.Tag == "" || node.ShortTag() == "!!merge")
+}
+
+// uncomment strips the # and the space after it from each line of a comment
+func uncomment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "#")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// explode returns a copy of node with its aliases replaced by copies of
+// what they refer to and its merge keys expanded into ordinary keys. The
+// anchors of copied aliases are dropped, so they cannot redefine an anchor.
+func explode(node *yaml.Node, copied bool) *yaml.Node {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		out := explode(node.Alias, true)
+		out.HeadComment, out.LineComment, out.FootComment = node.HeadComment, node.LineComment, node.FootComment
+		return out
+	}
+
+	out := *node
+	if copied {
+		out.Anchor = ""
+	}
+	out.Content = nil
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			out.Content = append(out.Content, explode(child, copied))
+		}
+		return &out
+	}
+
+	// Keys set by the mapping itself win over merged ones, and earlier
+	// merged mappings win over later ones
+	set := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if k := node.Content[i]; !isMergeKey(k) {
+			set[k.Value] = true
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if !isMergeKey(k) {
+			out.Content = append(out.Content, explode(k, copied), explode(v, copied))
+			continue
+		}
+		sources := []*yaml.Node{v}
+		if v.Kind == yaml.SequenceNode {
+			sources = v.Content
+		}
+		for _, src := range sources {
+			merged := explode(src, true)
+			if merged.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(merged.Content); j += 2 {
+				if name := merged.Content[j].Value; !set[name] {
+					set[name] = true
+					out.Content = append(out.Content, merged.Content[j], merged.Content[j+1])
+				}
+			}
+		}
+	}
+	return &out
+}
+
+func dropAnchors(node *yaml.Node) {
+	node.Anchor = ""
+	for _, child := range node.Content {
+		dropAnchors(child)
+	}
+}
+
+// mergeYAML returns the document to write for data, the result of a query
+// on doc, or nil when data has too little to do with doc to keep anything
+// from it, such as a scalar picked out of a mapping
+func mergeYAML(doc *yaml.Node, data interface{}) (*yaml.Node, error) {
+	if len(doc.Content) == 0 || !related(resolveAlias(doc.Content[0]), data) {
+		return nil, nil
+	}
+	m := &yamlMerger{anchors: make(map[string]*yaml.Node)}
+	return m.merge(doc, data)
+}
+
+// related reports whether data could be an edit of node: a mapping that
+// still has one of its keys, any array for a sequence, or any scalar for a
+// scalar
+func related(node *yaml.Node, data interface{}) bool {
+	switch val := data.(type) {
+	case map[string]interface{}:
+		if node.Kind != yaml.MappingNode {
+			return false
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if _, ok := val[node.Content[i].Value]; ok {
+				return true
+			}
+		}
+		return len(val) == 0
+	case []interface{}:
+		return node.Kind == yaml.SequenceNode
+	}
+	return node.Kind == yaml.ScalarNode
+}
+
+// yamlMerger builds the node tree for a query result from the tree of its
+// input, reusing every node whose value did not change. Nodes are visited
+// in document order, so an alias is kept only when the anchor it refers to
+// is still written before it and still has the alias's value.
+type yamlMerger struct {
+	anchors map[string]*yaml.Node // Anchored nodes written so far, by name
+}
+
+// yamlEntry is a key of a mapping and its value
+type yamlEntry struct {
+	key   string
+	value interface{}
+}
+
+func (m *yamlMerger) merge(node *yaml.Node, value interface{}) (*yaml.Node, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		out := *node
+		content, err := m.merge(node.Content[0], value)
+		if err != nil {
+			return nil, err
+		}
+		out.Content = []*yaml.Node{content}
+		return &out, nil
+
+	case yaml.AliasNode:
+		if target := m.anchors[node.Value]; target != nil && decodesTo(target, value) {
+			out := *node
+			out.Alias = target
+			return &out, nil
+		}
+		return m.fresh(node, value)
+
+	case yaml.ScalarNode:
+		if decodesTo(node, value) {
+			m.record(node)
+			return node, nil
+		}
+		return m.scalar(node, value)
+
+	case yaml.SequenceNode:
+		arr, ok := value.([]interface{})
+		if !ok {
+			return m.fresh(node, value)
+		}
+		out := &yaml.Node{}
+		*out = *node
+		out.Content = make([]*yaml.Node, 0, len(arr))
+		m.record(out)
+		for i, item := range arr {
+			var child *yaml.Node
+			var err error
+			if i < len(node.Content) {
+				child, err = m.merge(node.Content[i], item)
+			} else {
+				child, err = m.fresh(nil, item)
+			}
+			if err != nil {
+				return nil, err
+			}
+			out.Content = append(out.Content, child)
+		}
+		return out, nil
+
+	case yaml.MappingNode:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return m.fresh(node, value)
+		}
+		return m.mapping(node, obj)
+	}
+	return m.fresh(node, value)
+}
+
+// mapping merges obj into a mapping: its keys keep their order and
+// comments, removed keys are dropped, and new keys are added at the end in
+// sorted order. A merge key stays as long as every key it brings in is
+// still there; keys whose merged value changed are set in the mapping
+// itself, which overrides the merge.
+func (m *yamlMerger) mapping(node *yaml.Node, obj map[string]interface{}) (*yaml.Node, error) {
+	set := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k := node.Content[i]
+		if k.Kind != yaml.ScalarNode {
+			return m.fresh(node, obj)
+		}
+		if !isMergeKey(k) {
+			set[k.Value] = true
+		}
+	}
+
+	out := &yaml.Node{}
+	*out = *node
+	out.Content = nil
+	m.record(out)
+
+	written := make(map[string]bool)
+	add := func(name string, value interface{}) error {
+		key, err := m.fresh(nil, name)
+		if err != nil {
+			return err
+		}
+		child, err := m.fresh(nil, value)
+		if err != nil {
+			return err
+		}
+		out.Content = append(out.Content, key, child)
+		written[name] = true
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if isMergeKey(k) {
+			entries, keep := m.mergeSources(v)
+			for _, e := range entries {
+				if _, ok := obj[e.key]; !ok {
+					keep = false
+				}
+			}
+			if keep {
+				// Left to be resolved, the key is written as << rather
+				// than !!merge <<
+				key := *k
+				key.Tag = ""
+				out.Content = append(out.Content, &key, m.repoint(v))
+			}
+			for _, e := range entries {
+				value, ok := obj[e.key]
+				if !ok || set[e.key] || written[e.key] {
+					continue
+				}
+				if keep && yamlEqual(value, e.value) {
+					written[e.key] = true
+					continue
+				}
+				if err := add(e.key, value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		value, ok := obj[k.Value]
+		if !ok {
+			continue
+		}
+		child, err := m.merge(v, value)
+		if err != nil {
+			return nil, err
+		}
+		out.Content = append(out.Content, k, child)
+		written[k.Value] = true
+	}
+
+	for _, name := range sortedKeys(obj) {
+		if !written[name] {
+			if err := add(name, obj[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// mergeSources returns the keys a merge key brings into a mapping, in
+// order, and whether they could all be resolved against the anchors
+// written so far
+func (m *yamlMerger) mergeSources(v *yaml.Node) ([]yamlEntry, bool) {
+	sources := []*yaml.Node{v}
+	if v.Kind == yaml.SequenceNode {
+		sources = v.Content
+	}
+
+	var entries []yamlEntry
+	seen := make(map[string]bool)
+	resolved := true
+	for _, src := range sources {
+		if src.Kind == yaml.AliasNode {
+			if target := m.anchors[src.Value]; target != nil {
+				src = target
+			} else {
+				// The anchor is gone; the keys it brought in are
+				// still wanted in its place
+				src, resolved = resolveAlias(src), false
+			}
+		}
+		var obj map[string]interface{}
+		if src.Kind != yaml.MappingNode || src.Decode(&obj) != nil {
+			return nil, false
+		}
+		for _, name := range sortedKeys(obj) {
+			if !seen[name] {
+				seen[name] = true
+				entries = append(entries, yamlEntry{key: name, value: obj[name]})
+			}
+		}
+	}
+	return entries, resolved
+}
+
+// repoint returns a copy of a merge key's value with its aliases referring
+// to the anchors as written
+func (m *yamlMerger) repoint(node *yaml.Node) *yaml.Node {
+	out := *node
+	if node.Kind == yaml.AliasNode {
+		out.Alias = m.anchors[node.Value]
+		return &out
+	}
+	out.Content = nil
+	for _, child := range node.Content {
+		out.Content = append(out.Content, m.repoint(child))
+	}
+	return &out
+}
+
+// scalar replaces a scalar whose value changed. A string keeps the quoting
+// of the string it replaces, or its literal or folded style if it still
+// spans lines, and a local tag such as !secret.
+func (m *yamlMerger) scalar(old *yaml.Node, value interface{}) (*yaml.Node, error) {
+	node, err := m.fresh(old, value)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := value.(string)
+	if !ok || node.Kind != yaml.ScalarNode {
+		return node, nil
+	}
+
+	quoted := old.Style & (yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle)
+	block := old.Style & (yaml.LiteralStyle | yaml.FoldedStyle)
+	switch {
+	case quoted != 0:
+		node.Style = quoted
+	case block != 0 && strings.Contains(s, "\n"):
+		node.Style = block
+	}
+	if strings.HasPrefix(old.Tag, "!") && !strings.HasPrefix(old.Tag, "!!") {
+		node.Tag = old.Tag
+		node.Style |= yaml.TaggedStyle
+	}
+	return node, nil
+}
+
+// fresh encodes value as a new node in place of old, if any, keeping its
+// comments, its anchor and whether it was written in flow style
+func (m *yamlMerger) fresh(old *yaml.Node, value interface{}) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return nil, err
+	}
+	if old != nil {
+		node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		if old.Kind != yaml.AliasNode {
+			node.Anchor = old.Anchor
+		}
+		if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+			node.Style |= old.Style & yaml.FlowStyle
+		}
+	}
+	m.record(node)
+	return node, nil
+}
+
+func (m *yamlMerger) record(node *yaml.Node) {
+	if node.Anchor != "" {
+		m.anchors[node.Anchor] = node
+	}
+}
+
+// decodesTo reports whether node holds value
+func decodesTo(node *yaml.Node, value interface{}) bool {
+	var current interface{}
+	return node.Decode(&current) == nil && yamlEqual(current, value)
+}
+
+// yamlEqual reports whether two values are equal, comparing numbers by
+// value whatever their type
+func yamlEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, item := range av {
+			other, ok := bv[k]
+			if !ok || !yamlEqual(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !yamlEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if an, ok := yamlNumber(a); ok {
+		bn, ok := yamlNumber(b)
+		return ok && an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func yamlNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,417 @@
+// Package msgpack reads and writes MessagePack as the generic values used
+// across tq. Integers decode to int64 (uint64 above the int64 range) and
+// floats to float64, so the two stay distinct; bin values decode to
+// []byte, the timestamp extension to time.Time, and other extensions to
+// Ext.
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxDepth bounds the nesting of arrays and maps in decoded input
+const maxDepth = 10000
+
+// timestampType is the extension type of timestamps
+const timestampType = -1
+
+// Ext is an extension value other than a timestamp
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// Decoder reads a stream of MessagePack values
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder returns a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next value into v, which must be a *interface{}. It
+// returns io.EOF when the stream ends between values.
+func (d *Decoder) Decode(v interface{}) error {
+	ptr, ok := v.(*interface{})
+	if !ok {
+		return fmt.Errorf("msgpack: Decode requires *interface{}, got %T", v)
+	}
+	if _, err := d.r.Peek(1); err != nil {
+		return err
+	}
+	value, err := d.value(0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	*ptr = value
+	return nil
+}
+
+func (d *Decoder) byte() (byte, error) {
+	return d.r.ReadByte()
+}
+
+// bytes reads n bytes, growing the buffer as data arrives rather than
+// trusting a length read from the input
+func (d *Decoder) bytes(n uint64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes
+func (d *Decoder) uint(size int) (uint64, error) {
+	data, err := d.bytes(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range data {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func (d *Decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("msgpack: nesting too deep")
+	}
+	b, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.mapOf(uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return d.array(uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return d.str(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from size bytes
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n, depth)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", b)
+}
+
+func (d *Decoder) str(n uint64) (interface{}, error) {
+	data, err := d.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (d *Decoder) array(n uint64, depth int) (interface{}, error) {
+	items := make([]interface{}, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// mapOf reads a map. Keys that are not strings are formatted as text.
+func (d *Decoder) mapOf(n uint64, depth int) (interface{}, error) {
+	obj := make(map[string]interface{}, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok {
+			obj[s] = value
+		} else {
+			obj[fmt.Sprint(key)] = value
+		}
+	}
+	return obj, nil
+}
+
+// ext reads an extension of n data bytes
+func (d *Decoder) ext(n uint64) (interface{}, error) {
+	t, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(t) != timestampType {
+		return Ext{Type: int8(t), Data: data}, nil
+	}
+
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		n := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(n&(1<<34-1)), int64(n>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	}
+	return nil, fmt.Errorf("msgpack: invalid timestamp of %d bytes", len(data))
+}
+
+// Encode writes v as MessagePack. Integers use the smallest encoding,
+// float64 is always written as a 64-bit float, and map keys are sorted.
+func Encode(v interface{}) ([]byte, error) {
+	e := &encoder{}
+	if err := e.value(v); err != nil {
+		return nil, err
+	}
+	return e.b, nil
+}
+
+type encoder struct {
+	b []byte
+}
+
+// head writes a type byte followed by n in size bytes
+func (e *encoder) head(t byte, n uint64, size int) {
+	e.b = append(e.b, t)
+	for i := size - 1; i >= 0; i-- {
+		e.b = append(e.b, byte(n>>(8*i)))
+	}
+}
+
+// length writes the header of a str, bin, array or map; fix is the type
+// byte of the fixed form (0 when there is none), fixMax its largest length,
+// and t8 the type byte of the 8-bit form (0 when there is none), followed
+// by the 16- and 32-bit forms
+func (e *encoder) length(n int, fix byte, fixMax int, t8, t16 byte) error {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.b = append(e.b, fix|byte(n))
+	case t8 != 0 && n <= math.MaxUint8:
+		e.head(t8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		e.head(t16, uint64(n), 2)
+	case uint64(n) <= math.MaxUint32:
+		e.head(t16+1, uint64(n), 4)
+	default:
+		return fmt.Errorf("msgpack: length %d too large", n)
+	}
+	return nil
+}
+
+func (e *encoder) int(n int64) {
+	switch {
+	case n >= 0:
+		e.uint(uint64(n))
+	case n >= -32:
+		e.b = append(e.b, byte(n))
+	case n >= math.MinInt8:
+		e.head(0xd0, uint64(n), 1)
+	case n >= math.MinInt16:
+		e.head(0xd1, uint64(n), 2)
+	case n >= math.MinInt32:
+		e.head(0xd2, uint64(n), 4)
+	default:
+		e.head(0xd3, uint64(n), 8)
+	}
+}
+
+func (e *encoder) uint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.b = append(e.b, byte(n))
+	case n <= math.MaxUint8:
+		e.head(0xcc, n, 1)
+	case n <= math.MaxUint16:
+		e.head(0xcd, n, 2)
+	case n <= math.MaxUint32:
+		e.head(0xce, n, 4)
+	default:
+		e.head(0xcf, n, 8)
+	}
+}
+
+func (e *encoder) value(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		e.b = append(e.b, 0xc0)
+	case bool:
+		if val {
+			e.b = append(e.b, 0xc3)
+		} else {
+			e.b = append(e.b, 0xc2)
+		}
+	case int:
+		e.int(int64(val))
+	case int64:
+		e.int(val)
+	case uint64:
+		e.uint(val)
+	case float64:
+		e.head(0xcb, math.Float64bits(val), 8)
+	case string:
+		if err := e.length(len(val), 0xa0, 31, 0xd9, 0xda); err != nil {
+			return err
+		}
+		e.b = append(e.b, val...)
+	case []byte:
+		if err := e.length(len(val), 0, 0, 0xc4, 0xc5); err != nil {
+			return err
+		}
+		e.b = append(e.b, val...)
+	case time.Time:
+		e.timestamp(val)
+	case Ext:
+		e.ext(val.Type, val.Data)
+	case []interface{}:
+		if err := e.length(len(val), 0x90, 15, 0, 0xdc); err != nil {
+			return err
+		}
+		for _, item := range val {
+			if err := e.value(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if err := e.length(len(val), 0x80, 15, 0, 0xde); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := e.value(k); err != nil {
+				return err
+			}
+			if err := e.value(val[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode %T", v)
+	}
+	return nil
+}
+
+// ext writes an extension, using a fixext form when one fits
+func (e *encoder) ext(t int8, data []byte) {
+	switch n := len(data); {
+	case n == 1 || n == 2 || n == 4 || n == 8 || n == 16:
+		fix := map[int]byte{1: 0xd4, 2: 0xd5, 4: 0xd6, 8: 0xd7, 16: 0xd8}[n]
+		e.b = append(e.b, fix)
+	case n <= math.MaxUint8:
+		e.head(0xc7, uint64(n), 1)
+	case n <= math.MaxUint16:
+		e.head(0xc8, uint64(n), 2)
+	default:
+		e.head(0xc9, uint64(n), 4)
+	}
+	e.b = append(e.b, byte(t))
+	e.b = append(e.b, data...)
+}
+
+// timestamp writes the smallest timestamp form that holds t
+func (e *encoder) timestamp(t time.Time) {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec >= 0 && sec < 1<<34 && nsec == 0 && sec <= math.MaxUint32:
+		e.ext(timestampType, binary.BigEndian.AppendUint32(nil, uint32(sec)))
+	case sec >= 0 && sec < 1<<34:
+		e.ext(timestampType, binary.BigEndian.AppendUint64(nil, uint64(nsec)<<34|uint64(sec)))
+	default:
+		data := binary.BigEndian.AppendUint32(nil, uint32(nsec))
+		e.ext(timestampType, binary.BigEndian.AppendUint64(data, uint64(sec)))
+	}
+}
@@ -0,0 +1,97 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(128), int64(-32), int64(-33), int64(-129),
+		int64(65536), int64(math.MaxInt64), int64(math.MinInt64), uint64(math.MaxUint64),
+		1.0, -2.5, math.Inf(1),
+		"", "short", string(bytes.Repeat([]byte("x"), 300)),
+		[]byte{}, []byte{0, 1, 2},
+		[]interface{}{int64(1), "a", []interface{}{}},
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": nil}},
+		make([]interface{}, 20),
+		time.Unix(1700000000, 0).UTC(),
+		time.Unix(1700000000, 500).UTC(),
+		time.Unix(-1, 0).UTC(),
+		Ext{Type: 5, Data: []byte{1, 2, 3}},
+		Ext{Type: 7, Data: []byte{1, 2, 3, 4}},
+	}
+
+	for _, want := range values {
+		data, err := Encode(want)
+		if err != nil {
+			t.Fatalf("Encode(%v) failed: %v", want, err)
+		}
+		var got interface{}
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&got); err != nil {
+			t.Fatalf("Decode(%x) failed: %v", data, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Round trip of %v (%x) gave %#v", want, data, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{int64(1), "01"},
+		{int64(-1), "ff"},
+		{int64(200), "ccc8"},
+		{int64(-200), "d1ff38"},
+		{1.0, "cb3ff0000000000000"},
+		{"abc", "a3616263"},
+		{[]byte{1}, "c40101"},
+		{map[string]interface{}{"b": false, "a": nil}, "82a161c0a162c2"},
+		{time.Unix(1, 0), "d6ff00000001"},
+	}
+
+	for _, tt := range tests {
+		data, err := Encode(tt.value)
+		if err != nil {
+			t.Fatalf("Encode(%v) failed: %v", tt.value, err)
+		}
+		if got := hex.EncodeToString(data); got != tt.expected {
+			t.Errorf("Encode(%v) = %s, want %s", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	// float32, a map with an integer key, then the end of the stream
+	data, _ := hex.DecodeString("ca3fc000008101a178")
+	d := NewDecoder(bytes.NewReader(data))
+	var v interface{}
+	if err := d.Decode(&v); err != nil || v != 1.5 {
+		t.Fatalf("Expected 1.5, got %v (%v)", v, err)
+	}
+	if err := d.Decode(&v); err != nil || !reflect.DeepEqual(v, map[string]interface{}{"1": "x"}) {
+		t.Fatalf("Expected map, got %v (%v)", v, err)
+	}
+	if err := d.Decode(&v); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, s := range []string{"c1", "92 01", "db ffffffff", "d6ff0001"} {
+		data, _ := hex.DecodeString(string(bytes.ReplaceAll([]byte(s), []byte(" "), nil)))
+		var v interface{}
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&v); err == nil || err == io.EOF {
+			t.Errorf("Decode(%s): expected error, got %v", s, err)
+		}
+	}
+}
@@ -1,16 +1,107 @@
 package query
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"os"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // Engine executes queries on data
-type Engine struct{}
+type Engine struct {
+	input InputSource
+	nodes NodeSource
+	vars  map[string]interface{}
+	at    position // Where the value being queried sits in the input
+}
+
+// InputSource supplies the input values read by input() and inputs()
+type InputSource interface {
+	// Next returns the next input value, or io.EOF when none remain
+	Next() (interface{}, error)
+	// Filename names the file the current input came from, or returns
+	// nil for standard input
+	Filename() interface{}
+}
+
+// SetInput connects the engine to the input stream, so queries can read
+// further values with input() and inputs() and see input_filename()
+func (e *Engine) SetInput(src InputSource) {
+	e.input = src
+}
+
+// SetVariable defines $name for queries. $ENV, the environment, is always
+// defined unless set here.
+func (e *Engine) SetVariable(name string, value interface{}) {
+	if e.vars == nil {
+		e.vars = make(map[string]interface{})
+	}
+	e.vars[name] = value
+}
+
+// NodeSource describes where the values of the current input came from, for
+// formats such as YAML that keep more than their data
+type NodeSource interface {
+	// Line returns the line of the value at path, or 0 if it is unknown
+	Line(path []interface{}) int
+	// HeadComment returns the comment above the value at path, without
+	// the leading #
+	HeadComment(path []interface{}) string
+	// Explode replaces the aliases at and below path with copies of what
+	// they refer to, and expands merge keys
+	Explode(path []interface{})
+}
+
+// SetNodes connects the engine to the source of the current input, so
+// queries can use line(), head_comment() and explode()
+func (e *Engine) SetNodes(src NodeSource) {
+	e.nodes = src
+}
+
+// ErrEmpty is returned by Execute when the query produces no value, such as
+// a select whose condition is false or empty(). Unlike null, it is no output
+// at all.
+var ErrEmpty = errors.New("no value")
+
+// CompileError is an error in the query itself, such as an unknown function
+// or an unclosed bracket, as opposed to one in the data it runs on
+type CompileError struct {
+	Msg string
+}
+
+func (e *CompileError) Error() string {
+	return e.Msg
+}
+
+// compileError returns a *CompileError with a formatted message
+func compileError(format string, args ...interface{}) error {
+	return &CompileError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// HaltError is returned when the query calls halt() or halt_error(): the
+// program should write Message to stderr and exit with status Code
+type HaltError struct {
+	Code    int
+	Message string
+}
+
+func (e *HaltError) Error() string {
+	return fmt.Sprintf("halted with exit status %d", e.Code)
+}
+
+// position records the path of a value in the input, so that functions
+// asking where a value came from can find it
+type position struct {
+	path  []interface{}
+	value interface{}
+}
 
 // New creates a new query engine
 func New() *Engine {
@@ -20,6 +111,7 @@ func New() *Engine {
 // Execute runs a query on the given data
 func (e *Engine) Execute(query string, data interface{}) (interface{}, error) {
 	query = strings.TrimSpace(query)
+	e.at = position{path: []interface{}{}, value: data}
 
 	// Handle identity
 	if query == "." {
@@ -42,6 +134,12 @@ func (e *Engine) executeQuery(query string, data interface{}) (interface{}, erro
 		return e.executeIf(query, data)
 	}
 
+	// Handle assignment, which binds more loosely than // but more tightly
+	// than |
+	if op, at := findAssignment(query); at >= 0 && len(splitPipe(query)) == 1 {
+		return e.executeAssignment(query, op, at, data)
+	}
+
 	// Handle alternative operator //
 	if strings.Contains(query, "//") {
 		return e.executeAlternative(query, data)
@@ -52,10 +150,15 @@ func (e *Engine) executeQuery(query string, data interface{}) (interface{}, erro
 	}
 
 	// Handle pipe operations (but only if not inside brackets)
-	if strings.Contains(query, "|") {
+	if strings.Contains(query, "|") && len(splitPipe(query)) > 1 {
 		return e.executePipe(query, data)
 	}
 
+	// Handle variables, optionally followed by a path ($ARGS.named.x)
+	if strings.HasPrefix(query, "$") {
+		return e.executeVariable(query)
+	}
+
 	// Handle array operations
 	if strings.Contains(query, "[]") {
 		return e.executeArrayIteration(query, data)
@@ -81,13 +184,17 @@ func (e *Engine) executeQuery(query string, data interface{}) (interface{}, erro
 		return val, nil
 	}
 
-	return nil, fmt.Errorf("unsupported query: %s", query)
+	return nil, compileError("unsupported query: %s", query)
 }
 
 func (e *Engine) executePipe(query string, data interface{}) (interface{}, error) {
 	// Split by pipe, handling nested structures
 	parts := splitPipe(query)
 
+	// Positions found along this pipe do not outlive it
+	at := e.at
+	defer func() { e.at = at }()
+
 	result := data
 	for i, part := range parts {
 		part = strings.TrimSpace(part)
@@ -97,28 +204,81 @@ func (e *Engine) executePipe(query string, data interface{}) (interface{}, error
 		if arr, ok := result.([]interface{}); ok && i > 0 && strings.Contains(parts[i-1], "[]") {
 			// Apply this part to each element
 			var results []interface{}
-			for _, elem := range arr {
+			iterated := e.at
+			for j, elem := range arr {
+				e.at = iterated.element(arr, j)
 				elemResult, err := e.executeQuery(part, elem)
+				if errors.Is(err, ErrEmpty) {
+					// Elements rejected by select drop out
+					continue
+				}
 				if err != nil {
 					return nil, err
 				}
-				// Only include non-nil results (for select filters)
-				if elemResult != nil {
-					results = append(results, elemResult)
-				}
+				results = append(results, elemResult)
 			}
 			result = results
+			e.at = position{}
 		} else {
-			result, err = e.executeQuery(part, result)
+			in := result
+			result, err = e.executeQuery(part, in)
 			if err != nil {
 				return nil, err
 			}
+			e.at = e.at.follow(part, in, result)
 		}
 	}
 
 	return result, nil
 }
 
+// follow moves a position along the path expression query, which took in
+// to out. Anything else that returns its input, such as select, keeps the
+// position.
+func (p position) follow(query string, in, out interface{}) position {
+	steps, ok := parsePath(query)
+	if !ok || p.path == nil || !sameValue(p.value, in) {
+		return p
+	}
+	return position{path: append(copyPath(p.path), steps...), value: out}
+}
+
+// element returns the position of element j of arr, the result of a path
+// with one [] step at this position
+func (p position) element(arr []interface{}, j int) position {
+	if !sameValue(p.value, arr) {
+		return position{}
+	}
+	path := copyPath(p.path)
+	iterations := 0
+	for i, step := range path {
+		if step == nil {
+			path[i] = j
+			iterations++
+		}
+	}
+	if iterations != 1 {
+		return position{}
+	}
+	return position{path: path, value: arr[j]}
+}
+
+// sameValue reports whether a and b are the same value in memory: the same
+// object or array, or equal scalars
+func sameValue(a, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		return ok && reflect.ValueOf(av).Pointer() == reflect.ValueOf(bv).Pointer()
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		return ok && len(av) == len(bv) && (len(av) == 0 || &av[0] == &bv[0])
+	case nil:
+		return b == nil
+	}
+	return reflect.TypeOf(a).Comparable() && a == b
+}
+
 func (e *Engine) executeFieldAccess(query string, data interface{}) (interface{}, error) {
 	// Remove leading dot
 	path := strings.TrimPrefix(query, ".")
@@ -211,7 +371,7 @@ func (e *Engine) executeArrayIndex(path string, data interface{}) (interface{},
 
 	bracketEnd := strings.Index(path, "]")
 	if bracketEnd == -1 {
-		return nil, fmt.Errorf("unclosed bracket in path: %s", path)
+		return nil, compileError("unclosed bracket in path: %s", path)
 	}
 
 	// Extract parts
@@ -259,8 +419,9 @@ func (e *Engine) executeArrayIndex(path string, data interface{}) (interface{},
 		index = len(arr) + index
 	}
 
+	// As in jq, an index past either end is null
 	if index < 0 || index >= len(arr) {
-		return nil, fmt.Errorf("array index out of bounds: %d (array length: %d)", index, len(arr))
+		return nil, nil
 	}
 
 	result := arr[index]
@@ -306,7 +467,7 @@ func (e *Engine) executeArrayIteration(query string, data interface{}) (interfac
 func (e *Engine) executeSelect(query string, data interface{}) (interface{}, error) {
 	// Parse select(condition)
 	if !strings.HasPrefix(query, "select(") || !strings.HasSuffix(query, ")") {
-		return nil, fmt.Errorf("invalid select syntax")
+		return nil, compileError("invalid select syntax")
 	}
 
 	condition := query[7 : len(query)-1]
@@ -321,7 +482,7 @@ func (e *Engine) executeSelect(query string, data interface{}) (interface{}, err
 		return data, nil
 	}
 
-	return nil, nil
+	return nil, ErrEmpty
 }
 
 func (e *Engine) evaluateCondition(condition string, data interface{}) (bool, error) {
@@ -340,8 +501,13 @@ func (e *Engine) evaluateCondition(condition string, data interface{}) (bool, er
 				return false, err
 			}
 
-			// Parse right side
-			rightVal, err := parseValue(right)
+			// Parse right side, which may be a variable
+			var rightVal interface{}
+			if strings.HasPrefix(right, "$") {
+				rightVal, err = e.executeVariable(right)
+			} else {
+				rightVal, err = parseValue(right)
+			}
 			if err != nil {
 				return false, err
 			}
@@ -350,7 +516,7 @@ func (e *Engine) evaluateCondition(condition string, data interface{}) (bool, er
 		}
 	}
 
-	return false, fmt.Errorf("unsupported condition: %s", condition)
+	return false, compileError("unsupported condition: %s", condition)
 }
 
 func (e *Engine) executeArrayConstruction(query string, data interface{}) (interface{}, error) {
@@ -363,6 +529,9 @@ func (e *Engine) executeArrayConstruction(query string, data interface{}) (inter
 
 	// Execute inner query - this might produce multiple results
 	result, err := e.executeQuery(inner, data)
+	if errors.Is(err, ErrEmpty) {
+		return []interface{}{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -400,7 +569,7 @@ func (e *Engine) executeObjectConstruction(query string, data interface{}) (inte
 		if strings.Contains(pair, ":") {
 			parts := strings.SplitN(pair, ":", 2)
 			if len(parts) != 2 {
-				return nil, fmt.Errorf("invalid object construction syntax: %s", pair)
+				return nil, compileError("invalid object construction syntax: %s", pair)
 			}
 
 			key := strings.TrimSpace(parts[0])
@@ -465,7 +634,7 @@ func splitPipe(query string) []string {
 	var current strings.Builder
 	depth := 0
 
-	for _, ch := range query {
+	for i, ch := range query {
 		switch ch {
 		case '(', '[', '{':
 			depth++
@@ -474,7 +643,8 @@ func splitPipe(query string) []string {
 			depth--
 			current.WriteRune(ch)
 		case '|':
-			if depth == 0 {
+			// |= is an assignment, not a pipe
+			if depth == 0 && !strings.HasPrefix(query[i+1:], "=") {
 				parts = append(parts, current.String())
 				current.Reset()
 			} else {
@@ -584,13 +754,13 @@ func (e *Engine) executeFunction(query string, data interface{}) (interface{}, e
 	// Parse function name and arguments
 	parenIdx := strings.Index(query, "(")
 	if parenIdx == -1 {
-		return nil, fmt.Errorf("invalid function syntax: %s", query)
+		return nil, compileError("invalid function syntax: %s", query)
 	}
 
 	funcName := strings.TrimSpace(query[:parenIdx])
 	argsStr := query[parenIdx+1:]
 	if !strings.HasSuffix(argsStr, ")") {
-		return nil, fmt.Errorf("unclosed function parenthesis: %s", query)
+		return nil, compileError("unclosed function parenthesis: %s", query)
 	}
 	argsStr = strings.TrimSuffix(argsStr, ")")
 
@@ -663,8 +833,32 @@ func (e *Engine) executeFunction(query string, data interface{}) (interface{}, e
 		return e.funcFromEntries(data)
 	case "with_entries":
 		return e.funcWithEntries(argsStr, data)
+	case "input":
+		return e.funcInput()
+	case "inputs":
+		return e.funcInputs()
+	case "input_filename":
+		return e.funcInputFilename()
+	case "empty":
+		return nil, ErrEmpty
+	case "halt":
+		return nil, &HaltError{}
+	case "halt_error":
+		return e.funcHaltError(argsStr, data)
+	case "tostream":
+		return e.funcToStream(data)
+	case "fromstream":
+		return e.funcFromStream(argsStr, data)
+	case "truncate_stream":
+		return e.funcTruncateStream(argsStr, data)
+	case "line":
+		return e.funcLine(data)
+	case "head_comment":
+		return e.funcHeadComment(data)
+	case "explode":
+		return e.funcExplode(data)
 	default:
-		return nil, fmt.Errorf("unknown function: %s", funcName)
+		return nil, compileError("unknown function: %s", funcName)
 	}
 }
 
@@ -838,13 +1032,16 @@ func (e *Engine) funcMap(expr string, data interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("map requires an array")
 	}
 
-	result := make([]interface{}, len(arr))
+	result := make([]interface{}, 0, len(arr))
 	for i, elem := range arr {
 		mapped, err := e.executeQuery(strings.TrimSpace(expr), elem)
+		if errors.Is(err, ErrEmpty) {
+			continue
+		}
 		if err != nil {
 			return nil, fmt.Errorf("map error at index %d: %w", i, err)
 		}
-		result[i] = mapped
+		result = append(result, mapped)
 	}
 
 	return result, nil
@@ -1509,10 +1706,603 @@ func (e *Engine) funcWithEntries(argsStr string, data interface{}) (interface{},
 	return e.funcFromEntries(results)
 }
 
+// funcInput reads the next input value
+func (e *Engine) funcInput() (interface{}, error) {
+	if e.input == nil {
+		return nil, fmt.Errorf("no more inputs")
+	}
+	value, err := e.input.Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no more inputs")
+	}
+	return value, err
+}
+
+// funcInputs reads all remaining input values into an array
+func (e *Engine) funcInputs() (interface{}, error) {
+	result := make([]interface{}, 0)
+	if e.input == nil {
+		return result, nil
+	}
+	for {
+		value, err := e.input.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+}
+
+// funcInputFilename returns the name of the file being processed, or null
+// for standard input
+func (e *Engine) funcInputFilename() (interface{}, error) {
+	if e.input == nil {
+		return nil, nil
+	}
+	return e.input.Filename(), nil
+}
+
+// funcHaltError stops the program with the given exit status (default 5),
+// writing its input to stderr: a string as it is, anything else as JSON on
+// a line of its own
+func (e *Engine) funcHaltError(argsStr string, data interface{}) (interface{}, error) {
+	code := 5
+	if arg := strings.TrimSpace(argsStr); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("halt_error: invalid exit status: %w", err)
+		}
+		code = n
+	}
+
+	message, ok := data.(string)
+	if !ok {
+		text, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("halt_error: %w", err)
+		}
+		message = string(text) + "\n"
+	}
+	return nil, &HaltError{Code: code, Message: message}
+}
+
+// funcLine returns the line of the input the value came from, or null when
+// that is not known
+func (e *Engine) funcLine(data interface{}) (interface{}, error) {
+	path, ok := e.pathOf(data)
+	if !ok {
+		return nil, nil
+	}
+	if line := e.nodes.Line(path); line > 0 {
+		return line, nil
+	}
+	return nil, nil
+}
+
+// funcHeadComment returns the comment above the value in the input, or
+// null when where the value came from is not known
+func (e *Engine) funcHeadComment(data interface{}) (interface{}, error) {
+	path, ok := e.pathOf(data)
+	if !ok {
+		return nil, nil
+	}
+	return e.nodes.HeadComment(path), nil
+}
+
+// funcExplode resolves the aliases and merge keys of the input at the value,
+// or of the whole input when where the value came from is not known, so
+// that they are written out in full. The value itself is unchanged: aliases
+// are already resolved in the data.
+func (e *Engine) funcExplode(data interface{}) (interface{}, error) {
+	if e.nodes == nil {
+		return data, nil
+	}
+	path, ok := e.pathOf(data)
+	if !ok {
+		path = []interface{}{}
+	}
+	e.nodes.Explode(path)
+	return data, nil
+}
+
+// pathOf returns the path of data in the input, if it is known
+func (e *Engine) pathOf(data interface{}) ([]interface{}, bool) {
+	if e.nodes == nil || e.at.path == nil || !sameValue(e.at.value, data) {
+		return nil, false
+	}
+	for _, step := range e.at.path {
+		if step == nil {
+			return nil, false
+		}
+	}
+	return e.at.path, true
+}
+
+// funcToStream converts a value into jq streaming form: a [path, leaf]
+// event for every scalar and empty container, and a closing [path] event
+// after the last child of each non-empty container
+func (e *Engine) funcToStream(data interface{}) (interface{}, error) {
+	events := make([]interface{}, 0)
+	var walk func(path []interface{}, v interface{})
+	walk = func(path []interface{}, v interface{}) {
+		var children []interface{} // Keys or indices, in order
+		switch val := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(val))
+			for k := range val {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				children = append(children, k)
+			}
+		case []interface{}:
+			for i := range val {
+				children = append(children, i)
+			}
+		}
+
+		if len(children) == 0 {
+			events = append(events, []interface{}{copyPath(path), v})
+			return
+		}
+		for _, child := range children {
+			walk(append(path, child), childValue(v, child))
+		}
+		last := append(copyPath(path), children[len(children)-1])
+		events = append(events, []interface{}{last})
+	}
+	walk(nil, data)
+	return events, nil
+}
+
+// funcFromStream rebuilds values from the streaming events produced by its
+// argument. One complete value is returned as is; several (for example from
+// truncated events) are returned as an array.
+func (e *Engine) funcFromStream(argsStr string, data interface{}) (interface{}, error) {
+	arg, err := e.executeQuery(strings.TrimSpace(argsStr), data)
+	if err != nil {
+		return nil, err
+	}
+	events, err := streamEvents(arg)
+	if err != nil {
+		return nil, fmt.Errorf("fromstream: %w", err)
+	}
+
+	var results []interface{}
+	var current interface{}
+	for _, event := range events {
+		path := event[0].([]interface{})
+		done := false
+		if len(event) == 2 {
+			current, err = setPath(current, path, event[1])
+			if err != nil {
+				return nil, fmt.Errorf("fromstream: %w", err)
+			}
+			done = len(path) == 0
+		} else {
+			done = len(path) == 1
+		}
+		if done {
+			results = append(results, current)
+			current = nil
+		}
+	}
+
+	if len(results) == 1 {
+		return results[0], nil
+	}
+	return results, nil
+}
+
+// funcTruncateStream removes leading path elements from streaming events,
+// dropping events whose path is not longer than the depth. It accepts jq's
+// form, where the input is the depth and the argument yields the events
+// (1 | truncate_stream(...)), as well as the depth as argument with events
+// as input, which suits --stream: truncate_stream(1).
+func (e *Engine) funcTruncateStream(argsStr string, data interface{}) (interface{}, error) {
+	arg, err := e.executeQuery(strings.TrimSpace(argsStr), data)
+	if err != nil {
+		return nil, err
+	}
+
+	depthValue, input := arg, data
+	if _, ok := toNumber(data); ok {
+		depthValue, input = data, arg
+	}
+	depth, ok := toNumber(depthValue)
+	if !ok || depth < 0 {
+		return nil, fmt.Errorf("truncate_stream: depth must be a non-negative number")
+	}
+	n := int(depth)
+
+	truncate := func(event []interface{}) interface{} {
+		path := event[0].([]interface{})
+		if len(path) <= n {
+			return nil
+		}
+		return append([]interface{}{copyPath(path[n:])}, event[1:]...)
+	}
+
+	if isStreamEvent(input) {
+		return truncate(input.([]interface{})), nil
+	}
+	events, err := streamEvents(input)
+	if err != nil {
+		return nil, fmt.Errorf("truncate_stream: %w", err)
+	}
+	results := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		if truncated := truncate(event); truncated != nil {
+			results = append(results, truncated)
+		}
+	}
+	return results, nil
+}
+
+// isStreamEvent reports whether v is a single [path] or [path, leaf] event
+func isStreamEvent(v interface{}) bool {
+	event, ok := v.([]interface{})
+	if !ok || len(event) < 1 || len(event) > 2 {
+		return false
+	}
+	path, ok := event[0].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, p := range path {
+		if _, isString := p.(string); !isString {
+			if _, isNumber := toNumber(p); !isNumber {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// streamEvents accepts a single event or an array of events
+func streamEvents(v interface{}) ([][]interface{}, error) {
+	if isStreamEvent(v) {
+		return [][]interface{}{v.([]interface{})}, nil
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected streaming events, got %T", v)
+	}
+	events := make([][]interface{}, len(arr))
+	for i, item := range arr {
+		if !isStreamEvent(item) {
+			return nil, fmt.Errorf("invalid streaming event at index %d", i)
+		}
+		events[i] = item.([]interface{})
+	}
+	return events, nil
+}
+
+// setPath returns root with the value at path set to value, creating
+// objects for string keys and arrays for indices along the way
+func setPath(root interface{}, path []interface{}, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	if key, ok := path[0].(string); ok {
+		obj, isObject := root.(map[string]interface{})
+		if root == nil {
+			obj, isObject = make(map[string]interface{}), true
+		}
+		if !isObject {
+			return nil, fmt.Errorf("cannot index %T with %q", root, key)
+		}
+		child, err := setPath(obj[key], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = child
+		return obj, nil
+	}
+
+	n, _ := toNumber(path[0])
+	index := int(n)
+	arr, isArray := root.([]interface{})
+	if root != nil && !isArray {
+		return nil, fmt.Errorf("cannot index %T with number", root)
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("out of bounds negative array index")
+	}
+	for len(arr) <= index {
+		arr = append(arr, nil)
+	}
+	child, err := setPath(arr[index], path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	arr[index] = child
+	return arr, nil
+}
+
+// executeVariable evaluates $name, followed by an optional path such as
+// .named.x or [0]
+func (e *Engine) executeVariable(query string) (interface{}, error) {
+	end := 1
+	for end < len(query) && (query[end] == '_' || unicode.IsLetter(rune(query[end])) || unicode.IsDigit(rune(query[end]))) {
+		end++
+	}
+	name, rest := query[1:end], strings.TrimSpace(query[end:])
+
+	value, ok := e.vars[name]
+	if !ok && name == "ENV" {
+		value, ok = environment(), true
+	}
+	if !ok {
+		return nil, compileError("$%s is not defined", name)
+	}
+
+	if rest == "" {
+		return value, nil
+	}
+	if !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+		return nil, compileError("unsupported query: %s", query)
+	}
+	return e.executeQuery("."+strings.TrimPrefix(rest, "."), value)
+}
+
+// environment returns the environment variables as an object
+func environment() map[string]interface{} {
+	env := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// findAssignment returns the first assignment operator, = or |=, outside
+// brackets and strings in query, and its offset, or -1 if there is none.
+// Arithmetic update operators such as += are returned too, so that they can
+// be reported as unsupported rather than as a bad path.
+func findAssignment(query string) (string, int) {
+	depth := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case inString:
+			if ch == '\\' {
+				i++
+			} else if ch == '"' {
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '(' || ch == '[' || ch == '{':
+			depth++
+		case ch == ')' || ch == ']' || ch == '}':
+			depth--
+		case depth > 0:
+		case ch == '|' && strings.HasPrefix(query[i+1:], "="):
+			return "|=", i
+		case ch == '=':
+			if strings.HasPrefix(query[i+1:], "=") {
+				i++ // ==
+				continue
+			}
+			if i > 0 && strings.IndexByte("!<>", query[i-1]) >= 0 {
+				continue
+			}
+			if strings.HasSuffix(query[:i], "//") {
+				return "//=", i - 2
+			}
+			if i > 0 && strings.IndexByte("+-*/%", query[i-1]) >= 0 {
+				return query[i-1 : i+1], i - 1
+			}
+			return "=", i
+		}
+	}
+	return "", -1
+}
+
+// executeAssignment runs path = value, which sets every path matched on the
+// left to the value on the right, evaluated against the input, and
+// path |= f, which replaces the value at every path with f applied to it.
+// The input itself is left unchanged.
+func (e *Engine) executeAssignment(query, op string, at int, data interface{}) (interface{}, error) {
+	if op != "=" && op != "|=" {
+		return nil, compileError("unsupported operator %s", op)
+	}
+	left := strings.TrimSpace(query[:at])
+	right := strings.TrimSpace(query[at+len(op):])
+
+	steps, ok := parsePath(left)
+	if !ok {
+		return nil, compileError("invalid path on the left of %s: %s", op, left)
+	}
+	paths, err := expandPath(data, steps)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if op == "=" {
+		if value, err = e.executeQuery(right, data); err != nil {
+			return nil, err
+		}
+	}
+
+	result := copyValue(data)
+	for _, path := range paths {
+		v := copyValue(value)
+		if op == "|=" {
+			if v, err = e.executeQuery(right, getPath(result, path)); err != nil {
+				return nil, err
+			}
+		}
+		if result, err = setPath(result, path, v); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// parsePath parses a path expression made of .name, [n] and [] steps, such
+// as .a.b, .items[0].name or .items[], into its keys and indices, with nil
+// for each []
+func parsePath(query string) ([]interface{}, bool) {
+	query = strings.TrimSpace(query)
+	if !strings.HasPrefix(query, ".") {
+		return nil, false
+	}
+
+	path := []interface{}{}
+	rest := query
+	for rest != "" {
+		switch rest[0] {
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, false
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			if inner == "" {
+				path = append(path, nil)
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, false
+				}
+				path = append(path, index)
+			}
+			rest = rest[end+1:]
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" && strings.HasPrefix(rest, ".") {
+				return nil, false
+			}
+			if name != "" {
+				if strings.IndexFunc(name, func(r rune) bool {
+					return !(r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r))
+				}) >= 0 {
+					return nil, false
+				}
+				path = append(path, name)
+			}
+			rest = rest[end:]
+		default:
+			return nil, false
+		}
+	}
+	return path, true
+}
+
+// expandPath resolves the [] steps and negative indices of a parsed path
+// against data, giving every path it refers to
+func expandPath(data interface{}, steps []interface{}) ([][]interface{}, error) {
+	paths := [][]interface{}{{}}
+	for _, step := range steps {
+		var next [][]interface{}
+		for _, path := range paths {
+			v := getPath(data, path)
+			switch s := step.(type) {
+			case nil:
+				switch val := v.(type) {
+				case []interface{}:
+					for i := range val {
+						next = append(next, append(copyPath(path), i))
+					}
+				case map[string]interface{}:
+					keys := make([]string, 0, len(val))
+					for k := range val {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, append(copyPath(path), k))
+					}
+				default:
+					return nil, fmt.Errorf("cannot iterate over %T", v)
+				}
+			case int:
+				if s < 0 {
+					arr, _ := v.([]interface{})
+					if s += len(arr); s < 0 {
+						return nil, fmt.Errorf("out of bounds negative array index")
+					}
+				}
+				next = append(next, append(copyPath(path), s))
+			default:
+				next = append(next, append(copyPath(path), step))
+			}
+		}
+		paths = next
+	}
+	return paths, nil
+}
+
+// getPath returns the value at path, or null if there is none
+func getPath(v interface{}, path []interface{}) interface{} {
+	for _, step := range path {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			key, _ := step.(string)
+			v = val[key]
+		case []interface{}:
+			index, ok := step.(int)
+			if !ok || index < 0 || index >= len(val) {
+				return nil
+			}
+			v = val[index]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// copyValue returns a deep copy of the objects and arrays in v
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			obj[k] = copyValue(item)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(val))
+		for i, item := range val {
+			arr[i] = copyValue(item)
+		}
+		return arr
+	}
+	return v
+}
+
+// childValue returns the element of an object or array at key
+func childValue(v interface{}, key interface{}) interface{} {
+	if k, ok := key.(string); ok {
+		return v.(map[string]interface{})[k]
+	}
+	return v.([]interface{})[key.(int)]
+}
+
+func copyPath(path []interface{}) []interface{} {
+	return append([]interface{}{}, path...)
+}
+
 func (e *Engine) executeIf(query string, data interface{}) (interface{}, error) {
 	// Format: if COND then TRUE_BRANCH else FALSE_BRANCH end
 	if !strings.HasSuffix(query, " end") {
-		return nil, fmt.Errorf("if statement must end with 'end'")
+		return nil, compileError("if statement must end with 'end'")
 	}
 
 	// Remove "if " and " end"
@@ -1521,7 +2311,7 @@ func (e *Engine) executeIf(query string, data interface{}) (interface{}, error)
 	// Find " then "
 	thenIdx := strings.Index(inner, " then ")
 	if thenIdx == -1 {
-		return nil, fmt.Errorf("if statement missing 'then'")
+		return nil, compileError("if statement missing 'then'")
 	}
 
 	condStr := strings.TrimSpace(inner[:thenIdx])
@@ -1532,7 +2322,7 @@ func (e *Engine) executeIf(query string, data interface{}) (interface{}, error)
 	// A proper parser would be needed for nested structures
 	elseIdx := strings.LastIndex(rest, " else ")
 	if elseIdx == -1 {
-		return nil, fmt.Errorf("if statement missing 'else'")
+		return nil, compileError("if statement missing 'else'")
 	}
 
 	trueBranch := strings.TrimSpace(rest[:elseIdx])
@@ -1575,6 +2365,10 @@ func (e *Engine) executeAlternative(query string, data interface{}) (interface{}
 		if err == nil && isTruthy(result) {
 			return result, nil
 		}
+		// No value at all counts as false
+		if errors.Is(err, ErrEmpty) {
+			continue
+		}
 		// If error, continue to next alternative?
 		// jq behavior: errors in alternatives propagate, but null/false trigger next
 		// For now, let's propagate errors
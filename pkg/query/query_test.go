@@ -1,6 +1,10 @@
 package query
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
 	"testing"
 )
 
@@ -680,3 +684,388 @@ func TestExecuteAlternative(t *testing.T) {
 		}
 	})
 }
+
+func TestStreamFunctions(t *testing.T) {
+	engine := New()
+	data := map[string]interface{}{
+		"a": []interface{}{float64(1), map[string]interface{}{"b": float64(2)}},
+		"c": map[string]interface{}{},
+	}
+
+	events, err := engine.Execute("tostream()", data)
+	if err != nil {
+		t.Fatalf("tostream failed: %v", err)
+	}
+	expected := []interface{}{
+		[]interface{}{[]interface{}{"a", 0}, float64(1)},
+		[]interface{}{[]interface{}{"a", 1, "b"}, float64(2)},
+		[]interface{}{[]interface{}{"a", 1, "b"}},
+		[]interface{}{[]interface{}{"a", 1}},
+		[]interface{}{[]interface{}{"c"}, map[string]interface{}{}},
+		[]interface{}{[]interface{}{"c"}},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("tostream:\nwant %v\ngot  %v", expected, events)
+	}
+
+	t.Run("fromstream_roundtrip", func(t *testing.T) {
+		result, err := engine.Execute("fromstream(tostream())", data)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if !reflect.DeepEqual(result, data) {
+			t.Errorf("Expected %v, got %v", data, result)
+		}
+	})
+
+	t.Run("truncate_stream", func(t *testing.T) {
+		result, err := engine.Execute("fromstream(truncate_stream(1))", events)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		// As in jq, the empty "c" is a leaf at depth 1 and is dropped
+		if !reflect.DeepEqual(result, data["a"]) {
+			t.Errorf("Expected %v, got %v", data["a"], result)
+		}
+	})
+
+	t.Run("truncate_single_event", func(t *testing.T) {
+		event := []interface{}{[]interface{}{"a", float64(0)}, float64(1)}
+		result, err := engine.Execute("truncate_stream(1)", event)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		expected := []interface{}{[]interface{}{float64(0)}, float64(1)}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("invalid_events", func(t *testing.T) {
+		if _, err := engine.Execute("fromstream(.)", "nope"); err == nil {
+			t.Error("Expected error for non-event input")
+		}
+	})
+}
+
+// sliceInput is an InputSource over fixed values
+type sliceInput struct {
+	values []interface{}
+	name   interface{}
+}
+
+func (s *sliceInput) Next() (interface{}, error) {
+	if len(s.values) == 0 {
+		return nil, io.EOF
+	}
+	value := s.values[0]
+	s.values = s.values[1:]
+	return value, nil
+}
+
+func (s *sliceInput) Filename() interface{} {
+	return s.name
+}
+
+func TestInputFunctions(t *testing.T) {
+	engine := New()
+	engine.SetInput(&sliceInput{values: []interface{}{float64(1), float64(2), float64(3)}, name: "data.json"})
+
+	result, err := engine.Execute("input()", nil)
+	if err != nil || result != float64(1) {
+		t.Fatalf("Expected 1, got %v (%v)", result, err)
+	}
+
+	result, err = engine.Execute("inputs()", nil)
+	if err != nil || !reflect.DeepEqual(result, []interface{}{float64(2), float64(3)}) {
+		t.Fatalf("Expected [2 3], got %v (%v)", result, err)
+	}
+
+	if _, err := engine.Execute("input()", nil); err == nil {
+		t.Error("Expected error when inputs are exhausted")
+	}
+
+	result, err = engine.Execute("input_filename()", nil)
+	if err != nil || result != "data.json" {
+		t.Errorf("Expected data.json, got %v (%v)", result, err)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	engine := New()
+	newData := func() map[string]interface{} {
+		return map[string]interface{}{
+			"a":     map[string]interface{}{"b": float64(1)},
+			"items": []interface{}{map[string]interface{}{"n": "x"}, map[string]interface{}{"n": "y"}},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		path  []interface{}
+		want  interface{}
+	}{
+		{"set", `.a.b = 2`, []interface{}{"a", "b"}, float64(2)},
+		{"create", `.a.c.d = "new"`, []interface{}{"a", "c", "d"}, "new"},
+		{"from_input", `.a.c = .items[1].n`, []interface{}{"a", "c"}, "y"},
+		{"index", `.items[0].n = true`, []interface{}{"items", 0, "n"}, true},
+		{"negative_index", `.items[-1].n = null`, []interface{}{"items", 1, "n"}, nil},
+		{"iterate", `.items[].n = "z"`, []interface{}{"items", 1, "n"}, "z"},
+		{"update", `.a.b |= tostring()`, []interface{}{"a", "b"}, "1"},
+		{"update_each", `.items[].n |= length()`, []interface{}{"items", 0, "n"}, 1},
+		{"then_pipe", `.a.b = 5 | .a`, nil, map[string]interface{}{"b": float64(5)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := newData()
+			result, err := engine.Execute(tt.query, data)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if got := getPath(result, tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if !reflect.DeepEqual(data, newData()) {
+				t.Errorf("Input was modified: %v", data)
+			}
+		})
+	}
+
+	t.Run("equality_is_not_assignment", func(t *testing.T) {
+		result, err := engine.Execute(`select(.a.b == 1)`, newData())
+		if err != nil || result == nil {
+			t.Errorf("Expected the input back, got %v (%v)", result, err)
+		}
+	})
+
+	t.Run("invalid_path", func(t *testing.T) {
+		if _, err := engine.Execute(`length() = 1`, newData()); err == nil {
+			t.Error("Expected error for a non-path left side")
+		}
+	})
+
+	for _, op := range []string{"+=", "-=", "*=", "/=", "%=", "//="} {
+		t.Run("unsupported_"+op, func(t *testing.T) {
+			_, err := engine.Execute(`.a.b `+op+` 1`, newData())
+			var compile *CompileError
+			if !errors.As(err, &compile) || compile.Error() != "unsupported operator "+op {
+				t.Errorf("Expected an unsupported operator error, got %v", err)
+			}
+		})
+	}
+}
+
+// fakeNodes is a NodeSource that numbers lines by path length
+type fakeNodes struct {
+	exploded [][]interface{}
+}
+
+func (f *fakeNodes) Line(path []interface{}) int {
+	return len(path) + 1
+}
+
+func (f *fakeNodes) HeadComment(path []interface{}) string {
+	return fmt.Sprint(path)
+}
+
+func (f *fakeNodes) Explode(path []interface{}) {
+	f.exploded = append(f.exploded, path)
+}
+
+func TestNodeFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"a":     map[string]interface{}{"b": "x"},
+		"items": []interface{}{"p", "q"},
+	}
+
+	t.Run("without_source", func(t *testing.T) {
+		result, err := New().Execute(".a | line()", data)
+		if err != nil || result != nil {
+			t.Errorf("Expected null, got %v (%v)", result, err)
+		}
+	})
+
+	nodes := &fakeNodes{}
+	engine := New()
+	engine.SetNodes(nodes)
+
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{"line()", 1},
+		{".a | line()", 2},
+		{".a.b | line()", 3},
+		{".a | .b | head_comment()", "[a b]"},
+		{".items[1] | head_comment()", "[items 1]"},
+		{".items[] | head_comment()", []interface{}{"[items 0]", "[items 1]"}},
+		{`.a | select(.b == "x") | line()`, 2},
+		{".items | map(line())", []interface{}{nil, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			result, err := engine.Execute(tt.query, data)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, result)
+			}
+		})
+	}
+
+	result, err := engine.Execute(".a | explode()", data)
+	if err != nil || !reflect.DeepEqual(result, data["a"]) {
+		t.Fatalf("Expected .a back, got %v (%v)", result, err)
+	}
+	if !reflect.DeepEqual(nodes.exploded, [][]interface{}{{"a"}}) {
+		t.Errorf("Expected explode at [a], got %v", nodes.exploded)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	t.Setenv("TQ_TEST_VAR", "from env")
+
+	engine := New()
+	engine.SetVariable("name", "Bob")
+	engine.SetVariable("list", []interface{}{float64(1), float64(2)})
+	engine.SetVariable("ARGS", map[string]interface{}{
+		"positional": []interface{}{"a"},
+		"named":      map[string]interface{}{"name": "Bob"},
+	})
+	data := []interface{}{
+		map[string]interface{}{"name": "Alice"},
+		map[string]interface{}{"name": "Bob"},
+	}
+
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{"$name", "Bob"},
+		{"$list[1]", float64(2)},
+		{"$ARGS.named.name", "Bob"},
+		{"$ARGS.positional | length()", 1},
+		{"$ENV.TQ_TEST_VAR", "from env"},
+		{"{who: $name}", map[string]interface{}{"who": "Bob"}},
+		{".[] | select(.name == $name)", []interface{}{map[string]interface{}{"name": "Bob"}}},
+		{".[0].name = $name | .[0].name", "Bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			result, err := engine.Execute(tt.query, data)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, result)
+			}
+		})
+	}
+
+	if _, err := engine.Execute("$missing", nil); err == nil {
+		t.Error("Expected error for an undefined variable")
+	}
+}
+
+func TestHalt(t *testing.T) {
+	engine := New()
+	tests := []struct {
+		query string
+		data  interface{}
+		want  HaltError
+	}{
+		{"halt()", nil, HaltError{}},
+		{"halt_error()", "stopped", HaltError{Code: 5, Message: "stopped"}},
+		{".a | halt_error(1)", map[string]interface{}{"a": map[string]interface{}{"x": 1.0}}, HaltError{Code: 1, Message: "{\"x\":1}\n"}},
+		{"map(halt_error(2))", []interface{}{"first"}, HaltError{Code: 2, Message: "first"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := engine.Execute(tt.query, tt.data)
+			var halt *HaltError
+			if !errors.As(err, &halt) {
+				t.Fatalf("Expected a HaltError, got %v", err)
+			}
+			if *halt != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, *halt)
+			}
+		})
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	engine := New()
+	for _, query := range []string{"nosuch()", "map(nosuch())", "length(", "$undefined", "if . then 1 end"} {
+		_, err := engine.Execute(query, []interface{}{1.0})
+		var compile *CompileError
+		if !errors.As(err, &compile) {
+			t.Errorf("%s: expected a CompileError, got %v", query, err)
+		}
+	}
+
+	// Errors in the data are not compile errors
+	_, err := engine.Execute("split(\",\")", 1.0)
+	var compile *CompileError
+	if err == nil || errors.As(err, &compile) {
+		t.Errorf("Expected a runtime error, got %v", err)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	engine := New()
+	data := []interface{}{float64(1), nil, float64(3)}
+
+	for _, query := range []string{"select(. == 2)", "empty()", ".[0] | select(. > 1)", "{a: select(. == 2)}"} {
+		if _, err := engine.Execute(query, data); !errors.Is(err, ErrEmpty) {
+			t.Errorf("%s: expected ErrEmpty, got %v", query, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{".[] | select(. != 1)", []interface{}{nil, float64(3)}},
+		{"map(select(. != 1))", []interface{}{nil, float64(3)}},
+		{"[select(. == 2)]", []interface{}{}},
+		{"empty() // 5", float64(5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			result, err := engine.Execute(tt.query, data)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("Expected %#v, got %#v", tt.want, result)
+			}
+		})
+	}
+}
+
+func TestExecuteArrayIndexOutOfRange(t *testing.T) {
+	engine := New()
+	data := []interface{}{float64(1), float64(2)}
+	tests := []struct {
+		query    string
+		expected interface{}
+	}{
+		{".[1]", float64(2)},
+		{".[-1]", float64(2)},
+		{".[2]", nil},
+		{".[-3]", nil},
+		{".[5] // 0", float64(0)},
+	}
+	for _, tt := range tests {
+		result, err := engine.Execute(tt.query, data)
+		if err != nil {
+			t.Fatalf("%s: Execute failed: %v", tt.query, err)
+		}
+		if result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.query, tt.expected, result)
+		}
+	}
+}
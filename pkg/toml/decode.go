@@ -0,0 +1,736 @@
+package toml
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	decimalPattern  = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$`)
+	hexPattern      = regexp.MustCompile(`^0x[0-9A-Fa-f](_?[0-9A-Fa-f])*$`)
+	octalPattern    = regexp.MustCompile(`^0o[0-7](_?[0-7])*$`)
+	binaryPattern   = regexp.MustCompile(`^0b[01](_?[01])*$`)
+	floatPattern    = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+	datePattern     = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+	timePattern     = regexp.MustCompile(`^[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?$`)
+	datetimePattern = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2})[Tt ]([0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?)([Zz]|[+-][0-9]{2}:[0-9]{2})?$`)
+)
+
+// tableKind records how a table was created, which decides how it may be
+// extended later in the document
+type tableKind int
+
+const (
+	tableImplicit tableKind = iota // Parent of a [header], not yet defined itself
+	tableHeader                    // Defined by a [header] or [[header]]
+	tableDotted                    // Created by a dotted key (a.b = 1)
+)
+
+// table is a table being built. Inline tables and static arrays are stored
+// as plain values, so they can never be extended.
+type table struct {
+	kind   tableKind
+	values map[string]interface{} // *table, *tableArray or decoded values
+}
+
+// tableArray is an array of tables built by [[header]]s
+type tableArray struct {
+	tables []*table
+}
+
+// Decode parses a TOML document
+func Decode(input string) (interface{}, error) {
+	return DecodeWithOptions(input, DefaultOptions())
+}
+
+// DecodeWithOptions parses a TOML document using opts. The result is always
+// an object.
+func DecodeWithOptions(input string, opts Options) (interface{}, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	p := &parser{src: input, opts: opts, root: newTable(tableHeader)}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.root.value(), nil
+}
+
+func newTable(kind tableKind) *table {
+	return &table{kind: kind, values: make(map[string]interface{})}
+}
+
+// value converts the table into plain maps and slices
+func (t *table) value() map[string]interface{} {
+	result := make(map[string]interface{}, len(t.values))
+	for k, v := range t.values {
+		switch val := v.(type) {
+		case *table:
+			result[k] = val.value()
+		case *tableArray:
+			items := make([]interface{}, len(val.tables))
+			for i, item := range val.tables {
+				items[i] = item.value()
+			}
+			result[k] = items
+		default:
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// parser is a recursive descent parser over the whole document
+type parser struct {
+	src     string
+	pos     int
+	opts    Options
+	root    *table
+	current *table // Table that key/value pairs are added to
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.src[:pos], "\n")
+	start := strings.LastIndex(p.src[:pos], "\n") + 1
+	col := 1 + utf8.RuneCountInString(p.src[start:pos])
+	return &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) lookingAt(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+func (p *parser) expect(c byte, what string) error {
+	if p.peek() != c {
+		return p.errorf(p.pos, "expected %s", what)
+	}
+	p.pos++
+	return nil
+}
+
+// skipSpace skips spaces and tabs
+func (p *parser) skipSpace() {
+	for !p.eof() && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipComment skips a comment up to, but not including, the newline
+func (p *parser) skipComment() error {
+	if p.peek() != '#' {
+		return nil
+	}
+	for !p.eof() && p.src[p.pos] != '\n' {
+		c := p.src[p.pos]
+		if (c < 0x20 && c != '\t' && !(c == '\r' && p.lookingAt("\r\n"))) || c == 0x7f {
+			return p.errorf(p.pos, "control character in comment")
+		}
+		p.pos++
+	}
+	return nil
+}
+
+// newline consumes one newline, reporting whether there was one
+func (p *parser) newline() bool {
+	switch {
+	case p.lookingAt("\n"):
+		p.pos++
+	case p.lookingAt("\r\n"):
+		p.pos += 2
+	default:
+		return false
+	}
+	return true
+}
+
+// skipBlank skips whitespace, comments and newlines
+func (p *parser) skipBlank() error {
+	for {
+		p.skipSpace()
+		if err := p.skipComment(); err != nil {
+			return err
+		}
+		if !p.newline() {
+			return nil
+		}
+	}
+}
+
+// endLine requires the rest of the line to be blank or a comment
+func (p *parser) endLine() error {
+	p.skipSpace()
+	if err := p.skipComment(); err != nil {
+		return err
+	}
+	if !p.eof() && !p.newline() {
+		return p.errorf(p.pos, "expected end of line, found %q", p.peek())
+	}
+	return nil
+}
+
+func (p *parser) parse() error {
+	p.current = p.root
+	for {
+		if err := p.skipBlank(); err != nil {
+			return err
+		}
+		if p.eof() {
+			return nil
+		}
+
+		var err error
+		switch {
+		case p.lookingAt("[["):
+			err = p.arrayHeader()
+		case p.peek() == '[':
+			err = p.header()
+		default:
+			err = p.keyValue(p.current)
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.endLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// keyPath parses a possibly dotted key, returning its parts and the
+// position of each
+func (p *parser) keyPath() ([]string, []int, error) {
+	var keys []string
+	var positions []int
+	for {
+		p.skipSpace()
+		start := p.pos
+		var key string
+		var err error
+		switch p.peek() {
+		case '"':
+			if p.lookingAt(`"""`) {
+				return nil, nil, p.errorf(p.pos, "multi-line strings cannot be keys")
+			}
+			key, err = p.basicString()
+		case '\'':
+			if p.lookingAt("'''") {
+				return nil, nil, p.errorf(p.pos, "multi-line strings cannot be keys")
+			}
+			key, err = p.literalString()
+		default:
+			for !p.eof() && isBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, nil, p.errorf(p.pos, "expected a key")
+			}
+			key = p.src[start:p.pos]
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		positions = append(positions, start)
+
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, positions, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// header parses [a.b] and makes it the current table
+func (p *parser) header() error {
+	p.pos++
+	keys, positions, err := p.keyPath()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(']', "']' to close the table header"); err != nil {
+		return err
+	}
+
+	parent, err := p.descend(keys[:len(keys)-1], positions)
+	if err != nil {
+		return err
+	}
+	last, pos := keys[len(keys)-1], positions[len(keys)-1]
+	switch existing := parent.values[last].(type) {
+	case nil:
+		t := newTable(tableHeader)
+		parent.values[last] = t
+		p.current = t
+	case *table:
+		if existing.kind != tableImplicit {
+			return p.errorf(pos, "table %s is already defined", strings.Join(keys, "."))
+		}
+		existing.kind = tableHeader
+		p.current = existing
+	default:
+		return p.errorf(pos, "key %s is already defined", strings.Join(keys, "."))
+	}
+	return nil
+}
+
+// arrayHeader parses [[a.b]], appending a new table to the array
+func (p *parser) arrayHeader() error {
+	p.pos += 2
+	keys, positions, err := p.keyPath()
+	if err != nil {
+		return err
+	}
+	if !p.lookingAt("]]") {
+		return p.errorf(p.pos, "expected ']]' to close the array of tables header")
+	}
+	p.pos += 2
+
+	parent, err := p.descend(keys[:len(keys)-1], positions)
+	if err != nil {
+		return err
+	}
+	last, pos := keys[len(keys)-1], positions[len(keys)-1]
+	t := newTable(tableHeader)
+	switch existing := parent.values[last].(type) {
+	case nil:
+		parent.values[last] = &tableArray{tables: []*table{t}}
+	case *tableArray:
+		existing.tables = append(existing.tables, t)
+	default:
+		return p.errorf(pos, "key %s is already defined and is not an array of tables", strings.Join(keys, "."))
+	}
+	p.current = t
+	return nil
+}
+
+// descend walks the parent keys of a header from the root, creating
+// implicit tables and entering the last table of an array of tables
+func (p *parser) descend(keys []string, positions []int) (*table, error) {
+	t := p.root
+	for i, key := range keys {
+		switch next := t.values[key].(type) {
+		case nil:
+			child := newTable(tableImplicit)
+			t.values[key] = child
+			t = child
+		case *table:
+			t = next
+		case *tableArray:
+			t = next.tables[len(next.tables)-1]
+		default:
+			return nil, p.errorf(positions[i], "key %s is not a table", strings.Join(keys[:i+1], "."))
+		}
+	}
+	return t, nil
+}
+
+// keyValue parses key = value into t. Dotted keys create tables that only
+// dotted keys may extend.
+func (p *parser) keyValue(t *table) error {
+	keys, positions, err := p.keyPath()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('=', "'=' after key"); err != nil {
+		return err
+	}
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys[:len(keys)-1] {
+		switch next := t.values[key].(type) {
+		case nil:
+			child := newTable(tableDotted)
+			t.values[key] = child
+			t = child
+		case *table:
+			if next.kind != tableDotted {
+				return p.errorf(positions[i], "cannot add to table %s with a dotted key", strings.Join(keys[:i+1], "."))
+			}
+			t = next
+		default:
+			return p.errorf(positions[i], "key %s is already defined", strings.Join(keys[:i+1], "."))
+		}
+	}
+
+	last := keys[len(keys)-1]
+	if _, exists := t.values[last]; exists {
+		return p.errorf(positions[len(keys)-1], "key %s is already defined", strings.Join(keys, "."))
+	}
+	t.values[last] = value
+	return nil
+}
+
+// value parses any value
+func (p *parser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		if p.lookingAt(`"""`) {
+			return p.multilineBasicString()
+		}
+		return p.basicString()
+	case c == '\'':
+		if p.lookingAt("'''") {
+			return p.multilineLiteralString()
+		}
+		return p.literalString()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case p.eof() || c == '\n' || c == '\r' || c == '#':
+		return nil, p.errorf(p.pos, "expected a value")
+	}
+	return p.scalar()
+}
+
+// scalar parses a boolean, number or datetime
+func (p *parser) scalar() (interface{}, error) {
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	// A space may separate the date and time of a datetime
+	if datePattern.MatchString(p.src[start:p.pos]) && p.pos+3 < len(p.src) &&
+		p.src[p.pos] == ' ' && isDigit(p.src[p.pos+1]) && isDigit(p.src[p.pos+2]) && p.src[p.pos+3] == ':' {
+		p.pos++
+		for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.src[p.pos])) {
+			p.pos++
+		}
+	}
+	text := p.src[start:p.pos]
+
+	switch {
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case text == "inf" || text == "+inf":
+		return math.Inf(1), nil
+	case text == "-inf":
+		return math.Inf(-1), nil
+	case text == "nan" || text == "+nan" || text == "-nan":
+		return math.NaN(), nil
+	case decimalPattern.MatchString(text):
+		return p.integer(start, text, 10)
+	case hexPattern.MatchString(text):
+		return p.integer(start, text[2:], 16)
+	case octalPattern.MatchString(text):
+		return p.integer(start, text[2:], 8)
+	case binaryPattern.MatchString(text):
+		return p.integer(start, text[2:], 2)
+	case floatPattern.MatchString(text):
+		f, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+		if err != nil {
+			return nil, p.errorf(start, "invalid float %s", text)
+		}
+		return f, nil
+	}
+	return p.datetime(start, text)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *parser) integer(start int, digits string, base int) (interface{}, error) {
+	n, err := strconv.ParseInt(strings.ReplaceAll(digits, "_", ""), base, 64)
+	if err != nil {
+		return nil, p.errorf(start, "integer %s is out of range", p.src[start:p.pos])
+	}
+	return n, nil
+}
+
+// datetime parses the four datetime types, normalizing the separator and
+// zone letters to upper case
+func (p *parser) datetime(start int, text string) (interface{}, error) {
+	var kind, value, layout string
+	if m := datetimePattern.FindStringSubmatch(text); m != nil {
+		kind, layout = TypeDatetimeLocal, "2006-01-02T15:04:05"
+		value = m[1] + "T" + m[2]
+		if zone := strings.ToUpper(m[4]); zone != "" {
+			kind, layout = TypeDatetime, time.RFC3339
+			value += zone
+		}
+	} else if datePattern.MatchString(text) {
+		kind, value, layout = TypeDateLocal, text, "2006-01-02"
+	} else if timePattern.MatchString(text) {
+		kind, value, layout = TypeTimeLocal, text, "15:04:05"
+	} else {
+		return nil, p.errorf(start, "invalid value %s", text)
+	}
+
+	if _, err := time.Parse(layout, value); err != nil {
+		return nil, p.errorf(start, "invalid %s %s", kind, text)
+	}
+	if p.opts.Datetimes == DatetimeTagged {
+		return map[string]interface{}{"type": kind, "value": value}, nil
+	}
+	return value, nil
+}
+
+// array parses [a, b, ...], which may span lines and hold comments
+func (p *parser) array() (interface{}, error) {
+	p.pos++
+	items := make([]interface{}, 0)
+	for {
+		if err := p.skipBlank(); err != nil {
+			return nil, err
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if err := p.skipBlank(); err != nil {
+			return nil, err
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf(p.pos, "expected ',' or ']' in array")
+		}
+	}
+}
+
+// inlineTable parses { a = 1, b.c = 2 } on a single line
+func (p *parser) inlineTable() (interface{}, error) {
+	p.pos++
+	t := newTable(tableDotted)
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.pos++
+		return t.value(), nil
+	}
+	for {
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+			p.skipSpace()
+			if p.peek() == '}' {
+				return nil, p.errorf(p.pos, "trailing comma in inline table")
+			}
+		case '}':
+			p.pos++
+			return t.value(), nil
+		default:
+			return nil, p.errorf(p.pos, "expected ',' or '}' in inline table")
+		}
+	}
+}
+
+// basicString parses "..." with escapes
+func (p *parser) basicString() (string, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' || p.lookingAt("\r\n") {
+			return "", p.errorf(start, "unterminated string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", p.errorf(p.pos, "control character in string")
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// multilineBasicString parses """...""". A newline right after the opening
+// quotes is dropped, and a backslash at the end of a line removes the
+// newline and any whitespace that follows.
+func (p *parser) multilineBasicString() (string, error) {
+	start := p.pos
+	p.pos += 3
+	p.newline()
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf(start, "unterminated multi-line string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case p.lookingAt(`"""`):
+			return p.closeMultiline(&b, '"')
+		case c == '\\':
+			end := p.pos + 1
+			for end < len(p.src) && (p.src[end] == ' ' || p.src[end] == '\t') {
+				end++
+			}
+			if end < len(p.src) && (p.src[end] == '\n' || strings.HasPrefix(p.src[end:], "\r\n")) {
+				p.pos = end
+				for !p.eof() && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+					p.pos++
+				}
+				continue
+			}
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case c == '\n':
+			b.WriteByte(c)
+			p.pos++
+		case p.lookingAt("\r\n"):
+			b.WriteString("\r\n")
+			p.pos += 2
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", p.errorf(p.pos, "control character in string")
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// closeMultiline ends a multi-line string at a run of three to five
+// quotes, the extra ones belonging to the string
+func (p *parser) closeMultiline(b *strings.Builder, quote byte) (string, error) {
+	n := 0
+	for !p.eof() && p.src[p.pos] == quote {
+		n++
+		p.pos++
+	}
+	if n > 5 {
+		return "", p.errorf(p.pos-n, "too many quotes closing multi-line string")
+	}
+	b.WriteString(strings.Repeat(string(quote), n-3))
+	return b.String(), nil
+}
+
+// escape decodes one escape sequence into b
+func (p *parser) escape(b *strings.Builder) error {
+	start := p.pos
+	p.pos++
+	if p.eof() {
+		return p.errorf(start, "unterminated escape sequence")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.src) {
+			return p.errorf(start, "invalid unicode escape")
+		}
+		code, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf(start, "invalid unicode escape %s", p.src[start:p.pos+size])
+		}
+		b.WriteRune(rune(code))
+		p.pos += size
+	default:
+		return p.errorf(start, "invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+// literalString parses '...' without escapes
+func (p *parser) literalString() (string, error) {
+	start := p.pos
+	p.pos++
+	for {
+		if p.eof() || p.peek() == '\n' || p.lookingAt("\r\n") {
+			return "", p.errorf(start, "unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '\'' {
+			p.pos++
+			return p.src[start+1 : p.pos-1], nil
+		}
+		if c < 0x20 && c != '\t' || c == 0x7f {
+			return "", p.errorf(p.pos, "control character in string")
+		}
+		p.pos++
+	}
+}
+
+// multilineLiteralString parses ”'...”' without escapes
+func (p *parser) multilineLiteralString() (string, error) {
+	start := p.pos
+	p.pos += 3
+	p.newline()
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf(start, "unterminated multi-line string")
+		}
+		c := p.src[p.pos]
+		switch {
+		case p.lookingAt("'''"):
+			return p.closeMultiline(&b, '\'')
+		case c == '\n':
+			b.WriteByte(c)
+			p.pos++
+		case p.lookingAt("\r\n"):
+			b.WriteString("\r\n")
+			p.pos += 2
+		case c < 0x20 && c != '\t' || c == 0x7f:
+			return "", p.errorf(p.pos, "control character in string")
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
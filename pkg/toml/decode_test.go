@@ -0,0 +1,149 @@
+package toml
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	input := `# Service config
+title = "Say \"hi\"\u00e9"
+"quoted key" = 'C:\path'
+site."example.com" = true
+hex = 0xDEAD_beef
+big = 1_000
+flt = -6.5e-3
+multi = """
+one \
+   two"""
+lit = '''
+raw \n ''quoted'''''
+point = { x = 1, y.z = 2 }
+mixed = [
+  1, "two", # comment
+  { three = 3 },
+  [4],
+]
+
+[server]
+host = "localhost"
+
+[server.tls]
+enabled = true
+
+[[products]]
+name = "Hammer"
+[products.dims]
+w = 1
+
+[[products]]
+name = "Nail"
+`
+
+	expected := map[string]interface{}{
+		"title":      "Say \"hi\"é",
+		"quoted key": `C:\path`,
+		"site":       map[string]interface{}{"example.com": true},
+		"hex":        int64(0xDEADBEEF),
+		"big":        int64(1000),
+		"flt":        -6.5e-3,
+		"multi":      "one two",
+		"lit":        `raw \n ''quoted''`,
+		"point":      map[string]interface{}{"x": int64(1), "y": map[string]interface{}{"z": int64(2)}},
+		"mixed":      []interface{}{int64(1), "two", map[string]interface{}{"three": int64(3)}, []interface{}{int64(4)}},
+		"server": map[string]interface{}{
+			"host": "localhost",
+			"tls":  map[string]interface{}{"enabled": true},
+		},
+		"products": []interface{}{
+			map[string]interface{}{"name": "Hammer", "dims": map[string]interface{}{"w": int64(1)}},
+			map[string]interface{}{"name": "Nail"},
+		},
+	}
+
+	result, err := Decode(input)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecodeSpecialFloats(t *testing.T) {
+	result, err := Decode("a = inf\nb = -inf\nc = nan\n")
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	obj := result.(map[string]interface{})
+	if !math.IsInf(obj["a"].(float64), 1) || !math.IsInf(obj["b"].(float64), -1) || !math.IsNaN(obj["c"].(float64)) {
+		t.Errorf("Unexpected special floats: %v", obj)
+	}
+}
+
+func TestDecodeDatetimes(t *testing.T) {
+	input := "odt = 1979-05-27 07:32:00z\nldt = 1979-05-27T07:32:00.5\nld = 1979-05-27\nlt = 07:32:00\n"
+
+	result, err := Decode(input)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"odt": "1979-05-27T07:32:00Z",
+		"ldt": "1979-05-27T07:32:00.5",
+		"ld":  "1979-05-27",
+		"lt":  "07:32:00",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+
+	result, err = DecodeWithOptions(input, Options{Datetimes: DatetimeTagged})
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	tagged := map[string]interface{}{"type": TypeDatetime, "value": "1979-05-27T07:32:00Z"}
+	if got := result.(map[string]interface{})["odt"]; !reflect.DeepEqual(got, tagged) {
+		t.Errorf("Expected %v, got %v", tagged, got)
+	}
+	if got := result.(map[string]interface{})["lt"]; !reflect.DeepEqual(got, map[string]interface{}{"type": TypeTimeLocal, "value": "07:32:00"}) {
+		t.Errorf("Unexpected local time %v", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"duplicate key", "a = 1\na = 2", 2},
+		{"table defined twice", "[a]\n[b]\n[a]", 3},
+		{"table over dotted key", "a.b = 1\n[a]", 2},
+		{"dotted key into header table", "[a.b.c]\n[a]\nb.d = 1", 3},
+		{"extend inline table", "a = { b = 1 }\n[a.c]", 2},
+		{"array of tables over array", "a = []\n[[a]]", 2},
+		{"missing value", "a =", 1},
+		{"leading zero", "a = 01", 1},
+		{"bad datetime", "a = 1979-13-27", 1},
+		{"unterminated string", "a = \"abc\nb = 1", 1},
+		{"invalid escape", `a = "\x"`, 1},
+		{"trailing content", "a = 1 2", 1},
+		{"newline in inline table", "a = { b = 1,\nc = 2 }", 1},
+		{"integer overflow", "a = 9223372036854775808", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.input)
+			serr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("Expected *SyntaxError, got %v", err)
+			}
+			if serr.Line != tt.line {
+				t.Errorf("Expected error on line %d, got %v", tt.line, serr)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package toml
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encode writes v, which must be an object, as a TOML document. Keys are
+// sorted, with plain values first, then [tables], then [[arrays of
+// tables]]. Null object fields are left out, since TOML has no null.
+// Integral floats are written as integers so that numbers read from JSON
+// keep their shape.
+func Encode(v interface{}) (string, error) {
+	root, ok := v.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("TOML output requires an object, got %s", typeName(v))
+	}
+	e := &encoder{}
+	if err := e.table(nil, root, false); err != nil {
+		return "", err
+	}
+	return e.b.String(), nil
+}
+
+type encoder struct {
+	b strings.Builder
+}
+
+// table writes the fields of obj. Its [header] is written first when the
+// table has plain values, is empty, or is an element of an array of tables
+// (written); a table holding only subtables needs no header of its own.
+func (e *encoder) table(path []string, obj map[string]interface{}, written bool) error {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var plain, tables, arrays []string
+	for _, k := range keys {
+		switch val := obj[k].(type) {
+		case nil:
+		case map[string]interface{}:
+			if isDatetime(val) {
+				plain = append(plain, k)
+			} else {
+				tables = append(tables, k)
+			}
+		case []interface{}:
+			if isTableArray(val) {
+				arrays = append(arrays, k)
+			} else {
+				plain = append(plain, k)
+			}
+		default:
+			plain = append(plain, k)
+		}
+	}
+
+	if len(path) > 0 && !written && (len(plain) > 0 || len(keys) == 0) {
+		e.separate()
+		e.b.WriteString("[" + keyPath(path) + "]\n")
+	}
+	for _, k := range plain {
+		value, err := e.value(obj[k], append(path, k))
+		if err != nil {
+			return err
+		}
+		e.b.WriteString(key(k) + " = " + value + "\n")
+	}
+	for _, k := range tables {
+		if err := e.table(append(path[:len(path):len(path)], k), obj[k].(map[string]interface{}), false); err != nil {
+			return err
+		}
+	}
+	for _, k := range arrays {
+		sub := append(path[:len(path):len(path)], k)
+		for _, item := range obj[k].([]interface{}) {
+			e.separate()
+			e.b.WriteString("[[" + keyPath(sub) + "]]\n")
+			if err := e.table(sub, item.(map[string]interface{}), true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// separate puts a blank line before a header that follows other content
+func (e *encoder) separate() {
+	if e.b.Len() > 0 {
+		e.b.WriteString("\n")
+	}
+}
+
+// value formats an inline value
+func (e *encoder) value(v interface{}, path []string) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return quote(val), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return formatFloat(val), nil
+	case time.Time:
+		return val.Format(time.RFC3339Nano), nil
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			if item == nil {
+				return "", fmt.Errorf("TOML cannot represent null in array %s", keyPath(path))
+			}
+			s, err := e.value(item, path)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		if isDatetime(val) {
+			return val["value"].(string), nil
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			if val[k] != nil {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			return "{}", nil
+		}
+		sort.Strings(keys)
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			s, err := e.value(val[k], append(path, k))
+			if err != nil {
+				return "", err
+			}
+			fields[i] = key(k) + " = " + s
+		}
+		return "{ " + strings.Join(fields, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("TOML cannot represent %T at %s", v, keyPath(path))
+}
+
+// isTableArray reports whether arr is a non-empty array of plain objects,
+// written as [[header]] sections
+func isTableArray(arr []interface{}) bool {
+	if len(arr) == 0 {
+		return false
+	}
+	for _, item := range arr {
+		obj, ok := item.(map[string]interface{})
+		if !ok || isDatetime(obj) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDatetime reports whether obj is a tagged datetime
+func isDatetime(obj map[string]interface{}) bool {
+	if len(obj) != 2 {
+		return false
+	}
+	kind, _ := obj["type"].(string)
+	value, ok := obj["value"].(string)
+	if !ok {
+		return false
+	}
+	switch kind {
+	case TypeDatetime, TypeDatetimeLocal:
+		m := datetimePattern.FindStringSubmatch(value)
+		return m != nil && (m[4] != "") == (kind == TypeDatetime)
+	case TypeDateLocal:
+		return datePattern.MatchString(value)
+	case TypeTimeLocal:
+		return timePattern.MatchString(value)
+	}
+	return false
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	// A float keeps a fraction or exponent, so 72.0 reads back as a float
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// key writes a key bare when it can be, and quoted otherwise
+func key(k string) string {
+	if k == "" {
+		return `""`
+	}
+	for i := 0; i < len(k); i++ {
+		if !isBareKeyChar(k[i]) {
+			return quote(k)
+		}
+	}
+	return k
+}
+
+func keyPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, k := range path {
+		parts[i] = key(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quote writes s as a basic string
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// typeName names the JSON type of v for error messages
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "number"
+	}
+}
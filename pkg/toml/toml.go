@@ -0,0 +1,63 @@
+// Package toml reads and writes TOML v1.0 documents as the generic values
+// used across tq: map[string]interface{}, []interface{}, string, int64,
+// float64 and bool.
+//
+// TOML has four datetime types that have no JSON equivalent. By default
+// they decode to their RFC 3339 text; with DatetimeTagged they decode to
+// {"type": ..., "value": ...} objects, using the type names of the TOML
+// test suite, so that they keep their type through a conversion:
+//
+//	{"type": "datetime", "value": "1979-05-27T07:32:00Z"}
+//	{"type": "datetime-local", "value": "1979-05-27T07:32:00"}
+//	{"type": "date-local", "value": "1979-05-27"}
+//	{"type": "time-local", "value": "07:32:00"}
+//
+// The encoder writes tagged objects and time.Time values back as bare
+// datetimes.
+package toml
+
+import "fmt"
+
+// Datetime decoding policies
+const (
+	DatetimeString = "string" // Datetimes decode to their text (default)
+	DatetimeTagged = "tagged" // Datetimes decode to {"type", "value"} objects
+)
+
+// Datetime type names used by the tagged form
+const (
+	TypeDatetime      = "datetime"
+	TypeDatetimeLocal = "datetime-local"
+	TypeDateLocal     = "date-local"
+	TypeTimeLocal     = "time-local"
+)
+
+// Options for TOML decoding
+type Options struct {
+	Datetimes string // DatetimeString or DatetimeTagged
+}
+
+// DefaultOptions returns the default decoding options
+func DefaultOptions() Options {
+	return Options{Datetimes: DatetimeString}
+}
+
+// Validate reports unsupported option values
+func (o Options) Validate() error {
+	switch o.Datetimes {
+	case "", DatetimeString, DatetimeTagged:
+		return nil
+	}
+	return fmt.Errorf("unsupported datetime policy %q: use %q or %q", o.Datetimes, DatetimeString, DatetimeTagged)
+}
+
+// SyntaxError describes malformed TOML input. Line and Column are 1-based.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
@@ -0,0 +1,115 @@
+package toml
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	input := map[string]interface{}{
+		"title":   "tq \"config\"",
+		"port":    int64(8080),
+		"ratio":   0.5,
+		"weight":  float64(72),
+		"huge":    1e20,
+		"skip":    nil,
+		"tags":    []interface{}{"a", "b"},
+		"my key":  true,
+		"point":   []interface{}{map[string]interface{}{"x": 1}, 2},
+		"created": time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC),
+		"birthday": map[string]interface{}{
+			"type": TypeDateLocal, "value": "1979-05-27",
+		},
+		"server": map[string]interface{}{
+			"tls": map[string]interface{}{"enabled": true},
+		},
+		"empty": map[string]interface{}{},
+		"products": []interface{}{
+			map[string]interface{}{"name": "Hammer", "dims": map[string]interface{}{"w": 1}},
+			map[string]interface{}{"name": "Nail"},
+		},
+	}
+
+	expected := `birthday = 1979-05-27
+created = 1979-05-27T07:32:00Z
+huge = 1e+20
+"my key" = true
+point = [{ x = 1 }, 2]
+port = 8080
+ratio = 0.5
+tags = ["a", "b"]
+title = "tq \"config\""
+weight = 72.0
+
+[empty]
+
+[server.tls]
+enabled = true
+
+[[products]]
+name = "Hammer"
+
+[products.dims]
+w = 1
+
+[[products]]
+name = "Nail"
+`
+
+	result, err := Encode(input)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode([]interface{}{1}); err == nil {
+		t.Error("Expected error encoding an array document")
+	}
+	if _, err := Encode(map[string]interface{}{"a": []interface{}{nil}}); err == nil {
+		t.Error("Expected error encoding null in an array")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	input := `ld = 1979-05-27
+name = "multi\nline"
+odt = 1979-05-27T07:32:00-08:00
+ratio = 1.5e+100
+temp = 72.0
+
+[[fruit]]
+name = "apple"
+
+[fruit.physical]
+color = "red"
+
+[[fruit.variety]]
+name = "red delicious"
+
+[[fruit]]
+name = "banana"
+`
+
+	opts := Options{Datetimes: DatetimeTagged}
+	decoded, err := DecodeWithOptions(input, opts)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	encoded, err := Encode(decoded)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if encoded != input {
+		t.Errorf("Round trip changed the document:\n%s", encoded)
+	}
+
+	again, err := DecodeWithOptions(encoded, opts)
+	if err != nil || !reflect.DeepEqual(again, decoded) {
+		t.Errorf("Expected %v, got %v (%v)", decoded, again, err)
+	}
+}
@@ -2,7 +2,6 @@ package toon
 
 import (
 	"bufio"
-	"encoding/csv"
 	"fmt"
 	"io"
 	"strconv"
@@ -11,215 +10,662 @@ import (
 
 // Decode parses TOON format into a Go value
 func Decode(input string) (interface{}, error) {
+	return DecodeWithOptions(input, DefaultOptions())
+}
+
+// DecodeWithOptions parses TOON format into a Go value, honoring the
+// decoding fields of opts (ExpandPaths, PathCollision, SparseTabular)
+func DecodeWithOptions(input string, opts Options) (interface{}, error) {
 	if input == "" {
 		return nil, fmt.Errorf("empty input")
 	}
-	lines := strings.Split(input, "\n")
-	return parseTOON(lines, 0)
+	return DecodeReaderWithOptions(bufio.NewReader(strings.NewReader(input)), opts)
 }
 
-func parseTOON(lines []string, startIdx int) (interface{}, error) {
-	if startIdx >= len(lines) {
-		return nil, nil
+// DecodeReader reads TOON from a reader
+func DecodeReader(r *bufio.Reader) (interface{}, error) {
+	return DecodeReaderWithOptions(r, DefaultOptions())
+}
+
+// DecodeReaderWithOptions reads TOON from a reader using opts. Lines are
+// consumed as they are parsed; only the resulting value is held in memory.
+func DecodeReaderWithOptions(r *bufio.Reader, opts Options) (interface{}, error) {
+	d := NewDecoder(r)
+	d.SetOptions(opts)
+
+	var result interface{}
+	if err := d.Decode(&result); err != nil {
+		return nil, err
+	}
+	// The document must be the whole input
+	if err := d.end(); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	result := make(map[string]interface{})
+// end checks that the document just read is the whole input, with no
+// content or further documents after it
+func (d *Decoder) end() error {
+	if _, err := d.Token(); err != io.EOF {
+		return err
+	}
+	if l := d.separator; l != nil {
+		return l.errorf(l.col, CodeTrailingContent, "unexpected document separator: the input holds several documents")
+	}
+	return nil
+}
+
+// sourceLine is one non-blank source line
+type sourceLine struct {
+	idx     int    // 0-based line number
+	text    string // The full line, without its newline
+	indent  int
+	content string // The line without indentation or trailing blanks
+	col     int    // 1-based column where content starts
+}
+
+// errorf builds a SyntaxError at column col (1-based) of l
+func (l *sourceLine) errorf(col int, code ErrorCode, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Line:   l.idx + 1,
+		Column: col,
+		Code:   code,
+		Msg:    fmt.Sprintf(format, args...),
+		Source: l.text,
+	}
+}
+
+func (l *sourceLine) quoteError(col int, err *quoteError) error {
+	return l.errorf(col+err.off, err.code, "%s", err.msg)
+}
+
+// peek returns the next non-blank line without consuming it, or nil at the
+// end of the input or of the document: a DocumentSeparator line ends it
+func (d *Decoder) peek() (*sourceLine, error) {
+	for d.next == nil && !d.eof && d.separator == nil {
+		text, err := d.r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			d.eof = true
+			if text == "" {
+				break
+			}
+		}
+		idx := d.lines
+		d.lines++
 
-	for i := startIdx; i < len(lines); i++ {
-		line := lines[i]
-		if strings.TrimSpace(line) == "" {
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		if strings.TrimSpace(text) == "" {
 			continue
 		}
+		content := strings.TrimLeft(text, " \t")
+		l := &sourceLine{
+			idx:     idx,
+			text:    text,
+			indent:  countIndent(text),
+			content: strings.TrimRight(content, " \t"),
+			col:     len(text) - len(content) + 1,
+		}
+		if l.col == 1 && l.content == DocumentSeparator {
+			d.separator = l
+			break
+		}
+		d.next = l
+	}
+	return d.next, nil
+}
 
-		// Check indentation level
-		indent := countIndent(line)
-
-		// Parse key-value or array
-		if strings.Contains(line, ":") {
-			parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-
-			// Check if it's an array
-			if strings.HasPrefix(key, "[") || strings.Contains(key, "[") {
-				// Parse array header
-				arr, err := parseArrayHeader(key, value, lines, i+1)
-				if err != nil {
-					return nil, err
-				}
-
-				// Extract actual key if it has one
-				actualKey := key
-				if idx := strings.Index(key, "["); idx > 0 {
-					actualKey = key[:idx]
-				}
-
-				result[actualKey] = arr
-				continue
-			}
+// frameKind identifies the block a frame is reading
+type frameKind int
 
-			// Simple value
-			if value != "" {
-				parsed, err := parseValue(value)
-				if err != nil {
-					return nil, err
-				}
-				result[key] = parsed
-			} else {
-				// Nested object - parse following indented lines
-				nested := make(map[string]interface{})
-				j := i + 1
-				for j < len(lines) && countIndent(lines[j]) > indent {
-					j++
-				}
-				if j > i+1 {
-					nestedResult, err := parseTOON(lines[i+1:j], 0)
-					if err != nil {
-						return nil, fmt.Errorf("failed to parse nested object at key '%s': %w", key, err)
-					}
-					// Validate type assertion
-					nestedMap, ok := nestedResult.(map[string]interface{})
-					if !ok {
-						return nil, fmt.Errorf("expected object for key '%s', got %T", key, nestedResult)
-					}
-					nested = nestedMap
-				}
-				result[key] = nested
-			}
+const (
+	frameObject frameKind = iota
+	frameTable
+	frameList
+)
+
+// frame tracks one open block. Entries of the block share an indentation
+// that is fixed by its first entry and must be deeper than parent.
+type frame struct {
+	kind   frameKind
+	indent int // -1 until the first entry is read
+	parent int
+	header arrayHeader
+	count  int
+	line   *sourceLine // The array header line, for length errors
+	col    int         // Column of the array header
+}
+
+// ends reports whether l falls outside the block
+func (f *frame) ends(l *sourceLine) bool {
+	if l == nil {
+		return true
+	}
+	if f.indent < 0 {
+		return l.indent <= f.parent
+	}
+	return l.indent < f.indent
+}
+
+func (d *Decoder) emit(tok Token) {
+	d.queue = append(d.queue, tok)
+}
+
+func (d *Decoder) push(f *frame) {
+	d.stack = append(d.stack, f)
+}
+
+func (d *Decoder) pop() {
+	d.stack = d.stack[:len(d.stack)-1]
+}
+
+// step reads at most one line, queueing the tokens it produces. It returns
+// io.EOF once the document is complete.
+func (d *Decoder) step() error {
+	if !d.started {
+		d.started = true
+		return d.startDocument()
+	}
+
+	if len(d.stack) == 0 {
+		l, err := d.peek()
+		if err != nil {
+			return err
 		}
+		if l != nil {
+			return l.errorf(l.col, CodeTrailingContent, "unexpected content after end of document")
+		}
+		return io.EOF
 	}
 
-	return result, nil
+	switch f := d.stack[len(d.stack)-1]; f.kind {
+	case frameTable:
+		return d.stepRow(f)
+	case frameList:
+		return d.stepListItem(f)
+	default:
+		return d.stepField(f)
+	}
 }
 
-func parseArrayHeader(key, value string, lines []string, nextIdx int) (interface{}, error) {
-	// Extract array info: [length]{fields} or [length]
-	var length int
-	var fields []string
+func (d *Decoder) startDocument() error {
+	l, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		d.emit(Token{Kind: ObjectStart})
+		d.emit(Token{Kind: ObjectEnd})
+		return nil
+	}
 
-	// Parse [N]
-	start := strings.Index(key, "[")
-	end := strings.Index(key, "]")
-	if start == -1 || end == -1 {
-		return nil, fmt.Errorf("invalid array syntax in '%s'", key)
+	if strings.HasPrefix(l.content, "[") {
+		// Root array: rows and items may start at any indentation
+		d.next = nil
+		return d.field(l, l.content, l.col, -1, false)
+	}
+	if _, _, _, isField := splitField(l.content); !isField {
+		// Root primitive
+		d.next = nil
+		value, err := parsePrimitive(l, l.col, l.content)
+		if err != nil {
+			return err
+		}
+		d.emit(Token{Kind: Primitive, Value: value, Line: l.idx + 1, Column: l.col})
+		return nil
 	}
 
-	lengthStr := key[start+1 : end]
-	if lengthStr == "" {
-		return nil, fmt.Errorf("empty array length in '%s'", key)
+	d.emit(Token{Kind: ObjectStart, Line: l.idx + 1, Column: l.col})
+	d.push(&frame{kind: frameObject, indent: l.indent, parent: -1})
+	return nil
+}
+
+// stepField reads the next field of an object, or closes the object at the
+// first line indented less than its fields
+func (d *Decoder) stepField(f *frame) error {
+	l, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if f.ends(l) {
+		d.pop()
+		d.emit(Token{Kind: ObjectEnd})
+		return nil
+	}
+	if f.indent < 0 {
+		f.indent = l.indent
+	}
+	if l.indent > f.indent {
+		return l.errorf(l.col, CodeIndentation, "unexpected indentation: expected %d, got %d", f.indent, l.indent)
+	}
+
+	d.next = nil
+	return d.field(l, l.content, l.col, f.indent, true)
+}
+
+// field parses a "key: value" or "key[N]...:" entry that starts at column
+// col of l. Nested content belongs to the field when it is indented deeper
+// than parent. Array fields without a key (root arrays and "- [N]:" list
+// items) pass withKey false.
+func (d *Decoder) field(l *sourceLine, text string, col int, parent int, withKey bool) error {
+	keyPart, value, valueOff, ok := splitField(text)
+	if !ok {
+		return l.errorf(col+len(text), CodeMissingColon, "expected ':' after key %q", text)
 	}
 
-	var err error
-	length, err = strconv.Atoi(lengthStr)
+	key, headerOff, err := parseKey(l, keyPart, col)
 	if err != nil {
-		return nil, fmt.Errorf("invalid array length '%s': %w", lengthStr, err)
+		return err
+	}
+	if withKey {
+		d.emit(Token{Kind: Key, Key: key.name, Quoted: key.quoted, Line: l.idx + 1, Column: col, source: l.text})
 	}
 
-	if length < 0 {
-		return nil, fmt.Errorf("negative array length not allowed: %d", length)
+	// Array header
+	if headerOff >= 0 {
+		h, err := parseHeader(l, keyPart[headerOff:], col+headerOff)
+		if err != nil {
+			return err
+		}
+		return d.array(l, col+headerOff, h, value, col+valueOff, parent)
 	}
 
-	// Check for fields {field1,field2}
-	if strings.Contains(key, "{") {
-		fieldStart := strings.Index(key, "{")
-		fieldEnd := strings.Index(key, "}")
-		if fieldStart == -1 || fieldEnd == -1 {
-			return nil, fmt.Errorf("invalid array field syntax")
+	// Simple value
+	if value != "" {
+		parsed, err := parsePrimitive(l, col+valueOff, value)
+		if err != nil {
+			return err
 		}
-		fieldStr := key[fieldStart+1 : fieldEnd]
-		fields = strings.Split(fieldStr, ",")
-		for i := range fields {
-			fields[i] = strings.TrimSpace(fields[i])
+		d.emit(Token{Kind: Primitive, Value: parsed, Line: l.idx + 1, Column: col + valueOff})
+		return nil
+	}
+
+	// Nested object on the following indented lines
+	d.emit(Token{Kind: ObjectStart, Line: l.idx + 1, Column: col})
+	d.push(&frame{kind: frameObject, indent: -1, parent: parent})
+	return nil
+}
+
+// parseKey decodes the key portion of a field. headerOff is the offset of an
+// array header within keyPart, or -1 if there is none.
+func parseKey(l *sourceLine, keyPart string, col int) (fieldKey, int, error) {
+	if strings.HasPrefix(keyPart, `"`) {
+		name, n, err := unquote(keyPart)
+		if err != nil {
+			return fieldKey{}, 0, l.quoteError(col, err)
+		}
+		key := fieldKey{name: name, quoted: true}
+		switch {
+		case n == len(keyPart):
+			return key, -1, nil
+		case keyPart[n] == '[':
+			return key, n, nil
+		default:
+			return fieldKey{}, 0, l.errorf(col+n, CodeInvalidKey, "unexpected characters after quoted key")
 		}
 	}
 
-	// Parse array content
-	if len(fields) > 0 {
-		// Tabular array
-		return parseTabularArray(length, fields, lines, nextIdx)
+	if i := strings.Index(keyPart, "["); i >= 0 {
+		return fieldKey{name: keyPart[:i]}, i, nil
+	}
+	if keyPart == "" {
+		return fieldKey{}, 0, l.errorf(col, CodeInvalidKey, "missing key before ':'")
 	}
+	return fieldKey{name: keyPart}, -1, nil
+}
 
-	// Primitive or mixed array
-	if value != "" {
-		// Inline primitive array
-		return parsePrimitiveArray(value)
+// arrayHeader is the parsed form of "[N]" or "[N]{field1,field2}"
+type arrayHeader struct {
+	length    int // -1 when the header omits the length
+	fields    []string
+	delimiter string
+}
+
+func parseHeader(l *sourceLine, header string, col int) (arrayHeader, error) {
+	h := arrayHeader{delimiter: DelimiterComma}
+
+	end := strings.Index(header, "]")
+	if end == -1 {
+		return h, l.errorf(col, CodeInvalidHeader, "unclosed '[' in array header")
 	}
 
-	// List format array
-	return parseListArray(length, lines, nextIdx)
+	// A trailing tab or pipe inside the brackets declares the delimiter
+	lengthStr := header[1:end]
+	if n := len(lengthStr); n > 0 && (lengthStr[n-1] == '\t' || lengthStr[n-1] == '|') {
+		h.delimiter = lengthStr[n-1:]
+		lengthStr = lengthStr[:n-1]
+	}
+
+	// "[]" leaves the length unspecified; "[#N]" is an optional length marker
+	h.length = -1
+	if lengthStr != "" {
+		digits := strings.TrimPrefix(lengthStr, "#")
+		length, err := strconv.Atoi(digits)
+		if err != nil {
+			return h, l.errorf(col+1, CodeInvalidHeader, "invalid array length %q", lengthStr)
+		}
+		if length < 0 {
+			return h, l.errorf(col+1, CodeInvalidHeader, "negative array length not allowed: %d", length)
+		}
+		h.length = length
+	}
+
+	rest := header[end+1:]
+	if rest == "" {
+		return h, nil
+	}
+
+	// Check for fields {field1,field2}
+	restCol := col + end + 1
+	if !strings.HasPrefix(rest, "{") {
+		return h, l.errorf(restCol, CodeInvalidHeader, "unexpected characters after array length")
+	}
+	fieldEnd := strings.LastIndex(rest, "}")
+	if fieldEnd == -1 {
+		return h, l.errorf(restCol, CodeInvalidHeader, "unclosed '{' in array header")
+	}
+	if fieldEnd != len(rest)-1 {
+		return h, l.errorf(restCol+fieldEnd+1, CodeInvalidHeader, "unexpected characters after field list")
+	}
+
+	for _, c := range splitDelimited(rest[1:fieldEnd], h.delimiter) {
+		name := c.text
+		if strings.HasPrefix(name, `"`) {
+			unquoted, n, err := unquote(name)
+			if err != nil {
+				return h, l.quoteError(restCol+1+c.off, err)
+			}
+			if n != len(name) {
+				return h, l.errorf(restCol+1+c.off+n, CodeInvalidHeader, "unexpected characters after quoted field name")
+			}
+			name = unquoted
+		} else if name == "" {
+			return h, l.errorf(restCol+1+c.off, CodeInvalidHeader, "empty field name")
+		}
+		h.fields = append(h.fields, name)
+	}
+
+	return h, nil
 }
 
-func parsePrimitiveArray(value string) (interface{}, error) {
-	values := strings.Split(value, ",")
-	result := make([]interface{}, 0, len(values))
+// array emits the header token for an array and either its inline values or
+// a frame that reads its tabular rows or list items
+func (d *Decoder) array(l *sourceLine, headerCol int, h arrayHeader, value string, valueCol int, parent int) error {
+	d.emit(Token{Kind: ArrayHeader, Length: h.length, Fields: h.fields, Line: l.idx + 1, Column: headerCol})
+
+	if len(h.fields) > 0 {
+		if value != "" {
+			return l.errorf(valueCol, CodeInvalidHeader, "unexpected value after tabular array header")
+		}
+		d.push(&frame{kind: frameTable, indent: -1, parent: parent, header: h, line: l, col: headerCol})
+		return nil
+	}
 
-	for _, v := range values {
-		parsed, err := parseValue(strings.TrimSpace(v))
+	// List format array
+	if value == "" {
+		d.push(&frame{kind: frameList, indent: -1, parent: parent, header: h, line: l, col: headerCol})
+		return nil
+	}
+
+	// Inline primitive array
+	cells := splitDelimited(value, h.delimiter)
+	if h.length >= 0 && len(cells) != h.length {
+		return l.errorf(headerCol, CodeLengthMismatch, "array declares %d values, found %d", h.length, len(cells))
+	}
+	for _, c := range cells {
+		parsed, err := parsePrimitive(l, valueCol+c.off, c.text)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		result = append(result, parsed)
+		d.emit(Token{Kind: Primitive, Value: parsed, Line: l.idx + 1, Column: valueCol + c.off})
 	}
+	d.emit(Token{Kind: ArrayEnd})
+	return nil
+}
 
-	return result, nil
+// endArray closes an array frame once its block ends, checking the declared
+// length against the entries read
+func (d *Decoder) endArray(f *frame, noun string) error {
+	if f.header.length >= 0 && f.count != f.header.length {
+		return f.line.errorf(f.col, CodeLengthMismatch, "array declares %d %s, found %d", f.header.length, noun, f.count)
+	}
+	d.pop()
+	d.emit(Token{Kind: ArrayEnd})
+	return nil
 }
 
-func parseTabularArray(length int, fields []string, lines []string, startIdx int) (interface{}, error) {
-	result := make([]interface{}, 0, length)
+// stepRow reads one row of a tabular array
+func (d *Decoder) stepRow(f *frame) error {
+	l, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if f.ends(l) {
+		return d.endArray(f, "rows")
+	}
+	if f.indent < 0 {
+		f.indent = l.indent
+	}
+	if l.indent > f.indent {
+		return l.errorf(l.col, CodeIndentation, "unexpected indentation in tabular row")
+	}
+	d.next = nil
+
+	fields := f.header.fields
+	cells := splitDelimited(l.content, f.header.delimiter)
+	if len(cells) != len(fields) {
+		return l.errorf(l.col, CodeFieldCount, "field count mismatch: expected %d fields, got %d", len(fields), len(cells))
+	}
 
-	for i := 0; i < length && startIdx+i < len(lines); i++ {
-		line := strings.TrimSpace(lines[startIdx+i])
-		if line == "" {
+	obj := make(map[string]interface{}, len(fields))
+	for j, field := range fields {
+		// In sparse tables an empty unquoted cell marks an absent field;
+		// otherwise it is an empty string
+		if cells[j].text == "" && d.opts.SparseTabular {
 			continue
 		}
+		parsed, err := parsePrimitive(l, l.col+cells[j].off, cells[j].text)
+		if err != nil {
+			return err
+		}
+		obj[field] = parsed
+	}
+	f.count++
+	d.emit(Token{Kind: Row, Value: obj, Line: l.idx + 1, Column: l.col})
+	return nil
+}
+
+// stepListItem reads the hyphen line of one list item. An object item
+// carries its first field on the hyphen line and any further fields on the
+// lines below it.
+func (d *Decoder) stepListItem(f *frame) error {
+	l, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if f.ends(l) {
+		return d.endArray(f, "items")
+	}
+	if f.indent < 0 {
+		f.indent = l.indent
+	}
+	if l.indent > f.indent {
+		return l.errorf(l.col, CodeIndentation, "unexpected indentation in list item")
+	}
+	if l.content != "-" && !strings.HasPrefix(l.content, "- ") {
+		return l.errorf(l.col, CodeInvalidListItem, "expected list item starting with \"- \"")
+	}
+	d.next = nil
+	f.count++
 
-		// Use CSV reader to properly handle quoted fields with commas
-		csvReader := csv.NewReader(strings.NewReader(line))
-		csvReader.Comma = ','
-		csvReader.TrimLeadingSpace = true
+	text := strings.TrimLeft(l.content[1:], " ")
+	col := l.col + len(l.content) - len(text)
 
-		values, err := csvReader.Read()
+	if text == "" {
+		d.emit(Token{Kind: ObjectStart, Line: l.idx + 1, Column: l.col})
+		d.emit(Token{Kind: ObjectEnd})
+		return nil
+	}
+
+	if strings.HasPrefix(text, "[") {
+		return d.field(l, text, col, f.indent, false)
+	}
+
+	if _, _, _, isField := splitField(text); !isField {
+		value, err := parsePrimitive(l, col, text)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse tabular row at line %d: %w", startIdx+i, err)
+			return err
 		}
+		d.emit(Token{Kind: Primitive, Value: value, Line: l.idx + 1, Column: col})
+		return nil
+	}
 
-		if len(values) != len(fields) {
-			return nil, fmt.Errorf("field count mismatch at line %d: expected %d fields, got %d", startIdx+i, len(fields), len(values))
-		}
+	// The frame for the remaining fields sits below whatever block the
+	// first field opens, so it resumes once that block ends
+	d.emit(Token{Kind: ObjectStart, Line: l.idx + 1, Column: col})
+	d.push(&frame{kind: frameObject, indent: -1, parent: f.indent})
+	return d.field(l, text, col, f.indent, true)
+}
 
-		obj := make(map[string]interface{})
-		for j, field := range fields {
-			parsed, err := parseValue(strings.TrimSpace(values[j]))
-			if err != nil {
-				return nil, err
+// parsePrimitive parses a single scalar token found at column col of l
+func parsePrimitive(l *sourceLine, col int, s string) (interface{}, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return parseValue(s)
+	}
+
+	str, n, err := unquote(s)
+	if err != nil {
+		return nil, l.quoteError(col, err)
+	}
+	if n != len(s) {
+		return nil, l.errorf(col+n, CodeInvalidValue, "unexpected characters after closing quote")
+	}
+	return str, nil
+}
+
+// splitField splits "key: value" at the first colon outside quotes and
+// array headers. valueOff is the byte offset of value within text.
+func splitField(text string) (key, value string, valueOff int, ok bool) {
+	inQuote := false
+	depth := 0
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inQuote:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
 			}
-			obj[field] = parsed
+		case c == '"':
+			inQuote = true
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ':' && depth == 0:
+			rest := text[i+1:]
+			value = strings.TrimLeft(rest, " \t")
+			return strings.TrimRight(text[:i], " \t"), value, i + 1 + len(rest) - len(value), true
 		}
-
-		result = append(result, obj)
 	}
 
-	return result, nil
+	return "", "", 0, false
 }
 
-func parseListArray(length int, lines []string, startIdx int) (interface{}, error) {
-	result := make([]interface{}, 0, length)
+// cell is one delimited value and its byte offset within the source text
+type cell struct {
+	text string
+	off  int
+}
 
-	for i := 0; i < length && startIdx+i < len(lines); i++ {
-		line := strings.TrimSpace(lines[startIdx+i])
-		if !strings.HasPrefix(line, "-") {
+// splitDelimited splits s on delim, ignoring delimiters inside quoted values
+func splitDelimited(s, delim string) []cell {
+	var cells []cell
+	start := 0
+	inQuote := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inQuote {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
+			}
+			continue
+		}
+		if c == '"' {
+			inQuote = true
 			continue
 		}
+		if strings.HasPrefix(s[i:], delim) {
+			cells = append(cells, makeCell(s[start:i], start, delim))
+			start = i + len(delim)
+			i = start - 1
+		}
+	}
 
-		value := strings.TrimSpace(line[1:])
-		parsed, err := parseValue(value)
-		if err != nil {
-			return nil, err
+	return append(cells, makeCell(s[start:], start, delim))
+}
+
+func makeCell(s string, off int, delim string) cell {
+	cutset := " \t"
+	if delim == "\t" {
+		cutset = " "
+	}
+	trimmed := strings.TrimLeft(s, cutset)
+	return cell{
+		text: strings.TrimRight(trimmed, cutset),
+		off:  off + len(s) - len(trimmed),
+	}
+}
+
+// quoteError reports a malformed quoted string at byte offset off
+type quoteError struct {
+	code ErrorCode
+	off  int
+	msg  string
+}
+
+// unquote decodes the quoted string at the start of s and returns it along
+// with the number of bytes consumed, including both quotes
+func unquote(s string) (string, int, *quoteError) {
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, &quoteError{CodeUnterminatedString, 0, "unterminated string"}
+			}
+			i++
+			switch s[i] {
+			case '\\':
+				b.WriteByte('\\')
+			case '"':
+				b.WriteByte('"')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, &quoteError{CodeInvalidEscape, i - 1, fmt.Sprintf("invalid escape sequence \\%c", s[i])}
+			}
+		default:
+			b.WriteByte(c)
 		}
-		result = append(result, parsed)
 	}
 
-	return result, nil
+	return "", 0, &quoteError{CodeUnterminatedString, 0, "unterminated string"}
 }
 
 func parseValue(s string) (interface{}, error) {
@@ -238,22 +684,62 @@ func parseValue(s string) (interface{}, error) {
 	}
 
 	// Try number
-	if num, err := strconv.ParseFloat(s, 64); err == nil {
-		// Check if it's an integer
-		if float64(int64(num)) == num {
-			return int64(num), nil
+	if isNumericLiteral(s) {
+		if num, err := strconv.ParseFloat(s, 64); err == nil {
+			// Check if it's an integer
+			if float64(int64(num)) == num {
+				return int64(num), nil
+			}
+			return num, nil
 		}
-		return num, nil
-	}
-
-	// String - remove quotes if present
-	if strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"") {
-		return strings.Trim(s, "\""), nil
 	}
 
 	return s, nil
 }
 
+// isNumericLiteral reports whether s is a plain decimal number such as 42,
+// -1.5 or 1e6. Forms like "Inf", "0x1p4" or "007" stay strings.
+func isNumericLiteral(s string) bool {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	digits := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+		digits++
+	}
+	if digits == 0 || (digits > 1 && strings.TrimPrefix(s, "-")[0] == '0') {
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		frac := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+			frac++
+		}
+		if frac == 0 {
+			return false
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		exp := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+			exp++
+		}
+		if exp == 0 {
+			return false
+		}
+	}
+	return i == len(s)
+}
+
 func countIndent(line string) int {
 	count := 0
 	for _, c := range line {
@@ -267,23 +753,3 @@ func countIndent(line string) int {
 	}
 	return count
 }
-
-// DecodeReader reads TOON from a reader
-func DecodeReader(r *bufio.Reader) (interface{}, error) {
-	var lines []string
-	for {
-		line, err := r.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				if line != "" {
-					lines = append(lines, strings.TrimSuffix(line, "\n"))
-				}
-				break
-			}
-			return nil, err
-		}
-		lines = append(lines, strings.TrimSuffix(line, "\n"))
-	}
-
-	return parseTOON(lines, 0)
-}
@@ -1,6 +1,8 @@
 package toon
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -79,3 +81,138 @@ func TestDecodeNestedObject(t *testing.T) {
 		t.Errorf("Expected name=Alice, got %v", user["name"])
 	}
 }
+
+func TestDecodeSyntaxErrorPosition(t *testing.T) {
+	input := `config:
+  server:
+    items[2]{x,y}:
+      1,2
+      3`
+
+	_, err := Decode(input)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("Expected *SyntaxError, got %T: %v", err, err)
+	}
+
+	if syntaxErr.Line != 5 || syntaxErr.Column != 7 {
+		t.Errorf("Expected line 5, column 7, got line %d, column %d", syntaxErr.Line, syntaxErr.Column)
+	}
+	if syntaxErr.Code != CodeFieldCount {
+		t.Errorf("Expected code %s, got %s", CodeFieldCount, syntaxErr.Code)
+	}
+
+	expected := "5 |       3\n  |       ^"
+	if syntaxErr.Excerpt() != expected {
+		t.Errorf("Expected excerpt:\n%s\nGot:\n%s", expected, syntaxErr.Excerpt())
+	}
+}
+
+func TestDecodeSyntaxErrorCodes(t *testing.T) {
+	tests := []struct {
+		input string
+		code  ErrorCode
+		line  int
+	}{
+		{"tags[3]: a,b", CodeLengthMismatch, 1},
+		{"a: 1\n    b: 2", CodeIndentation, 2},
+		{"a: 1\nb", CodeMissingColon, 2},
+		{"a: \"open", CodeUnterminatedString, 1},
+		{"a: \"bad\\q\"", CodeInvalidEscape, 1},
+		{"items[x]: 1", CodeInvalidHeader, 1},
+		{"items[1]:\n  a", CodeInvalidListItem, 2},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(tt.input)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Decode(%q): expected *SyntaxError, got %v", tt.input, err)
+			continue
+		}
+		if syntaxErr.Code != tt.code || syntaxErr.Line != tt.line {
+			t.Errorf("Decode(%q): expected %s at line %d, got %s at line %d", tt.input, tt.code, tt.line, syntaxErr.Code, syntaxErr.Line)
+		}
+	}
+}
+
+func TestDecodeRoundTripQuoting(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []interface{}{"a,b", "say \"hi\"", "line1\nline2", "", "007"},
+	}
+
+	encoded, err := Encode(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v\n%s", err, encoded)
+	}
+
+	if !reflect.DeepEqual(decoded, data) {
+		t.Errorf("Round trip mismatch:\nencoded: %s\ngot: %#v", encoded, decoded)
+	}
+}
+
+func TestDecodePipeDelimitedInline(t *testing.T) {
+	result, err := Decode("tags[3|]: a,b|c|\"d|e\"")
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	expected := map[string]interface{}{"tags": []interface{}{"a,b", "c", "d|e"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecodeExpandPaths(t *testing.T) {
+	input := `server.http.port: 8080
+server.http.host: localhost
+"a.b": 1
+server:
+  name: api`
+
+	opts := DefaultOptions()
+	opts.ExpandPaths = true
+
+	result, err := DecodeWithOptions(input, opts)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"a.b": int64(1),
+		"server": map[string]interface{}{
+			"name": "api",
+			"http": map[string]interface{}{"port": int64(8080), "host": "localhost"},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestDecodeExpandPathsCollision(t *testing.T) {
+	input := "a.b: 1\na: 2"
+
+	opts := DefaultOptions()
+	opts.ExpandPaths = true
+
+	_, err := DecodeWithOptions(input, opts)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Code != CodePathCollision || syntaxErr.Line != 2 {
+		t.Fatalf("Expected path collision on line 2, got %v", err)
+	}
+
+	opts.PathCollision = CollisionOverwrite
+	result, err := DecodeWithOptions(input, opts)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"a": int64(2)}) {
+		t.Errorf("Expected last write to win, got %v", result)
+	}
+}
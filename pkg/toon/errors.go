@@ -0,0 +1,64 @@
+package toon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorCode classifies a SyntaxError
+type ErrorCode string
+
+// Syntax error codes reported by the decoder
+const (
+	CodeIndentation        ErrorCode = "indentation"
+	CodeMissingColon       ErrorCode = "missing-colon"
+	CodeInvalidKey         ErrorCode = "invalid-key"
+	CodeInvalidHeader      ErrorCode = "invalid-header"
+	CodeLengthMismatch     ErrorCode = "length-mismatch"
+	CodeFieldCount         ErrorCode = "field-count"
+	CodeInvalidListItem    ErrorCode = "invalid-list-item"
+	CodeUnterminatedString ErrorCode = "unterminated-string"
+	CodeInvalidEscape      ErrorCode = "invalid-escape"
+	CodeInvalidValue       ErrorCode = "invalid-value"
+	CodeTrailingContent    ErrorCode = "trailing-content"
+	CodePathCollision      ErrorCode = "path-collision"
+)
+
+// SyntaxError describes malformed TOON input. Line and Column are 1-based
+// and always relative to the start of the document.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Code   ErrorCode
+	Msg    string
+	Source string // The offending line, without its newline
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s [%s]", e.Line, e.Column, e.Msg, e.Code)
+}
+
+// Excerpt renders the offending line with a caret under the error column:
+//
+//	3 | users[2]{id,name}:
+//	  |      ^
+func (e *SyntaxError) Excerpt() string {
+	gutter := fmt.Sprintf("%d", e.Line)
+	pad := strings.Repeat(" ", len(gutter))
+
+	// Keep tabs from the source so the caret lines up in a terminal
+	var marker strings.Builder
+	for i := 0; i < e.Column-1 && i < len(e.Source); i++ {
+		if e.Source[i] == '\t' {
+			marker.WriteByte('\t')
+		} else {
+			marker.WriteByte(' ')
+		}
+	}
+	for i := len(e.Source); i < e.Column-1; i++ {
+		marker.WriteByte(' ')
+	}
+	marker.WriteByte('^')
+
+	return fmt.Sprintf("%s | %s\n%s | %s", gutter, e.Source, pad, marker.String())
+}
@@ -0,0 +1,622 @@
+package toon
+
+import (
+	"encoding"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshaler is implemented by types that encode themselves as TOON. The
+// returned document is decoded and spliced into the surrounding value.
+type Marshaler interface {
+	MarshalTOON() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves from TOON. It
+// receives the TOON encoding of the value at its position.
+type Unmarshaler interface {
+	UnmarshalTOON([]byte) error
+}
+
+var (
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// Marshal returns the TOON encoding of v using DefaultOptions.
+//
+// Struct fields are named by their `toon:"name,omitempty"` tag, falling back
+// to the `json` tag and then the field name; a name of "-" skips the field.
+// Embedded structs are flattened into their parent. time.Time values are
+// written as RFC 3339 strings, encoding.TextMarshaler values as their text,
+// and []byte as base64.
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalWithOptions(v, DefaultOptions())
+}
+
+// MarshalWithOptions is like Marshal but encodes with opts
+func MarshalWithOptions(v interface{}, opts Options) ([]byte, error) {
+	tree, err := toValue(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	output, err := Encode(tree, opts)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(output), nil
+}
+
+// Unmarshal decodes TOON data into the value pointed to by v, following the
+// same field naming rules as Marshal
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("toon: Unmarshal requires a non-nil pointer, got %T", v)
+	}
+
+	tree, err := Decode(string(data))
+	if err != nil {
+		return err
+	}
+	return fromValue(tree, rv.Elem(), "")
+}
+
+// toValue converts a Go value into the map/slice/primitive tree the encoder
+// works on
+func toValue(rv reflect.Value) (interface{}, error) {
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	// A nil pointer or interface is null, whatever methods its type has
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+		return nil, nil
+	}
+
+	// Custom encodings take precedence, including pointer-receiver methods
+	// on addressable values
+	if rv.Kind() != reflect.Ptr && rv.CanAddr() {
+		if ptr := rv.Addr(); ptr.Type().Implements(marshalerType) || ptr.Type().Implements(textMarshalerType) {
+			rv = ptr
+		}
+	}
+	if rv.Type().Implements(marshalerType) {
+		b, err := rv.Interface().(Marshaler).MarshalTOON()
+		if err != nil {
+			return nil, fmt.Errorf("toon: MarshalTOON for %s: %w", rv.Type(), err)
+		}
+		if strings.TrimSpace(string(b)) == "" {
+			return map[string]interface{}{}, nil
+		}
+		return Decode(string(b))
+	}
+	if rv.Type() == timeType {
+		return rv.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	}
+	if rv.Type().Implements(textMarshalerType) {
+		b, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, fmt.Errorf("toon: MarshalText for %s: %w", rv.Type(), err)
+		}
+		return string(b), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return toValue(rv.Elem())
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return rv.Uint(), nil
+	case reflect.Float32:
+		// Go through the shortest float32 text so 0.1 stays 0.1
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(rv.Float(), 'g', -1, 32), 64)
+		return f, nil
+	case reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Struct:
+		return structToValue(rv)
+	case reflect.Map:
+		return mapToValue(rv)
+	case reflect.Slice:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(rv.Bytes()), nil
+		}
+		return sliceToValue(rv)
+	case reflect.Array:
+		return sliceToValue(rv)
+	default:
+		return nil, fmt.Errorf("toon: unsupported type %s", rv.Type())
+	}
+}
+
+func structToValue(rv reflect.Value) (interface{}, error) {
+	obj := make(map[string]interface{})
+	for _, f := range structFields(rv.Type()) {
+		fv, ok := fieldByIndex(rv, f.index, false)
+		if !ok {
+			continue
+		}
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		value, err := toValue(fv)
+		if err != nil {
+			return nil, err
+		}
+		obj[f.name] = value
+	}
+	return obj, nil
+}
+
+func mapToValue(rv reflect.Value) (interface{}, error) {
+	if rv.IsNil() {
+		return nil, nil
+	}
+	obj := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		value, err := toValue(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = value
+	}
+	return obj, nil
+}
+
+func sliceToValue(rv reflect.Value) (interface{}, error) {
+	arr := make([]interface{}, rv.Len())
+	for i := range arr {
+		value, err := toValue(rv.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = value
+	}
+	return arr, nil
+}
+
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if tm, ok := key.Interface().(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("toon: unsupported map key type %s", key.Type())
+}
+
+// fromValue stores a decoded tree value into rv. path names the position
+// for error messages.
+func fromValue(tree interface{}, rv reflect.Value, path string) error {
+	// Allocate through pointers, leaving nil for null
+	if rv.Kind() == reflect.Ptr {
+		if tree == nil {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return fromValue(tree, rv.Elem(), path)
+	}
+
+	if rv.CanAddr() {
+		ptr := rv.Addr()
+		if u, ok := ptr.Interface().(Unmarshaler); ok {
+			encoded, err := Encode(tree, DefaultOptions())
+			if err != nil {
+				return err
+			}
+			return u.UnmarshalTOON([]byte(encoded))
+		}
+		if u, ok := ptr.Interface().(encoding.TextUnmarshaler); ok {
+			if s, isString := tree.(string); isString {
+				return u.UnmarshalText([]byte(s))
+			}
+			if tree == nil {
+				return nil
+			}
+		}
+	}
+
+	if tree == nil {
+		switch rv.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Slice:
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return typeError(tree, rv, path)
+		}
+		rv.Set(reflect.ValueOf(tree))
+		return nil
+
+	case reflect.Bool:
+		b, ok := tree.(bool)
+		if !ok {
+			return typeError(tree, rv, path)
+		}
+		rv.SetBool(b)
+		return nil
+
+	case reflect.String:
+		s, ok := tree.(string)
+		if !ok {
+			return typeError(tree, rv, path)
+		}
+		rv.SetString(s)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := treeInt(tree)
+		if !ok || rv.OverflowInt(n) {
+			return typeError(tree, rv, path)
+		}
+		rv.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u, isUint := tree.(uint64); isUint && !rv.OverflowUint(u) {
+			rv.SetUint(u)
+			return nil
+		}
+		n, ok := treeInt(tree)
+		if !ok || n < 0 || rv.OverflowUint(uint64(n)) {
+			return typeError(tree, rv, path)
+		}
+		rv.SetUint(uint64(n))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := treeFloat(tree)
+		if !ok || rv.OverflowFloat(f) {
+			return typeError(tree, rv, path)
+		}
+		rv.SetFloat(f)
+		return nil
+
+	case reflect.Struct:
+		obj, ok := tree.(map[string]interface{})
+		if !ok {
+			return typeError(tree, rv, path)
+		}
+		return objectToStruct(obj, rv, path)
+
+	case reflect.Map:
+		obj, ok := tree.(map[string]interface{})
+		if !ok {
+			return typeError(tree, rv, path)
+		}
+		return objectToMap(obj, rv, path)
+
+	case reflect.Slice:
+		if s, isString := tree.(string); isString && rv.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return fmt.Errorf("toon: decoding base64 at %s: %w", displayPath(path), err)
+			}
+			rv.SetBytes(b)
+			return nil
+		}
+		arr, ok := tree.([]interface{})
+		if !ok {
+			return typeError(tree, rv, path)
+		}
+		slice := reflect.MakeSlice(rv.Type(), len(arr), len(arr))
+		for i, item := range arr {
+			if err := fromValue(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+		return nil
+
+	case reflect.Array:
+		arr, ok := tree.([]interface{})
+		if !ok || len(arr) > rv.Len() {
+			return typeError(tree, rv, path)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i >= len(arr) {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+				continue
+			}
+			if err := fromValue(arr[i], rv.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("toon: unsupported type %s at %s", rv.Type(), displayPath(path))
+	}
+}
+
+func objectToStruct(obj map[string]interface{}, rv reflect.Value, path string) error {
+	fields := structFields(rv.Type())
+	for key, value := range obj {
+		f := lookupField(fields, key)
+		if f == nil {
+			continue
+		}
+		fv, _ := fieldByIndex(rv, f.index, true)
+		if err := fromValue(value, fv, path+"."+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectToMap(obj map[string]interface{}, rv reflect.Value, path string) error {
+	mt := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMapWithSize(mt, len(obj)))
+	}
+	for key, value := range obj {
+		kv := reflect.New(mt.Key()).Elem()
+		if err := setMapKey(key, kv); err != nil {
+			return fmt.Errorf("toon: map key %q at %s: %w", key, displayPath(path), err)
+		}
+		ev := reflect.New(mt.Elem()).Elem()
+		if err := fromValue(value, ev, path+"."+key); err != nil {
+			return err
+		}
+		rv.SetMapIndex(kv, ev)
+	}
+	return nil
+}
+
+func setMapKey(key string, kv reflect.Value) error {
+	if kv.Kind() == reflect.String {
+		kv.SetString(key)
+		return nil
+	}
+	if u, ok := kv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(key))
+	}
+	switch kv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, kv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		kv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(key, 10, kv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		kv.SetUint(n)
+		return nil
+	}
+	return fmt.Errorf("unsupported map key type %s", kv.Type())
+}
+
+func treeInt(tree interface{}) (int64, bool) {
+	switch n := tree.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+func treeFloat(tree interface{}) (float64, bool) {
+	switch n := tree.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func typeError(tree interface{}, rv reflect.Value, path string) error {
+	return fmt.Errorf("toon: cannot unmarshal %s into Go value of type %s at %s", treeTypeName(tree), rv.Type(), displayPath(path))
+}
+
+func treeTypeName(tree interface{}) string {
+	switch tree.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	default:
+		return "number"
+	}
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+// field describes one encoded struct field
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields lists the encoded fields of t, flattening embedded structs.
+// A name defined at a shallower depth hides the same name deeper down.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	seen := make(map[string]bool)
+
+	type level struct {
+		typ   reflect.Type
+		index []int
+	}
+	current := []level{{typ: t}}
+	for len(current) > 0 {
+		var next []level
+		names := make(map[string]bool)
+
+		for _, lv := range current {
+			for i := 0; i < lv.typ.NumField(); i++ {
+				sf := lv.typ.Field(i)
+				name, omitEmpty, tagged := fieldTag(sf)
+				if name == "-" {
+					continue
+				}
+
+				index := append(append([]int{}, lv.index...), i)
+
+				if sf.Anonymous && !tagged {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct && ft != timeType {
+						next = append(next, level{typ: ft, index: index})
+						continue
+					}
+				}
+				if !sf.IsExported() {
+					continue
+				}
+
+				if name == "" {
+					name = sf.Name
+				}
+				if seen[name] || names[name] {
+					continue
+				}
+				names[name] = true
+				fields = append(fields, field{name: name, index: index, omitEmpty: omitEmpty})
+			}
+		}
+
+		for name := range names {
+			seen[name] = true
+		}
+		current = next
+	}
+
+	return fields
+}
+
+// fieldTag reads the toon tag, falling back to the json tag
+func fieldTag(sf reflect.StructField) (name string, omitEmpty, tagged bool) {
+	tag, ok := sf.Tag.Lookup("toon")
+	if !ok {
+		tag, ok = sf.Tag.Lookup("json")
+	}
+	if !ok {
+		return "", false, false
+	}
+
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return parts[0], omitEmpty, parts[0] != ""
+}
+
+// lookupField finds the field for key, preferring an exact match and
+// falling back to a case-insensitive one
+func lookupField(fields []field, key string) *field {
+	for i := range fields {
+		if fields[i].name == key {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, key) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// fieldByIndex walks an embedded field path. When alloc is set, nil embedded
+// pointers are allocated; otherwise ok is false if one is nil.
+func fieldByIndex(rv reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(idx)
+	}
+	return rv, true
+}
+
+func isEmptyValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	}
+	return false
+}
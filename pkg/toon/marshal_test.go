@@ -0,0 +1,153 @@
+package toon
+
+import (
+	"encoding"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type marshalBase struct {
+	ID      int       `toon:"id"`
+	Created time.Time `json:"created"`
+}
+
+type marshalUser struct {
+	marshalBase
+	Name    string            `toon:"name"`
+	Email   string            `toon:"email,omitempty"`
+	Tags    []string          `json:"tags"`
+	Manager *marshalUser      `toon:"manager,omitempty"`
+	Level   marshalLevel      `toon:"level"`
+	Labels  map[string]string `toon:"labels,omitempty"`
+	Secret  string            `toon:"-"`
+	Score   float32
+}
+
+// marshalLevel encodes itself as text
+type marshalLevel int
+
+func (l marshalLevel) MarshalText() ([]byte, error) {
+	return []byte(strings.Repeat("*", int(l))), nil
+}
+
+func (l *marshalLevel) UnmarshalText(b []byte) error {
+	*l = marshalLevel(len(b))
+	return nil
+}
+
+// marshalPoint encodes itself as TOON
+type marshalPoint struct {
+	X, Y int
+}
+
+func (p marshalPoint) MarshalTOON() ([]byte, error) {
+	return []byte("xy[2]: " + strconv.Itoa(p.X) + "," + strconv.Itoa(p.Y)), nil
+}
+
+func (p *marshalPoint) UnmarshalTOON(b []byte) error {
+	var v struct {
+		XY []int `toon:"xy"`
+	}
+	if err := Unmarshal(b, &v); err != nil {
+		return err
+	}
+	p.X, p.Y = v.XY[0], v.XY[1]
+	return nil
+}
+
+func TestMarshalStruct(t *testing.T) {
+	created := time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)
+	user := marshalUser{
+		marshalBase: marshalBase{ID: 1, Created: created},
+		Name:        "Ada",
+		Tags:        []string{"admin", "ops"},
+		Level:       3,
+		Secret:      "hidden",
+		Score:       0.1,
+	}
+
+	out, err := Marshal(user)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	expected := `Score: 0.1
+created: "2025-01-15T10:30:00Z"
+id: 1
+level: ***
+name: Ada
+tags[2]: admin,ops`
+	if string(out) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+	}
+
+	var decoded marshalUser
+	if err := Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	user.Secret = ""
+	if !reflect.DeepEqual(decoded, user) {
+		t.Errorf("Round trip mismatch:\nwant %+v\ngot  %+v", user, decoded)
+	}
+}
+
+func TestMarshalCustomMarshaler(t *testing.T) {
+	data := map[string]marshalPoint{"origin": {X: 1, Y: 2}}
+
+	out, err := Marshal(data)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(out) != "origin:\n  xy[2]: 1,2" {
+		t.Errorf("Unexpected output:\n%s", out)
+	}
+
+	var decoded map[string]marshalPoint
+	if err := Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, data) {
+		t.Errorf("Expected %v, got %v", data, decoded)
+	}
+}
+
+func TestMarshalNilInterfaces(t *testing.T) {
+	var v struct {
+		Point Marshaler              `toon:"point"`
+		Level encoding.TextMarshaler `toon:"level"`
+		Ptr   *marshalPoint          `toon:"ptr"`
+		Any   interface{}            `toon:"any"`
+	}
+
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if expected := "any: null\nlevel: null\npoint: null\nptr: null"; string(out) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+	}
+
+	v.Point, v.Level = marshalPoint{X: 1, Y: 2}, marshalLevel(2)
+	out, err = Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(out), "level: **") || !strings.Contains(string(out), "xy[2]: 1,2") {
+		t.Errorf("Expected the interface values' own encodings, got:\n%s", out)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var user marshalUser
+	err := Unmarshal([]byte("id: Ada"), &user)
+	if err == nil || !strings.Contains(err.Error(), "cannot unmarshal string") {
+		t.Errorf("Expected type error, got %v", err)
+	}
+
+	if err := Unmarshal([]byte("id: 1"), user); err == nil {
+		t.Error("Expected error for non-pointer target")
+	}
+}
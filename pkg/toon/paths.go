@@ -0,0 +1,167 @@
+package toon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Path expansion collision policies
+const (
+	CollisionError     = "error"
+	CollisionOverwrite = "overwrite"
+)
+
+// isIdentifier reports whether s can be a segment of a folded key
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// encodeKey quotes keys the decoder could not read back verbatim. With key
+// folding enabled, literal dotted keys are quoted as well so that path
+// expansion leaves them alone.
+func encodeKey(key string, opts Options) string {
+	needsQuote := key == "" ||
+		strings.HasPrefix(key, " ") ||
+		strings.HasSuffix(key, " ") ||
+		strings.ContainsAny(key, ":[]{}\"\n\r\t") ||
+		strings.HasPrefix(key, "- ") ||
+		(opts.KeyFolding && strings.Contains(key, "."))
+
+	if needsQuote {
+		return quoteString(key)
+	}
+	return key
+}
+
+// foldKey follows the chain of single-key objects starting at obj[key] and
+// returns the encoded dotted key and the value at the end of the chain.
+// Folding stops at a non-identifier segment, at maxDepth segments, or where
+// the folded key would collide with a sibling key.
+func foldKey(obj map[string]interface{}, key string, maxDepth int) (string, interface{}) {
+	value := obj[key]
+	if !isIdentifier(key) {
+		return encodeKey(key, Options{KeyFolding: true}), value
+	}
+
+	segments := []string{key}
+	values := []interface{}{value}
+	for maxDepth == 0 || len(segments) < maxDepth {
+		nested, ok := value.(map[string]interface{})
+		if !ok || len(nested) != 1 {
+			break
+		}
+		var next string
+		for k := range nested {
+			next = k
+		}
+		if !isIdentifier(next) {
+			break
+		}
+		value = nested[next]
+		segments = append(segments, next)
+		values = append(values, value)
+	}
+
+	// Back off until the folded key no longer shadows a literal sibling
+	for len(segments) > 1 {
+		if _, exists := obj[strings.Join(segments, ".")]; !exists {
+			break
+		}
+		segments = segments[:len(segments)-1]
+		values = values[:len(values)-1]
+	}
+
+	return strings.Join(segments, "."), values[len(values)-1]
+}
+
+// fieldKey is a decoded key. Quoted keys are never expanded into paths.
+type fieldKey struct {
+	name   string
+	quoted bool
+}
+
+// assign stores value under the Key token key in obj, expanding dotted keys
+// into nested objects when path expansion is enabled. Collision errors
+// point at the key.
+func (d *Decoder) assign(obj map[string]interface{}, key Token, value interface{}) error {
+	if !d.opts.ExpandPaths {
+		obj[key.Key] = value
+		return nil
+	}
+
+	segments := []string{key.Key}
+	if !key.Quoted && strings.Contains(key.Key, ".") {
+		segments = strings.Split(key.Key, ".")
+		for _, segment := range segments {
+			if !isIdentifier(segment) {
+				segments = []string{key.Key}
+				break
+			}
+		}
+	}
+
+	target := obj
+	for i, segment := range segments[:len(segments)-1] {
+		existing, exists := target[segment]
+		nested, isObject := existing.(map[string]interface{})
+		if !isObject {
+			if exists && d.opts.PathCollision != CollisionOverwrite {
+				return key.errorf(CodePathCollision, "path %q conflicts with existing non-object value", strings.Join(segments[:i+1], "."))
+			}
+			nested = make(map[string]interface{})
+			target[segment] = nested
+		}
+		target = nested
+	}
+
+	last := segments[len(segments)-1]
+	return d.merge(target, last, value, key.Key, key)
+}
+
+// merge sets target[key] = value, deep-merging objects and applying the
+// collision policy to anything else that already exists
+func (d *Decoder) merge(target map[string]interface{}, key string, value interface{}, path string, tok Token) error {
+	existing, exists := target[key]
+	if !exists {
+		target[key] = value
+		return nil
+	}
+
+	existingObj, ok1 := existing.(map[string]interface{})
+	valueObj, ok2 := value.(map[string]interface{})
+	if ok1 && ok2 {
+		for k, v := range valueObj {
+			if err := d.merge(existingObj, k, v, path+"."+k, tok); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if d.opts.PathCollision != CollisionOverwrite {
+		return tok.errorf(CodePathCollision, "key %q is already defined", path)
+	}
+	target[key] = value
+	return nil
+}
+
+// validatePathOptions checks the decoder's path expansion settings
+func validatePathOptions(opts Options) error {
+	switch opts.PathCollision {
+	case "", CollisionError, CollisionOverwrite:
+		return nil
+	default:
+		return fmt.Errorf("unsupported path collision policy %q: use %q or %q", opts.PathCollision, CollisionError, CollisionOverwrite)
+	}
+}
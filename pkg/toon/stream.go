@@ -0,0 +1,395 @@
+package toon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// TokenKind identifies the kind of a Token
+type TokenKind int
+
+// Token kinds produced by Decoder.Token
+const (
+	ObjectStart TokenKind = iota // Start of an object
+	ObjectEnd                    // End of an object
+	Key                          // An object key; the value's tokens follow
+	ArrayHeader                  // An array header; items or rows follow
+	ArrayEnd                     // End of an array
+	Row                          // One tabular row, as an object
+	Primitive                    // A primitive value
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case ObjectStart:
+		return "object start"
+	case ObjectEnd:
+		return "object end"
+	case Key:
+		return "key"
+	case ArrayHeader:
+		return "array header"
+	case ArrayEnd:
+		return "array end"
+	case Row:
+		return "row"
+	case Primitive:
+		return "primitive"
+	default:
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+}
+
+// Token is one element of a TOON document. Only the fields relevant to
+// Kind are set.
+type Token struct {
+	Kind TokenKind
+
+	// Key is the key name of a Key token. Quoted reports whether the key
+	// was quoted in the source, which exempts it from path expansion.
+	Key    string
+	Quoted bool
+
+	// Value holds the primitive of a Primitive token or the
+	// map[string]interface{} of a Row token
+	Value interface{}
+
+	// Length is the declared length of an ArrayHeader, or -1 if the
+	// header omits it. Fields lists the columns of a tabular array.
+	Length int
+	Fields []string
+
+	// Line and Column locate the token in the input (1-based). End tokens
+	// have no position.
+	Line   int
+	Column int
+
+	source string // The key's line, for errors raised while assigning it
+}
+
+// errorf builds a SyntaxError at the token's position
+func (t Token) errorf(code ErrorCode, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Line:   t.Line,
+		Column: t.Column,
+		Code:   code,
+		Msg:    fmt.Sprintf(format, args...),
+		Source: t.source,
+	}
+}
+
+// Decoder reads a TOON document from an input stream. Lines are read only
+// as they are needed, so a document can be consumed token by token or row
+// by row without holding it in memory.
+type Decoder struct {
+	r    *bufio.Reader
+	opts Options
+
+	next      *sourceLine // Lookahead line, not yet consumed
+	lines     int         // Lines read so far
+	eof       bool
+	separator *sourceLine // The DocumentSeparator that ended the document, if any
+
+	started bool
+	stack   []*frame
+	queue   []Token
+	err     error
+}
+
+// NewDecoder returns a decoder that reads from r with DefaultOptions
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), opts: DefaultOptions()}
+}
+
+// SetOptions sets the decoding options (ExpandPaths, PathCollision,
+// SparseTabular)
+func (d *Decoder) SetOptions(opts Options) {
+	d.opts = opts
+}
+
+// DocumentSeparator is the line between two documents of a stream. It is
+// not valid TOON, so it cannot be mistaken for content.
+const DocumentSeparator = "---"
+
+// NextDocument reports whether another document follows the one just read,
+// after a DocumentSeparator line, and starts reading it. Call it once Token
+// has returned io.EOF.
+//
+//	for {
+//		var v interface{}
+//		if err := d.Decode(&v); err != nil {
+//			...
+//		}
+//		if _, err := d.Token(); err != io.EOF {
+//			...
+//		}
+//		if !d.NextDocument() {
+//			break
+//		}
+//	}
+func (d *Decoder) NextDocument() bool {
+	if d.separator == nil || d.err != io.EOF {
+		return false
+	}
+	d.separator = nil
+	d.started, d.stack, d.queue, d.err = false, nil, nil, nil
+	return true
+}
+
+// Token returns the next token in the document. At the end of the document,
+// or at a DocumentSeparator line, it returns io.EOF; content after the end
+// is reported as a SyntaxError. Errors are sticky.
+func (d *Decoder) Token() (Token, error) {
+	for len(d.queue) == 0 {
+		if d.err != nil {
+			return Token{}, d.err
+		}
+		d.err = d.step()
+	}
+
+	tok := d.queue[0]
+	d.queue = d.queue[1:]
+	return tok, nil
+}
+
+// Decode reads the next complete value from the stream and stores it in the
+// value pointed to by v, following the rules of Unmarshal. At the start of
+// the input this is the whole document; after an ArrayHeader token it is
+// the next item or row.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("toon: Decode requires a non-nil pointer, got %T", v)
+	}
+	if err := validatePathOptions(d.opts); err != nil {
+		return err
+	}
+
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	tree, err := d.build(tok)
+	if err != nil {
+		return err
+	}
+
+	if target, ok := v.(*interface{}); ok {
+		*target = tree
+		return nil
+	}
+	return fromValue(tree, rv.Elem(), "")
+}
+
+// build assembles the value that starts with tok
+func (d *Decoder) build(tok Token) (interface{}, error) {
+	switch tok.Kind {
+	case Primitive, Row:
+		return tok.Value, nil
+
+	case ObjectStart:
+		obj := make(map[string]interface{})
+		for {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if key.Kind == ObjectEnd {
+				return obj, nil
+			}
+			first, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.build(first)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.assign(obj, key, value); err != nil {
+				return nil, err
+			}
+		}
+
+	case ArrayHeader:
+		arr := make([]interface{}, 0, max(tok.Length, 0))
+		for {
+			item, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if item.Kind == ArrayEnd {
+				return arr, nil
+			}
+			value, err := d.build(item)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+
+	default:
+		return nil, fmt.Errorf("toon: unexpected %s token", tok.Kind)
+	}
+}
+
+// Encoder writes TOON documents to an output stream
+type Encoder struct {
+	w    io.Writer
+	opts Options
+}
+
+// NewEncoder returns an encoder that writes to w with DefaultOptions
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, opts: DefaultOptions()}
+}
+
+// SetOptions sets the encoding options
+func (e *Encoder) SetOptions(opts Options) {
+	e.opts = opts
+}
+
+// Encode writes the TOON encoding of v, followed by a newline. v may be
+// anything Marshal accepts. Lines are written as they are produced rather
+// than after the whole document is built.
+func (e *Encoder) Encode(v interface{}) error {
+	tree, err := toValue(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	if err := encodeTo(e.w, tree, e.opts); err != nil {
+		return err
+	}
+	_, err = io.WriteString(e.w, "\n")
+	return err
+}
+
+// RowScanner iterates over the rows of a document whose top level is a
+// single array, such as one huge "items[N]{...}:" table. Only the current
+// row is held in memory.
+//
+//	s := toon.NewRowScanner(r)
+//	for s.Scan() {
+//		process(s.Row())
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+type RowScanner struct {
+	d       *Decoder
+	key     string
+	header  Token
+	inObj   bool
+	started bool
+	done    bool
+	row     interface{}
+	err     error
+}
+
+// NewRowScanner returns a scanner that reads from r with DefaultOptions
+func NewRowScanner(r io.Reader) *RowScanner {
+	return &RowScanner{d: NewDecoder(r)}
+}
+
+// SetOptions sets the decoding options
+func (s *RowScanner) SetOptions(opts Options) {
+	s.d.SetOptions(opts)
+}
+
+// Scan advances to the next row, returning false at the end of the array or
+// on error
+func (s *RowScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+	if !s.started {
+		s.started = true
+		if s.err = s.start(); s.err != nil {
+			s.done = true
+			return false
+		}
+	}
+
+	tok, err := s.d.Token()
+	if err != nil {
+		return s.fail(err)
+	}
+	if tok.Kind == ArrayEnd {
+		s.done = true
+		s.err = s.finish()
+		return false
+	}
+	if s.row, err = s.d.build(tok); err != nil {
+		return s.fail(err)
+	}
+	return true
+}
+
+// Row returns the current row: an object for tabular arrays, or the item
+// itself for list and inline arrays
+func (s *RowScanner) Row() interface{} {
+	return s.row
+}
+
+// Key returns the top-level key holding the array, or "" for a root array
+func (s *RowScanner) Key() string {
+	return s.key
+}
+
+// Header returns the ArrayHeader token of the array, once Scan has been
+// called
+func (s *RowScanner) Header() Token {
+	return s.header
+}
+
+// Err returns the first error encountered by Scan
+func (s *RowScanner) Err() error {
+	return s.err
+}
+
+func (s *RowScanner) fail(err error) bool {
+	s.done = true
+	s.row = nil
+	s.err = err
+	return false
+}
+
+// start reads up to the array header
+func (s *RowScanner) start() error {
+	tok, err := s.d.Token()
+	if err != nil {
+		return err
+	}
+	if tok.Kind == ObjectStart {
+		s.inObj = true
+		if tok, err = s.d.Token(); err != nil {
+			return err
+		}
+		if tok.Kind == Key {
+			s.key = tok.Key
+			if tok, err = s.d.Token(); err != nil {
+				return err
+			}
+		}
+	}
+	if tok.Kind != ArrayHeader {
+		return fmt.Errorf("toon: row streaming requires a document whose top level is a single array")
+	}
+	s.header = tok
+	return nil
+}
+
+// finish checks that nothing follows the array
+func (s *RowScanner) finish() error {
+	if s.inObj {
+		tok, err := s.d.Token()
+		if err != nil {
+			return err
+		}
+		if tok.Kind != ObjectEnd {
+			return tok.errorf(CodeTrailingContent, "row streaming requires a single top-level key, found %q after %q", tok.Key, s.key)
+		}
+	}
+	return s.d.end()
+}
@@ -0,0 +1,197 @@
+package toon
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoderTokens(t *testing.T) {
+	input := `name: demo
+users[2]{id,name}:
+  1,Alice
+  2,Bob
+items[2]:
+  - tags[2]: a,b
+    n: 1
+  - x`
+
+	expected := []Token{
+		{Kind: ObjectStart, Line: 1, Column: 1},
+		{Kind: Key, Key: "name", Line: 1, Column: 1},
+		{Kind: Primitive, Value: "demo", Line: 1, Column: 7},
+		{Kind: Key, Key: "users", Line: 2, Column: 1},
+		{Kind: ArrayHeader, Length: 2, Fields: []string{"id", "name"}, Line: 2, Column: 6},
+		{Kind: Row, Value: map[string]interface{}{"id": int64(1), "name": "Alice"}, Line: 3, Column: 3},
+		{Kind: Row, Value: map[string]interface{}{"id": int64(2), "name": "Bob"}, Line: 4, Column: 3},
+		{Kind: ArrayEnd},
+		{Kind: Key, Key: "items", Line: 5, Column: 1},
+		{Kind: ArrayHeader, Length: 2, Line: 5, Column: 6},
+		{Kind: ObjectStart, Line: 6, Column: 5},
+		{Kind: Key, Key: "tags", Line: 6, Column: 5},
+		{Kind: ArrayHeader, Length: 2, Line: 6, Column: 9},
+		{Kind: Primitive, Value: "a", Line: 6, Column: 14},
+		{Kind: Primitive, Value: "b", Line: 6, Column: 16},
+		{Kind: ArrayEnd},
+		{Kind: Key, Key: "n", Line: 7, Column: 5},
+		{Kind: Primitive, Value: int64(1), Line: 7, Column: 8},
+		{Kind: ObjectEnd},
+		{Kind: Primitive, Value: "x", Line: 8, Column: 5},
+		{Kind: ArrayEnd},
+		{Kind: ObjectEnd},
+	}
+
+	d := NewDecoder(strings.NewReader(input))
+	for i, want := range expected {
+		got, err := d.Token()
+		if err != nil {
+			t.Fatalf("token %d: unexpected error: %v", i, err)
+		}
+		got.source = ""
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("token %d:\nwant %+v\ngot  %+v", i, want, got)
+		}
+	}
+	if _, err := d.Token(); err != io.EOF {
+		t.Errorf("Expected io.EOF after the document, got %v", err)
+	}
+}
+
+func TestDecoderIncremental(t *testing.T) {
+	pr, pw := io.Pipe()
+	more := make(chan struct{})
+	go func() {
+		io.WriteString(pw, "rows[2]{id}:\n  1\n")
+		<-more
+		io.WriteString(pw, "  2\n")
+		pw.Close()
+	}()
+
+	// The first row must be available before the rest of the input exists
+	d := NewDecoder(pr)
+	for _, kind := range []TokenKind{ObjectStart, Key, ArrayHeader} {
+		if tok, err := d.Token(); err != nil || tok.Kind != kind {
+			t.Fatalf("Expected %s, got %s (%v)", kind, tok.Kind, err)
+		}
+	}
+	var row struct {
+		ID int `toon:"id"`
+	}
+	if err := d.Decode(&row); err != nil || row.ID != 1 {
+		t.Fatalf("Expected first row, got %+v (%v)", row, err)
+	}
+
+	close(more)
+	if err := d.Decode(&row); err != nil || row.ID != 2 {
+		t.Fatalf("Expected second row, got %+v (%v)", row, err)
+	}
+	for _, kind := range []TokenKind{ArrayEnd, ObjectEnd} {
+		if tok, err := d.Token(); err != nil || tok.Kind != kind {
+			t.Fatalf("Expected %s, got %s (%v)", kind, tok.Kind, err)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	d := NewDecoder(strings.NewReader("a: 1\nb[3]: x,y\n"))
+	var v interface{}
+	err := d.Decode(&v)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Line != 2 || serr.Code != CodeLengthMismatch {
+		t.Fatalf("Expected length mismatch on line 2, got %v", err)
+	}
+	if _, err2 := d.Token(); err2 != err {
+		t.Errorf("Expected sticky error, got %v", err2)
+	}
+}
+
+func TestDecoderDocuments(t *testing.T) {
+	input := "a: 1\n\nb: x\n---\na: 2\n---\n[2]: 3,4\n"
+	d := NewDecoder(strings.NewReader(input))
+	var docs []interface{}
+	for {
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if _, err := d.Token(); err != io.EOF {
+			t.Fatalf("Expected the end of the document, got %v", err)
+		}
+		docs = append(docs, v)
+		if !d.NextDocument() {
+			break
+		}
+	}
+	expected := []interface{}{
+		map[string]interface{}{"a": int64(1), "b": "x"},
+		map[string]interface{}{"a": int64(2)},
+		[]interface{}{int64(3), int64(4)},
+	}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected %v, got %v", expected, docs)
+	}
+
+	// A single document must be the whole input
+	_, err := Decode(input)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Code != CodeTrailingContent || syntaxErr.Line != 4 {
+		t.Errorf("Expected a trailing content error on line 4, got %v", err)
+	}
+}
+
+func TestEncoderStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	opts := DefaultOptions()
+	opts.Delimiter = DelimiterPipe
+	enc.SetOptions(opts)
+
+	if err := enc.Encode(map[string]interface{}{"tags": []interface{}{"a", "b"}}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if err := enc.Encode(struct {
+		Name string `toon:"name"`
+	}{"Ada"}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "tags[2|]: a|b\nname: Ada\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestRowScanner(t *testing.T) {
+	s := NewRowScanner(strings.NewReader("items[3]{id,name}:\n  1,a\n  2,b\n  3,c\n"))
+	var ids []int64
+	for s.Scan() {
+		ids = append(ids, s.Row().(map[string]interface{})["id"].(int64))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) || s.Key() != "items" || s.Header().Length != 3 {
+		t.Errorf("Unexpected scan: ids %v, key %q, header %+v", ids, s.Key(), s.Header())
+	}
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not an array", "a: 1\n"},
+		{"second key", "items[1]{id}:\n  1\nother: 2\n"},
+		{"length mismatch", "items[2]{id}:\n  1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRowScanner(strings.NewReader(tt.input))
+			for s.Scan() {
+			}
+			if s.Err() == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
@@ -1,17 +1,82 @@
 package toon
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
 )
 
+// Delimiters allowed in array headers and rows. DelimiterAuto lets the
+// encoder pick, per array, whichever delimiter needs the least quoting.
+const (
+	DelimiterComma = ","
+	DelimiterTab   = "\t"
+	DelimiterPipe  = "|"
+	DelimiterAuto  = "auto"
+)
+
+// Fill values for missing fields in sparse tables
+const (
+	SparseFillEmpty = "empty"
+	SparseFillNull  = "null"
+)
+
+// Blank-line policies between top-level keys
+const (
+	BlankLinesNone   = "none"   // No blank lines (default)
+	BlankLinesAll    = "all"    // A blank line between every top-level key
+	BlankLinesBlocks = "blocks" // Blank lines around multi-line entries only
+)
+
+// Quoting policies for string values
+const (
+	QuoteMinimal = "minimal" // Quote only when the value would be ambiguous (default)
+	QuoteAlways  = "always"  // Quote every string value
+)
+
+// autoDelimiters lists the candidates tried in auto mode, in order of preference
+var autoDelimiters = []string{DelimiterComma, DelimiterTab, DelimiterPipe}
+
 // Options for TOON encoding/decoding
 type Options struct {
 	Indent    int
-	Delimiter string
+	Delimiter string // DelimiterComma, DelimiterTab, DelimiterPipe or DelimiterAuto
 	UseTab    bool
+
+	// KeyFolding collapses chains of single-key objects into dotted keys
+	// (a.b.c: value) when encoding. FlattenDepth caps the number of
+	// segments in a folded key; 0 means unlimited.
+	KeyFolding   bool
+	FlattenDepth int
+
+	// ExpandPaths splits unquoted dotted keys into nested objects when
+	// decoding. PathCollision decides what happens when an expanded path
+	// conflicts with an existing value: CollisionError (the default) or
+	// CollisionOverwrite.
+	ExpandPaths   bool
+	PathCollision string
+
+	// SparseTabular writes arrays of objects with differing keys as a
+	// table over the union of their keys when that is shorter than list
+	// form. SparseFill selects how missing fields are written:
+	// SparseFillEmpty (an empty cell) or SparseFillNull. When decoding,
+	// SparseTabular reads an empty unquoted cell back as an absent field
+	// rather than an empty string.
+	SparseTabular bool
+	SparseFill    string
+
+	// Style options. LengthMarker writes array lengths as [#N];
+	// OmitLengths drops them entirely ([]). BlankLines is one of the
+	// BlankLines* policies, Quoting one of the Quote* policies, and
+	// TrailingNewline ends the document with a newline.
+	LengthMarker    bool
+	OmitLengths     bool
+	BlankLines      string
+	Quoting         string
+	TrailingNewline bool
 }
 
 // DefaultOptions returns default TOON options
@@ -28,37 +93,184 @@ type Value interface{}
 
 // Encode converts a Go value to TOON format
 func Encode(v interface{}, opts Options) (string, error) {
-	return encode(v, opts, 0)
+	var b strings.Builder
+	if err := encodeTo(&b, v, opts); err != nil {
+		return "", err
+	}
+
+	output := b.String()
+	if opts.TrailingNewline && !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+	return output, nil
+}
+
+// encodeTo writes the TOON encoding of v to w, without a trailing newline
+func encodeTo(w io.Writer, v interface{}, opts Options) error {
+	if opts.Delimiter == "" {
+		opts.Delimiter = DelimiterComma
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	e := &encoder{w: bufio.NewWriter(w), opts: opts}
+	if err := e.encode(v, 0); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// validate rejects unknown values for the encoder's enumerated options
+func (o Options) validate() error {
+	switch o.Delimiter {
+	case DelimiterComma, DelimiterTab, DelimiterPipe, DelimiterAuto:
+	default:
+		return fmt.Errorf("unsupported delimiter %q: use ',', '\\t', '|' or 'auto'", o.Delimiter)
+	}
+	switch o.SparseFill {
+	case "", SparseFillEmpty, SparseFillNull:
+	default:
+		return fmt.Errorf("unsupported sparse fill %q: use %q or %q", o.SparseFill, SparseFillEmpty, SparseFillNull)
+	}
+	switch o.BlankLines {
+	case "", BlankLinesNone, BlankLinesAll, BlankLinesBlocks:
+	default:
+		return fmt.Errorf("unsupported blank-line policy %q: use %q, %q or %q", o.BlankLines, BlankLinesNone, BlankLinesAll, BlankLinesBlocks)
+	}
+	switch o.Quoting {
+	case "", QuoteMinimal, QuoteAlways:
+	default:
+		return fmt.Errorf("unsupported quoting policy %q: use %q or %q", o.Quoting, QuoteMinimal, QuoteAlways)
+	}
+	return nil
+}
+
+// header builds the "[N]" part of an array header, including the length
+// marker and delimiter marker as configured
+func (o Options) header(length int, delim string) string {
+	switch {
+	case o.OmitLengths:
+		return "[" + headerMarker(delim) + "]"
+	case o.LengthMarker:
+		return fmt.Sprintf("[#%d%s]", length, headerMarker(delim))
+	default:
+		return fmt.Sprintf("[%d%s]", length, headerMarker(delim))
+	}
+}
+
+// quoteValue encodes a string value under the configured quoting policy
+func (o Options) quoteValue(s, delim string) string {
+	if o.Quoting == QuoteAlways {
+		return quoteString(s)
+	}
+	return encodeString(s, delim)
 }
 
-func encode(v interface{}, opts Options, depth int) (string, error) {
-	if v == nil {
-		return "null", nil
+// documentDelimiter is the delimiter that governs quoting outside arrays
+func (o Options) documentDelimiter() string {
+	if o.Delimiter == DelimiterAuto {
+		return DelimiterComma
 	}
+	return o.Delimiter
+}
+
+// arrayDelimiter picks the delimiter for one array given its cell values.
+// In auto mode the candidate that forces the fewest quoted strings wins.
+func (o Options) arrayDelimiter(values []interface{}) string {
+	if o.Delimiter != DelimiterAuto {
+		return o.Delimiter
+	}
+
+	best, bestQuoted := DelimiterComma, -1
+	for _, delim := range autoDelimiters {
+		quoted := 0
+		for _, v := range values {
+			if s, ok := v.(string); ok && strings.HasPrefix(encodeString(s, delim), `"`) {
+				quoted++
+			}
+		}
+		if bestQuoted == -1 || quoted < bestQuoted {
+			best, bestQuoted = delim, quoted
+		}
+	}
+	return best
+}
+
+// headerMarker returns the delimiter marker written inside "[N]". Comma is
+// the default and has no marker.
+func headerMarker(delim string) string {
+	if delim == DelimiterComma {
+		return ""
+	}
+	return delim
+}
+
+// encoder writes a document line by line as it walks the value, so large
+// documents are never assembled in memory
+type encoder struct {
+	w     *bufio.Writer
+	opts  Options
+	lines int
+}
+
+// line writes one output line. Lines are separated, not terminated, by
+// newlines.
+func (e *encoder) line(s string) {
+	if e.lines > 0 {
+		e.w.WriteByte('\n')
+	}
+	e.w.WriteString(s)
+	e.lines++
+}
 
+// render runs fn against a scratch encoder and returns what it wrote
+func (e *encoder) render(fn func(*encoder) error) (string, error) {
+	var b strings.Builder
+	scratch := &encoder{w: bufio.NewWriter(&b), opts: e.opts}
+	if err := fn(scratch); err != nil {
+		return "", err
+	}
+	if err := scratch.w.Flush(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (e *encoder) encode(v interface{}, depth int) error {
 	switch val := v.(type) {
 	case map[string]interface{}:
-		return encodeObject(val, opts, depth)
+		return e.encodeObject(val, depth, "")
 	case []interface{}:
-		return encodeArray(val, opts, depth)
+		// Root array: rows and items sit one level below the header
+		return e.encodeArray("", val, depth+1)
+	default:
+		e.line(e.scalar(v, e.opts.documentDelimiter()))
+		return nil
+	}
+}
+
+// scalar formats a primitive value for a document using delimiter delim
+func (e *encoder) scalar(v interface{}, delim string) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
 	case bool:
-		return fmt.Sprintf("%t", val), nil
+		return fmt.Sprintf("%t", val)
 	case float64, int, int64:
-		return fmt.Sprintf("%v", val), nil
+		return fmt.Sprintf("%v", val)
 	case string:
-		return encodeString(val, opts.Delimiter), nil
+		return e.opts.quoteValue(val, delim)
 	default:
-		return fmt.Sprintf("%v", val), nil
+		return fmt.Sprintf("%v", val)
 	}
 }
 
-func encodeObject(obj map[string]interface{}, opts Options, depth int) (string, error) {
-	if len(obj) == 0 {
-		return "", nil
-	}
-
-	var lines []string
-	indent := makeIndent(depth, opts)
+// encodeObject writes the fields of obj at depth. A non-empty prefix
+// replaces the indentation of the first line, which is how list items put
+// their first field after the hyphen.
+func (e *encoder) encodeObject(obj map[string]interface{}, depth int, prefix string) error {
+	indent := makeIndent(depth, e.opts)
 
 	// Sort keys for deterministic output
 	keys := make([]string, 0, len(obj))
@@ -67,161 +279,221 @@ func encodeObject(obj map[string]interface{}, opts Options, depth int) (string,
 	}
 	sort.Strings(keys)
 
-	for _, key := range keys {
-		value := obj[key]
-		if value == nil {
-			lines = append(lines, fmt.Sprintf("%s%s: null", indent, key))
-			continue
+	previousMultiline := false
+	for i, literal := range keys {
+		key, value := literal, obj[literal]
+		if e.opts.KeyFolding {
+			key, value = foldKey(obj, literal, e.opts.FlattenDepth)
+		} else {
+			key = encodeKey(key, e.opts)
+		}
+
+		// Top-level blank-line policies look at entry boundaries
+		multiline := isMultiline(value)
+		if depth == 0 && i > 0 {
+			policy := e.opts.BlankLines
+			if policy == BlankLinesAll || (policy == BlankLinesBlocks && (multiline || previousMultiline)) {
+				e.line("")
+			}
+		}
+		previousMultiline = multiline
+
+		start := indent
+		if i == 0 && prefix != "" {
+			start = prefix
 		}
 
 		switch v := value.(type) {
 		case map[string]interface{}:
-			nested, err := encodeObject(v, opts, depth+1)
-			if err != nil {
-				return "", err
+			e.line(start + key + ":")
+			if err := e.encodeObject(v, depth+1, ""); err != nil {
+				return err
 			}
-			lines = append(lines, fmt.Sprintf("%s%s:", indent, key))
-			lines = append(lines, nested)
-
 		case []interface{}:
-			encoded, err := encodeArrayValue(v, opts, depth+1)
-			if err != nil {
-				return "", err
+			if err := e.encodeArray(start+key, v, depth+1); err != nil {
+				return err
 			}
-			lines = append(lines, fmt.Sprintf("%s%s%s", indent, key, encoded))
-
 		default:
-			encoded, err := encode(value, opts, depth)
-			if err != nil {
-				return "", err
-			}
-			lines = append(lines, fmt.Sprintf("%s%s: %s", indent, key, encoded))
+			e.line(start + key + ": " + e.scalar(value, e.opts.documentDelimiter()))
 		}
 	}
 
-	return strings.Join(lines, "\n"), nil
+	return nil
+}
+
+// isMultiline reports whether an object entry holding value spans more
+// than one line
+func isMultiline(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		if len(v) == 0 {
+			return false
+		}
+		if _, ok := tabularFields(v, false); ok {
+			return true
+		}
+		return !isAllPrimitives(v)
+	default:
+		return false
+	}
 }
 
-func encodeArray(arr []interface{}, opts Options, depth int) (string, error) {
+// encodeArray writes arr with its header following prefix (a key, a list
+// hyphen, or nothing for a root array). Rows and items go at depth.
+func (e *encoder) encodeArray(prefix string, arr []interface{}, depth int) error {
 	if len(arr) == 0 {
-		return "[0]:", nil
+		e.line(prefix + e.opts.header(0, DelimiterComma) + ":")
+		return nil
 	}
 
-	// Check if array is uniform (all objects with same keys)
-	if isUniformArray(arr) {
-		return encodeTabularArray(arr, opts, depth)
+	// Uniform objects with primitive values use the tabular format
+	if fields, ok := tabularFields(arr, false); ok {
+		e.encodeTabularArray(prefix, arr, fields, depth)
+		return nil
 	}
 
 	// Check if all primitives
 	if isAllPrimitives(arr) {
-		return encodePrimitiveArray(arr, opts)
+		e.encodePrimitiveArray(prefix, arr)
+		return nil
+	}
+
+	// Sparse tables fill missing fields, so only use one if it is shorter
+	// than the list
+	if e.opts.SparseTabular {
+		if fields, ok := tabularFields(arr, true); ok {
+			list, err := e.render(func(s *encoder) error {
+				return s.encodeMixedArray(prefix, arr, depth)
+			})
+			if err != nil {
+				return err
+			}
+			sparse, _ := e.render(func(s *encoder) error {
+				s.encodeTabularArray(prefix, arr, fields, depth)
+				return nil
+			})
+			if len(sparse) < len(list) {
+				e.line(sparse)
+			} else {
+				e.line(list)
+			}
+			return nil
+		}
 	}
 
 	// Mixed array - use list format
-	return encodeMixedArray(arr, opts, depth)
+	return e.encodeMixedArray(prefix, arr, depth)
 }
 
-// encodeArrayValue is an alias for encodeArray for backward compatibility
-func encodeArrayValue(arr []interface{}, opts Options, depth int) (string, error) {
-	return encodeArray(arr, opts, depth)
-}
+func (e *encoder) encodePrimitiveArray(prefix string, arr []interface{}) {
+	delim := e.opts.arrayDelimiter(arr)
 
-func encodePrimitiveArray(arr []interface{}, opts Options) (string, error) {
-	var values []string
-	for _, item := range arr {
-		switch v := item.(type) {
-		case string:
-			values = append(values, encodeString(v, opts.Delimiter))
-		case bool:
-			values = append(values, fmt.Sprintf("%t", v))
-		default:
-			values = append(values, fmt.Sprintf("%v", v))
-		}
+	values := make([]string, len(arr))
+	for i, item := range arr {
+		values[i] = e.scalar(item, delim)
 	}
-	return fmt.Sprintf("[%d]: %s", len(arr), strings.Join(values, opts.Delimiter)), nil
+	e.line(fmt.Sprintf("%s%s: %s", prefix, e.opts.header(len(arr), delim), strings.Join(values, delim)))
 }
 
-func encodeTabularArray(arr []interface{}, opts Options, depth int) (string, error) {
-	if len(arr) == 0 {
-		return "[0]:", nil
-	}
-
-	// Get fields from first object
-	first, ok := arr[0].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("expected object in uniform array")
+func (e *encoder) encodeTabularArray(prefix string, arr []interface{}, fields []string, depth int) {
+	// Pick the delimiter from every cell in the table
+	var cells []interface{}
+	for _, item := range arr {
+		obj := item.(map[string]interface{})
+		for _, field := range fields {
+			cells = append(cells, obj[field])
+		}
 	}
+	delim := e.opts.arrayDelimiter(cells)
 
-	// Sort fields for deterministic output
-	var fields []string
-	for key := range first {
-		fields = append(fields, key)
+	// Write header
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = encodeString(field, delim)
 	}
-	sort.Strings(fields)
+	e.line(fmt.Sprintf("%s%s{%s}:", prefix, e.opts.header(len(arr), delim), strings.Join(names, delim)))
 
-	// Build header
-	header := fmt.Sprintf("[%d]{%s}:", len(arr), strings.Join(fields, opts.Delimiter))
-
-	// Build rows
-	indent := makeIndent(depth, opts)
-	var rows []string
+	// Write rows
+	indent := makeIndent(depth, e.opts)
+	values := make([]string, len(fields))
 	for _, item := range arr {
-		obj, ok := item.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		var values []string
-		for _, field := range fields {
-			val := obj[field]
-			switch v := val.(type) {
-			case string:
-				values = append(values, encodeString(v, opts.Delimiter))
-			case nil:
-				values = append(values, "null")
-			default:
-				values = append(values, fmt.Sprintf("%v", v))
+		obj := item.(map[string]interface{})
+		for i, field := range fields {
+			val, exists := obj[field]
+			if !exists && e.opts.SparseFill != SparseFillNull {
+				values[i] = ""
+			} else {
+				values[i] = e.scalar(val, delim)
 			}
 		}
-		rows = append(rows, fmt.Sprintf("%s%s", indent, strings.Join(values, opts.Delimiter)))
+		e.line(indent + strings.Join(values, delim))
 	}
-
-	return header + "\n" + strings.Join(rows, "\n"), nil
 }
 
-func encodeMixedArray(arr []interface{}, opts Options, depth int) (string, error) {
-	indent := makeIndent(depth, opts)
-	var lines []string
-	lines = append(lines, fmt.Sprintf("[%d]:", len(arr)))
+func (e *encoder) encodeMixedArray(prefix string, arr []interface{}, depth int) error {
+	e.line(prefix + e.opts.header(len(arr), DelimiterComma) + ":")
 
 	for _, item := range arr {
-		encoded, err := encode(item, opts, depth)
-		if err != nil {
-			return "", err
+		if err := e.encodeListItem(item, depth); err != nil {
+			return err
 		}
-		lines = append(lines, fmt.Sprintf("%s- %s", indent, encoded))
 	}
+	return nil
+}
+
+// encodeListItem writes one "- " item with the hyphen at depth. An object
+// puts its first field on the hyphen line and the rest one level deeper.
+func (e *encoder) encodeListItem(item interface{}, depth int) error {
+	hyphen := makeIndent(depth, e.opts) + "- "
 
-	return strings.Join(lines, "\n"), nil
+	switch v := item.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			e.line(strings.TrimSuffix(hyphen, " "))
+			return nil
+		}
+		return e.encodeObject(v, depth+1, hyphen)
+	case []interface{}:
+		return e.encodeArray(hyphen, v, depth+1)
+	default:
+		e.line(hyphen + e.scalar(item, e.opts.documentDelimiter()))
+		return nil
+	}
 }
 
 func encodeString(s, delimiter string) string {
 	// Quote if needed
-	needsQuote := strings.HasPrefix(s, " ") ||
+	needsQuote := s == "" ||
+		strings.HasPrefix(s, " ") ||
 		strings.HasSuffix(s, " ") ||
 		strings.Contains(s, delimiter) ||
 		strings.Contains(s, ":") ||
-		strings.Contains(s, "\n") ||
+		strings.ContainsAny(s, "\"\n\r\t") ||
 		s == "true" || s == "false" || s == "null" ||
 		strings.HasPrefix(s, "-") ||
 		looksLikeNumber(s)
 
 	if needsQuote {
-		return fmt.Sprintf("\"%s\"", strings.ReplaceAll(s, "\"", "\\\""))
+		return quoteString(s)
 	}
 	return s
 }
 
+// quoteEscaper escapes the characters the decoder recognizes inside quotes
+var quoteEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+)
+
+func quoteString(s string) string {
+	return "\"" + quoteEscaper.Replace(s) + "\""
+}
+
 func looksLikeNumber(s string) bool {
 	// Check if string is a valid number and nothing else
 	if s == "" {
@@ -231,41 +503,46 @@ func looksLikeNumber(s string) bool {
 	return err == nil
 }
 
-func isUniformArray(arr []interface{}) bool {
-	if len(arr) == 0 {
-		return false
-	}
-
+// tabularFields returns the sorted column names for arr if it can be written
+// as a table: every item an object whose values are all primitives. Unless
+// sparse is set, every object must also have the same keys.
+func tabularFields(arr []interface{}, sparse bool) ([]string, bool) {
 	first, ok := arr[0].(map[string]interface{})
 	if !ok {
-		return false
-	}
-
-	// Get first object's keys
-	var firstKeys []string
-	for key := range first {
-		firstKeys = append(firstKeys, key)
+		return nil, false
 	}
 
-	// Check all other objects have same keys
-	for i := 1; i < len(arr); i++ {
-		obj, ok := arr[i].(map[string]interface{})
+	seen := make(map[string]bool)
+	for _, item := range arr {
+		obj, ok := item.(map[string]interface{})
 		if !ok {
-			return false
+			return nil, false
 		}
-
-		if len(obj) != len(firstKeys) {
-			return false
+		if !sparse && len(obj) != len(first) {
+			return nil, false
 		}
-
-		for _, key := range firstKeys {
-			if _, exists := obj[key]; !exists {
-				return false
+		for key, value := range obj {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, false
+			}
+			if _, exists := first[key]; !sparse && !exists {
+				return nil, false
 			}
+			seen[key] = true
 		}
 	}
 
-	return true
+	if len(seen) == 0 {
+		return nil, false
+	}
+
+	fields := make([]string, 0, len(seen))
+	for key := range seen {
+		fields = append(fields, key)
+	}
+	sort.Strings(fields)
+	return fields, true
 }
 
 func isAllPrimitives(arr []interface{}) bool {
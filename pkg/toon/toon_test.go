@@ -1,6 +1,7 @@
 package toon
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -109,6 +110,211 @@ active: true`
 	}
 }
 
+func TestEncodeDelimiters(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"sku": "A1", "desc": "Widget, large"},
+			map[string]interface{}{"sku": "B2", "desc": "Gadget"},
+		},
+	}
+
+	tests := []struct {
+		delimiter string
+		expected  string
+	}{
+		{DelimiterComma, "items[2]{desc,sku}:\n  \"Widget, large\",A1\n  Gadget,B2"},
+		{DelimiterPipe, "items[2|]{desc|sku}:\n  Widget, large|A1\n  Gadget|B2"},
+		{DelimiterTab, "items[2\t]{desc\tsku}:\n  Widget, large\tA1\n  Gadget\tB2"},
+		{DelimiterAuto, "items[2\t]{desc\tsku}:\n  Widget, large\tA1\n  Gadget\tB2"},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Delimiter = tt.delimiter
+
+		result, err := Encode(data, opts)
+		if err != nil {
+			t.Fatalf("Encode(%q) failed: %v", tt.delimiter, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Encode(%q):\nExpected:\n%s\nGot:\n%s", tt.delimiter, tt.expected, result)
+		}
+
+		decoded, err := Decode(result)
+		if err != nil {
+			t.Fatalf("Decode(%q) failed: %v", tt.delimiter, err)
+		}
+		if !reflect.DeepEqual(decoded, data) {
+			t.Errorf("Round trip with %q: got %#v", tt.delimiter, decoded)
+		}
+	}
+
+	if _, err := Encode(data, Options{Indent: 2, Delimiter: ";"}); err == nil {
+		t.Error("Expected error for unsupported delimiter")
+	}
+}
+
+func TestEncodeKeyFolding(t *testing.T) {
+	data := map[string]interface{}{
+		"server": map[string]interface{}{
+			"http": map[string]interface{}{"port": float64(8080)},
+		},
+		"db":       map[string]interface{}{"host": "x", "port": float64(1)},
+		"a.b":      float64(1),
+		"my-key":   map[string]interface{}{"c": true},
+		"settings": map[string]interface{}{"deep": map[string]interface{}{"x": float64(1), "y": float64(2)}},
+	}
+
+	opts := DefaultOptions()
+	opts.KeyFolding = true
+
+	result, err := Encode(data, opts)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := `"a.b": 1
+db:
+  host: x
+  port: 1
+my-key:
+  c: true
+server.http.port: 8080
+settings.deep:
+  x: 1
+  y: 2`
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	opts.FlattenDepth = 2
+	result, err = Encode(data, opts)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !contains(result, "server.http:\n  port: 8080") {
+		t.Errorf("Expected fold limited to 2 segments, got:\n%s", result)
+	}
+}
+
+func TestEncodeArrayWithNestedFields(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"id": float64(1), "meta": map[string]interface{}{"a": float64(1)}},
+			map[string]interface{}{"id": float64(2), "meta": map[string]interface{}{"a": float64(2)}},
+		},
+	}
+
+	result, err := Encode(data, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := `items[2]:
+  - id: 1
+    meta:
+      a: 1
+  - id: 2
+    meta:
+      a: 2`
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestEncodeSparseTabular(t *testing.T) {
+	data := []interface{}{
+		map[string]interface{}{"id": int64(1), "name": "a"},
+		map[string]interface{}{"id": int64(2)},
+		map[string]interface{}{"id": int64(3), "email": "c@d"},
+	}
+
+	opts := DefaultOptions()
+	opts.SparseTabular = true
+
+	result, err := Encode(data, opts)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "[3]{email,id,name}:\n  ,1,a\n  ,2,\n  c@d,3,"
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+
+	decoded, err := DecodeWithOptions(result, opts)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, data) {
+		t.Errorf("Round trip mismatch: got %#v", decoded)
+	}
+
+	// Outside sparse tables an empty cell is an empty string
+	decoded, err = Decode("[2]{id,name}:\n  1,\n  2,b")
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	expectedRows := []interface{}{
+		map[string]interface{}{"id": int64(1), "name": ""},
+		map[string]interface{}{"id": int64(2), "name": "b"},
+	}
+	if !reflect.DeepEqual(decoded, expectedRows) {
+		t.Errorf("Expected %#v, got %#v", expectedRows, decoded)
+	}
+
+	opts.SparseFill = SparseFillNull
+	result, err = Encode(data, opts)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !contains(result, "null,2,null") {
+		t.Errorf("Expected null-filled row, got:\n%s", result)
+	}
+}
+
+func TestEncodeStyleOptions(t *testing.T) {
+	data := map[string]interface{}{
+		"name": "Ada",
+		"tags": []interface{}{"a", "b"},
+		"user": map[string]interface{}{"id": float64(1)},
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(*Options)
+		expected string
+	}{
+		{"length marker", func(o *Options) { o.LengthMarker = true }, "name: Ada\ntags[#2]: a,b\nuser:\n  id: 1"},
+		{"omit lengths", func(o *Options) { o.OmitLengths = true }, "name: Ada\ntags[]: a,b\nuser:\n  id: 1"},
+		{"blank lines all", func(o *Options) { o.BlankLines = BlankLinesAll }, "name: Ada\n\ntags[2]: a,b\n\nuser:\n  id: 1"},
+		{"blank lines blocks", func(o *Options) { o.BlankLines = BlankLinesBlocks }, "name: Ada\ntags[2]: a,b\n\nuser:\n  id: 1"},
+		{"always quote", func(o *Options) { o.Quoting = QuoteAlways }, "name: \"Ada\"\ntags[2]: \"a\",\"b\"\nuser:\n  id: 1"},
+		{"trailing newline", func(o *Options) { o.TrailingNewline = true }, "name: Ada\ntags[2]: a,b\nuser:\n  id: 1\n"},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		tt.modify(&opts)
+
+		result, err := Encode(data, opts)
+		if err != nil {
+			t.Fatalf("%s: Encode failed: %v", tt.name, err)
+		}
+		if result != tt.expected {
+			t.Errorf("%s:\nExpected:\n%q\nGot:\n%q", tt.name, tt.expected, result)
+		}
+
+		decoded, err := Decode(result)
+		if err != nil {
+			t.Fatalf("%s: Decode failed: %v", tt.name, err)
+		}
+		if decoded.(map[string]interface{})["name"] != "Ada" {
+			t.Errorf("%s: round trip lost name, got %v", tt.name, decoded)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && (s[:len(substr)] == substr || s[len(s)-len(substr):] == substr || containsMiddle(s, substr)))
 }